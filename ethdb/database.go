@@ -15,6 +15,8 @@ import (
 	"awesomeProject1/goleveldb/leveldb/filter"
 	"awesomeProject1/goleveldb/leveldb/iterator"
 	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/util"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/log"
 )
 
@@ -39,6 +41,13 @@ type LDBDatabase struct {
 }
 
 func NewLDBDatabase(file string, cache int, handles int) (*LDBDatabase, error) {
+	return NewLDBDatabaseWithRouter(file, cache, handles, nil)
+}
+
+// NewLDBDatabaseWithRouter opens the database with the given key router, so
+// Put/Get/Has/Delete pick the primary or secondary tree by themselves, see
+// RawdbKeyRouter.
+func NewLDBDatabaseWithRouter(file string, cache int, handles int, router func(key []byte) opt.Tree) (*LDBDatabase, error) {
 
 	// Ensure we have some minimal caching and file guarantees
 	if cache < 16 {
@@ -57,6 +66,7 @@ func NewLDBDatabase(file string, cache int, handles int) (*LDBDatabase, error) {
 		Compression:            opt.NoCompression,
 		//ReadOnly:true,
 		DisableBlockCache: true,
+		KeyRouter:         router,
 	})
 	if _, corrupted := err.(*errors.ErrCorrupted); corrupted {
 		db, err = leveldb.RecoverFile(file, nil)
@@ -68,8 +78,9 @@ func NewLDBDatabase(file string, cache int, handles int) (*LDBDatabase, error) {
 
 	fmt.Println("-------------------------------------------open success")
 	return &LDBDatabase{
-		fn: file, // 文件名
-		db: db,   // 数据库对象
+		fn:  file, // 文件名
+		db:  db,   // 数据库对象
+		log: log.New("database", file),
 	}, nil
 }
 func NewLDBDatabase2(file string, cache int, handles int) (*LDBDatabase, error) {
@@ -100,8 +111,9 @@ func NewLDBDatabase2(file string, cache int, handles int) (*LDBDatabase, error)
 		return nil, err
 	}
 	return &LDBDatabase{
-		fn: file, // 文件名
-		db: db,   // 数据库对象
+		fn:  file, // 文件名
+		db:  db,   // 数据库对象
+		log: log.New("database", file),
 	}, nil
 }
 
@@ -152,6 +164,10 @@ func (db *LDBDatabase) Has(key []byte) (bool, error) {
 	return db.db.Has(key, nil)
 }
 
+func (db *LDBDatabase) Has_s(key []byte) (bool, error) {
+	return db.db.Has_s(key, nil)
+}
+
 // Get returns the given key if it's present.
 var (
 	Ti    time.Time
@@ -219,10 +235,59 @@ func (db *LDBDatabase) Delete(key []byte) error {
 	return db.db.Delete(key, nil)
 }
 
+// Delete_s deletes the key from the secondary tree
+func (db *LDBDatabase) Delete_s(key []byte) error {
+	// Measure the database delete latency, if requested
+	if db.delTimer != nil {
+		defer db.delTimer.UpdateSince(time.Now())
+	}
+	// Execute the actual operation
+	return db.db.Delete_s(key, nil)
+}
+
 func (db *LDBDatabase) NewIterator() iterator.Iterator {
 	return db.db.NewIterator(nil, nil)
 }
 
+func (db *LDBDatabase) NewIterator_s() iterator.Iterator {
+	return db.db.NewIterator_s(nil, nil)
+}
+
+// MigrateRange moves the keys in [start, limit) from one tree to the other
+// while the database stays open, see leveldb.DB.MigrateRangeFunc. A nil
+// start or limit leaves that side of the range open. The progress is logged
+// every few seconds, and passed to progress after each chunk if not nil.
+func (db *LDBDatabase) MigrateRange(start, limit []byte, from, to opt.Tree, progress func(moved int, last []byte)) error {
+	var (
+		begin  = time.Now()
+		logged = begin
+		total  int
+	)
+	db.log.Info("Migrating database range", "from", from, "to", to, "start", fmt.Sprintf("%x", start), "limit", fmt.Sprintf("%x", limit))
+	err := db.db.MigrateRangeFunc(util.Range{Start: start, Limit: limit}, from, to, func(moved int, last []byte) {
+		total = moved
+		if progress != nil {
+			progress(moved, last)
+		}
+		if time.Since(logged) > 8*time.Second {
+			db.log.Info("Migrating database range", "from", from, "to", to, "moved", moved, "last", fmt.Sprintf("%x", last), "elapsed", common.PrettyDuration(time.Since(begin)))
+			logged = time.Now()
+		}
+	})
+	if err != nil {
+		db.log.Error("Database range migration failed", "moved", total, "err", err)
+		return err
+	}
+	db.log.Info("Migrated database range", "from", from, "to", to, "moved", total, "elapsed", common.PrettyDuration(time.Since(begin)))
+	return nil
+}
+
+// Ancient retrieves item number of the given kind from the freezer, which
+// holds the canonical blocks moved out of the LSM.
+func (db *LDBDatabase) Ancient(kind string, number uint64) ([]byte, error) {
+	return db.db.Ancient(kind, number)
+}
+
 func (db *LDBDatabase) Close() {
 	// Stop the metrics collection to avoid internal database races
 	db.quitLock.Lock()
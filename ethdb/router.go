@@ -0,0 +1,46 @@
+package myethdb
+
+import (
+	"awesomeProject1/goleveldb/leveldb/opt"
+)
+
+// Key lengths of the geth rawdb chain data schema, prefix included.
+const (
+	hashLen        = 32
+	numLen         = 8
+	headerKeyLen   = 1 + numLen + hashLen     // h + num + hash, b/r likewise
+	headerTDKeyLen = headerKeyLen + 1         // h + num + hash + t
+	headerHashLen  = 1 + numLen + 1           // h + num + n
+	hashKeyLen     = 1 + hashLen              // H/l + hash
+	bloomBitsLen   = 1 + 2 + numLen + hashLen // B + bit + section + hash
+)
+
+// RawdbKeyRouter is an opt.Options.KeyRouter matching the geth rawdb schema.
+// Chain data (headers, canonical hashes, bodies, receipts, tx lookups and
+// bloom bits) goes to the secondary tree, state trie nodes, snapshots and
+// metadata stay in the primary tree.
+func RawdbKeyRouter(key []byte) opt.Tree {
+	if len(key) == 0 {
+		return opt.PrimaryTree
+	}
+	switch key[0] {
+	case 'h':
+		switch len(key) {
+		case headerKeyLen, headerTDKeyLen, headerHashLen:
+			return opt.SecondaryTree
+		}
+	case 'b', 'r':
+		if len(key) == headerKeyLen {
+			return opt.SecondaryTree
+		}
+	case 'H', 'l':
+		if len(key) == hashKeyLen {
+			return opt.SecondaryTree
+		}
+	case 'B':
+		if len(key) == bloomBitsLen {
+			return opt.SecondaryTree
+		}
+	}
+	return opt.PrimaryTree
+}
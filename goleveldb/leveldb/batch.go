@@ -9,6 +9,7 @@ package leveldb
 import (
 	"awesomeProject1/goleveldb/leveldb/errors"
 	"awesomeProject1/goleveldb/leveldb/memdb"
+	"awesomeProject1/goleveldb/leveldb/opt"
 	"awesomeProject1/goleveldb/leveldb/storage"
 	"encoding/binary"
 	"fmt"
@@ -36,12 +37,28 @@ const (
 	batchBufioSize = 16
 )
 
+// batchTagSecondary is or-ed into the key type of a journal record that
+// belongs to the secondary tree. Only TreeBatch records carry the tag.
+const batchTagSecondary = 0x80
+
 // BatchReplay wraps basic batch operations.batch作为数据库操作的最小执行单元
 type BatchReplay interface {
 	Put(key, value []byte)
 	Delete(key []byte)
 }
 
+// BatchRangeReplay is a BatchReplay that also replays range deletions.
+type BatchRangeReplay interface {
+	BatchReplay
+	DeleteRange(start, limit []byte)
+}
+
+// BatchMergeReplay is a BatchReplay that also replays merge operands.
+type BatchMergeReplay interface {
+	BatchReplay
+	Merge(key, operand []byte)
+}
+
 type batchIndex struct {
 	keyType            keyType //插入还是删除
 	keyPos, keyLen     int     //K长度和内容
@@ -78,6 +95,64 @@ type Batch_s struct {
 	Batch
 }
 
+// Tree selects one of the two LSM trees of a DB, see opt.Tree.
+type Tree = opt.Tree
+
+const (
+	PrimaryTree   = opt.PrimaryTree
+	SecondaryTree = opt.SecondaryTree
+)
+
+// TreeBatch is a write batch spanning both trees. Each record is tagged with
+// its target tree, and the whole batch is committed atomically under one
+// sequence range, see DB.WriteTree.
+type TreeBatch struct {
+	primary   Batch
+	secondary Batch
+}
+
+func (b *TreeBatch) batch(tree Tree) *Batch {
+	if tree == SecondaryTree {
+		return &b.secondary
+	}
+	return &b.primary
+}
+
+// Put appends 'put operation' of the given key/value pair to the batch,
+// targeting the given tree.
+// It is safe to modify the contents of the argument after Put returns but not
+// before.
+func (b *TreeBatch) Put(tree Tree, key, value []byte) {
+	b.batch(tree).appendRec(keyTypeVal, key, value)
+}
+
+// Delete appends 'delete operation' of the given key to the batch, targeting
+// the given tree.
+// It is safe to modify the contents of the argument after Delete returns but
+// not before.
+func (b *TreeBatch) Delete(tree Tree, key []byte) {
+	b.batch(tree).appendRec(keyTypeDel, key, nil)
+}
+
+// DeleteRange appends 'range delete operation' of the keys from start,
+// inclusive, up to limit, exclusive, to the batch, targeting the given tree.
+// It is safe to modify the contents of the arguments after DeleteRange
+// returns but not before.
+func (b *TreeBatch) DeleteRange(tree Tree, start, limit []byte) {
+	b.batch(tree).appendRec(keyTypeRangeDel, start, limit)
+}
+
+// Len returns number of records in the batch.
+func (b *TreeBatch) Len() int {
+	return b.primary.Len() + b.secondary.Len()
+}
+
+// Reset resets the batch.
+func (b *TreeBatch) Reset() {
+	b.primary.Reset()
+	b.secondary.Reset()
+}
+
 func (b *Batch) grow(n int) {
 	o := len(b.data)
 	if cap(b.data)-o < n {
@@ -93,7 +168,7 @@ func (b *Batch) grow(n int) {
 
 func (b *Batch) appendRec(kt keyType, key, value []byte) {
 	n := 1 + binary.MaxVarintLen32 + len(key)
-	if kt == keyTypeVal {
+	if kt.hasValue() {
 		n += binary.MaxVarintLen32 + len(value)
 	}
 	b.grow(n)
@@ -106,7 +181,7 @@ func (b *Batch) appendRec(kt keyType, key, value []byte) {
 	index.keyPos = o
 	index.keyLen = len(key)
 	o += copy(data[o:], key)
-	if kt == keyTypeVal {
+	if kt.hasValue() {
 		o += binary.PutUvarint(data[o:], uint64(len(value)))
 		index.valuePos = o
 		index.valueLen = len(value)
@@ -131,6 +206,40 @@ func (b *Batch) Delete(key []byte) {
 	b.appendRec(keyTypeDel, key, nil)
 }
 
+// DeleteRange appends 'range delete operation' of the keys from start,
+// inclusive, up to limit, exclusive, to the batch. The deletion covers the
+// keys written before it, including those written earlier in the batch.
+// It is safe to modify the contents of the arguments after DeleteRange
+// returns but not before.
+func (b *Batch) DeleteRange(start, limit []byte) {
+	b.appendRec(keyTypeRangeDel, start, limit)
+}
+
+// Merge appends 'merge operation' of the given key/operand pair to the
+// batch, see DB.Merge.
+// It is safe to modify the contents of the arguments after Merge returns but
+// not before.
+func (b *Batch) Merge(key, operand []byte) {
+	b.appendRec(keyTypeMerge, key, operand)
+}
+
+func (b *Batch) hasRangeDel() bool {
+	return b.hasKeyType(keyTypeRangeDel)
+}
+
+func (b *Batch) hasMerge() bool {
+	return b.hasKeyType(keyTypeMerge)
+}
+
+func (b *Batch) hasKeyType(kt keyType) bool {
+	for _, index := range b.index {
+		if index.keyType == kt {
+			return true
+		}
+	}
+	return false
+}
+
 // Dump dumps batch contents. The returned slice can be loaded into the
 // batch using Load method.
 // The returned slice is not its own copy, so the contents should not be
@@ -147,7 +256,10 @@ func (b *Batch) Load(data []byte) error {
 	return b.decode(data, -1)
 }
 
-// Replay replays batch contents.
+// Replay replays batch contents. Range deletions are replayed only if r is
+// a BatchRangeReplay, ErrRangeDelUnsupported is returned otherwise. Merge
+// operands are replayed only if r is a BatchMergeReplay,
+// ErrMergeUnsupported is returned otherwise.
 func (b *Batch) Replay(r BatchReplay) error {
 	for _, index := range b.index {
 		switch index.keyType {
@@ -155,6 +267,18 @@ func (b *Batch) Replay(r BatchReplay) error {
 			r.Put(index.k(b.data), index.v(b.data))
 		case keyTypeDel:
 			r.Delete(index.k(b.data))
+		case keyTypeRangeDel:
+			rr, ok := r.(BatchRangeReplay)
+			if !ok {
+				return ErrRangeDelUnsupported
+			}
+			rr.DeleteRange(index.k(b.data), index.v(b.data))
+		case keyTypeMerge:
+			mr, ok := r.(BatchMergeReplay)
+			if !ok {
+				return ErrMergeUnsupported
+			}
+			mr.Merge(index.k(b.data), index.v(b.data))
 		}
 	}
 	return nil
@@ -222,6 +346,13 @@ func (b *Batch) decode(data []byte, expectedLen int) error {
 func (b *Batch) putMem(seq uint64, mdb *memdb.DB) error {
 	var ik []byte
 	for i, index := range b.index {
+		if index.keyType == keyTypeRangeDel {
+			ik = makeInternalKey(ik, index.k(b.data), seq+uint64(i), keyTypeDel)
+			if err := mdb.PutRangeTombstone(ik, index.v(b.data)); err != nil {
+				return err
+			}
+			continue
+		}
 		ik = makeInternalKey(ik, index.k(b.data), seq+uint64(i), index.keyType)
 		//mdb *memdb.DB调用memdb中定义的public Put方法
 		//log.Println(ik,index.k(b.data),index.v(b.data))
@@ -238,6 +369,13 @@ func (b *Batch) putMem_s(seq uint64, mdb *memdb.DBs) error {
 	var ik []byte
 	//fmt.Println("开始遍历batch，给每个数据加上8bytes的后缀")
 	for i, index := range b.index {
+		if index.keyType == keyTypeRangeDel {
+			ik = makeInternalKey(ik, index.k(b.data), seq+uint64(i), keyTypeDel)
+			if err := mdb.PutRangeTombstone_s(ik, index.v(b.data)); err != nil {
+				return err
+			}
+			continue
+		}
 		ik = makeInternalKey(ik, index.k(b.data), seq+uint64(i), index.keyType)
 		//mdb *memdb.DB调用memdb中定义的public Put方法
 		//fmt.Println("每Add一个后缀，就Put进mem中")
@@ -251,6 +389,9 @@ func (b *Batch) putMem_s(seq uint64, mdb *memdb.DBs) error {
 func (b *Batch) revertMem_s(seq uint64, mdb *memdb.DBs) error {
 	var ik []byte
 	for i, index := range b.index {
+		if index.keyType == keyTypeRangeDel {
+			continue
+		}
 		ik = makeInternalKey(ik, index.k(b.data), seq+uint64(i), index.keyType)
 		if err := mdb.Delete_s(ik); err != nil {
 			return err
@@ -261,6 +402,9 @@ func (b *Batch) revertMem_s(seq uint64, mdb *memdb.DBs) error {
 func (b *Batch) revertMem(seq uint64, mdb *memdb.DB) error {
 	var ik []byte
 	for i, index := range b.index {
+		if index.keyType == keyTypeRangeDel {
+			continue
+		}
 		ik = makeInternalKey(ik, index.k(b.data), seq+uint64(i), index.keyType)
 		if err := mdb.Delete(ik); err != nil {
 			return err
@@ -279,12 +423,25 @@ func MakeBatch(n int) *Batch {
 }
 
 func decodeBatch(data []byte, fn func(i int, index batchIndex) error) error {
+	return decodeTreeBatch(data, false, func(i int, tree Tree, index batchIndex) error {
+		return fn(i, index)
+	})
+}
+
+// decodeTreeBatch decodes batch records, when tagged is true records carrying
+// batchTagSecondary are reported as belonging to the secondary tree.
+func decodeTreeBatch(data []byte, tagged bool, fn func(i int, tree Tree, index batchIndex) error) error {
 	var index batchIndex
 	for i, o := 0, 0; o < len(data); i++ {
 		// Key type.
+		tree := PrimaryTree
 		index.keyType = keyType(data[o])
-		if index.keyType > keyTypeVal {
-			return newErrBatchCorrupted(fmt.Sprintf("bad record: invalid type %#x", uint(index.keyType)))
+		if tagged && index.keyType&batchTagSecondary != 0 {
+			tree = SecondaryTree
+			index.keyType &^= batchTagSecondary
+		}
+		if !index.keyType.validBatch() {
+			return newErrBatchCorrupted(fmt.Sprintf("bad record: invalid type %#x", uint(data[o])))
 		}
 		o++
 
@@ -299,7 +456,7 @@ func decodeBatch(data []byte, fn func(i int, index batchIndex) error) error {
 		o += index.keyLen
 
 		// Value.
-		if index.keyType == keyTypeVal {
+		if index.keyType.hasValue() {
 			x, n = binary.Uvarint(data[o:])
 			o += n
 			if n <= 0 || o+int(x) > len(data) {
@@ -313,7 +470,7 @@ func decodeBatch(data []byte, fn func(i int, index batchIndex) error) error {
 			index.valueLen = 0
 		}
 
-		if err := fn(i, index); err != nil {
+		if err := fn(i, tree, index); err != nil {
 			return err
 		}
 	}
@@ -337,9 +494,16 @@ func decodeBatchToMem(data []byte, expectSeq uint64, mdb *memdb.DB) (seq uint64,
 		if i >= batchLen {
 			return newErrBatchCorrupted("invalid records length")
 		}
-		ik = makeInternalKey(ik, index.k(data), seq+uint64(i), index.keyType)
-		if err := mdb.Put(ik, index.v(data)); err != nil {
-			return err
+		if index.keyType == keyTypeRangeDel {
+			ik = makeInternalKey(ik, index.k(data), seq+uint64(i), keyTypeDel)
+			if err := mdb.PutRangeTombstone(ik, index.v(data)); err != nil {
+				return err
+			}
+		} else {
+			ik = makeInternalKey(ik, index.k(data), seq+uint64(i), index.keyType)
+			if err := mdb.Put(ik, index.v(data)); err != nil {
+				return err
+			}
 		}
 		decodedLen++
 		return nil
@@ -364,9 +528,59 @@ func decodeBatchToMem_s(data []byte, expectSeq uint64, mdb *memdb.DBs) (seq uint
 		if i >= batchLen {
 			return newErrBatchCorrupted("invalid records length")
 		}
-		ik = makeInternalKey(ik, index.k(data), seq+uint64(i), index.keyType)
-		if err := mdb.Put_s(ik, index.v(data)); err != nil {
-			return err
+		if index.keyType == keyTypeRangeDel {
+			ik = makeInternalKey(ik, index.k(data), seq+uint64(i), keyTypeDel)
+			if err := mdb.PutRangeTombstone_s(ik, index.v(data)); err != nil {
+				return err
+			}
+		} else {
+			ik = makeInternalKey(ik, index.k(data), seq+uint64(i), index.keyType)
+			if err := mdb.Put_s(ik, index.v(data)); err != nil {
+				return err
+			}
+		}
+		decodedLen++
+		return nil
+	})
+	if err == nil && decodedLen != batchLen {
+		err = newErrBatchCorrupted(fmt.Sprintf("invalid records length: %d vs %d", batchLen, decodedLen))
+	}
+	return
+}
+
+// decodeTreeBatchToMem replays a primary journal record into mdb, and the
+// records tagged for the secondary tree into mdbs, so that a TreeBatch is
+// recovered into both trees or neither.
+func decodeTreeBatchToMem(data []byte, expectSeq uint64, mdb *memdb.DB, mdbs *memdb.DBs) (seq uint64, batchLen int, err error) {
+	seq, batchLen, err = decodeBatchHeader(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	data = data[batchHeaderLen:]
+	var ik []byte
+	var decodedLen int
+	err = decodeTreeBatch(data, true, func(i int, tree Tree, index batchIndex) error {
+		if i >= batchLen {
+			return newErrBatchCorrupted("invalid records length")
+		}
+		kt := index.keyType
+		if kt == keyTypeRangeDel {
+			kt = keyTypeDel
+		}
+		ik = makeInternalKey(ik, index.k(data), seq+uint64(i), kt)
+		var perr error
+		switch {
+		case tree == SecondaryTree && index.keyType == keyTypeRangeDel:
+			perr = mdbs.PutRangeTombstone_s(ik, index.v(data))
+		case tree == SecondaryTree:
+			perr = mdbs.Put_s(ik, index.v(data))
+		case index.keyType == keyTypeRangeDel:
+			perr = mdb.PutRangeTombstone(ik, index.v(data))
+		default:
+			perr = mdb.Put(ik, index.v(data))
+		}
+		if perr != nil {
+			return perr
 		}
 		decodedLen++
 		return nil
@@ -415,3 +629,32 @@ func writeBatchesWithHeader(wr io.Writer, batches []*Batch, seq uint64) error {
 	}
 	return nil
 }
+
+// writeTreeBatchWithHeader writes both halves of b as a single journal
+// record, the secondary records are tagged with batchTagSecondary. The
+// primary records take seq onwards and the secondary records follow them.
+func writeTreeBatchWithHeader(wr io.Writer, b *TreeBatch, seq uint64) error {
+	if _, err := wr.Write(encodeBatchHeader(nil, seq, b.Len())); err != nil {
+		return err
+	}
+	if _, err := wr.Write(b.primary.data); err != nil {
+		return err
+	}
+	var (
+		buf [binary.MaxVarintLen32]byte
+		rec []byte
+	)
+	for _, index := range b.secondary.index {
+		rec = append(rec[:0], byte(index.keyType)|batchTagSecondary)
+		rec = append(rec, buf[:binary.PutUvarint(buf[:], uint64(index.keyLen))]...)
+		rec = append(rec, index.k(b.secondary.data)...)
+		if index.keyType.hasValue() {
+			rec = append(rec, buf[:binary.PutUvarint(buf[:], uint64(index.valueLen))]...)
+			rec = append(rec, index.v(b.secondary.data)...)
+		}
+		if _, err := wr.Write(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -12,6 +12,8 @@ import (
 	"testing"
 	"testing/quick"
 
+	"awesomeProject1/goleveldb/leveldb/comparer"
+	"awesomeProject1/goleveldb/leveldb/memdb"
 	"awesomeProject1/goleveldb/leveldb/testutil"
 )
 
@@ -145,3 +147,55 @@ func TestBatch(t *testing.T) {
 	}
 	t.Logf("length=%d internalLen=%d", len(kvs), internalLen)
 }
+
+func TestTreeBatchJournal(t *testing.T) {
+	b := new(TreeBatch)
+	b.Put(PrimaryTree, []byte("k1"), []byte("v1"))
+	b.Put(SecondaryTree, []byte("k2"), []byte("v2"))
+	b.Delete(SecondaryTree, []byte("k3"))
+	b.Delete(PrimaryTree, []byte("k4"))
+	if b.Len() != 4 {
+		t.Fatalf("TreeBatch.Len: want 4, got %d", b.Len())
+	}
+
+	buf := new(bytes.Buffer)
+	if err := writeTreeBatchWithHeader(buf, b, 10); err != nil {
+		t.Fatal("writeTreeBatchWithHeader: ", err)
+	}
+	icmp := &iComparer{comparer.DefaultComparer}
+	if _, _, err := decodeBatchToMem(buf.Bytes(), 0, memdb.New(icmp, 0)); err == nil {
+		t.Error("decodeBatchToMem: want error on tagged record")
+	}
+
+	mdb := memdb.New(icmp, 0)
+	mdbs := memdb.New_s(icmp, 0)
+	seq, n, err := decodeTreeBatchToMem(buf.Bytes(), 0, mdb, mdbs)
+	if err != nil {
+		t.Fatal("decodeTreeBatchToMem: ", err)
+	}
+	if seq != 10 || n != 4 {
+		t.Errorf("decodeTreeBatchToMem: want seq=10 len=4, got seq=%d len=%d", seq, n)
+	}
+	if mdb.Len() != 2 || mdbs.Len_s() != 2 {
+		t.Fatalf("decodeTreeBatchToMem: want 2/2 records, got %d/%d", mdb.Len(), mdbs.Len_s())
+	}
+	for _, x := range []struct {
+		secondary bool
+		ikey      internalKey
+	}{
+		{false, makeInternalKey(nil, []byte("k1"), 10, keyTypeVal)},
+		{false, makeInternalKey(nil, []byte("k4"), 11, keyTypeDel)},
+		{true, makeInternalKey(nil, []byte("k2"), 12, keyTypeVal)},
+		{true, makeInternalKey(nil, []byte("k3"), 13, keyTypeDel)},
+	} {
+		var found bool
+		if x.secondary {
+			found = mdbs.Contains_s(x.ikey)
+		} else {
+			found = mdb.Contains(x.ikey)
+		}
+		if !found {
+			t.Errorf("decodeTreeBatchToMem: missing %v (secondary=%v)", x.ikey, x.secondary)
+		}
+	}
+}
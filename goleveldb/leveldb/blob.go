@@ -0,0 +1,472 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"sort"
+	"time"
+
+	"awesomeProject1/goleveldb/leveldb/cache"
+	"awesomeProject1/goleveldb/leveldb/errors"
+	"awesomeProject1/goleveldb/leveldb/storage"
+	"awesomeProject1/goleveldb/leveldb/util"
+)
+
+const (
+	// Cache namespace of the blob file readers, tables use namespace 0.
+	blobCacheNS = 1
+
+	// Interval between two blob GC runs.
+	blobGCInterval = time.Minute
+
+	// Size of the batches written by a blob file rewrite.
+	blobGCChunkSize = 1 << 20
+)
+
+var errBlobCorrupted = errors.New("leveldb: blob record corrupted")
+
+// blobPointer locates a record of a blob file. It is the value of the
+// keyTypeBlob entries of the tables.
+type blobPointer struct {
+	num    int64 // blob file number
+	offset int64
+	size   int64 // size of the whole record
+}
+
+func (p blobPointer) encode(dst []byte) []byte {
+	dst = binary.AppendUvarint(dst, uint64(p.num))
+	dst = binary.AppendUvarint(dst, uint64(p.offset))
+	return binary.AppendUvarint(dst, uint64(p.size))
+}
+
+func decodeBlobPointer(b []byte) (p blobPointer, err error) {
+	var x [3]uint64
+	for i := range x {
+		n := 0
+		if x[i], n = binary.Uvarint(b); n <= 0 {
+			return p, errors.NewErrCorrupted(storage.FileDesc{}, errors.New("leveldb: bad blob pointer"))
+		}
+		b = b[n:]
+	}
+	return blobPointer{num: int64(x[0]), offset: int64(x[1]), size: int64(x[2])}, nil
+}
+
+// A blob file is a sequence of records:
+//
+//	checksum: uint32  // crc32c of the rest of the record, little endian
+//	keyLen:   uvarint
+//	valueLen: uvarint
+//	key:      keyLen bytes, the user key
+//	value:    valueLen bytes
+//
+// The key is only there for the GC, to find whether the value is still live.
+const blobHeaderLen = 4
+
+func appendBlobRecord(dst, ukey, value []byte) []byte {
+	start := len(dst)
+	dst = append(dst, make([]byte, blobHeaderLen)...)
+	dst = binary.AppendUvarint(dst, uint64(len(ukey)))
+	dst = binary.AppendUvarint(dst, uint64(len(value)))
+	dst = append(dst, ukey...)
+	dst = append(dst, value...)
+	binary.LittleEndian.PutUint32(dst[start:], util.NewCRC(dst[start+blobHeaderLen:]).Value())
+	return dst
+}
+
+func decodeBlobRecord(rec []byte) (ukey, value []byte, err error) {
+	if len(rec) < blobHeaderLen {
+		return nil, nil, errBlobCorrupted
+	}
+	if binary.LittleEndian.Uint32(rec) != util.NewCRC(rec[blobHeaderLen:]).Value() {
+		return nil, nil, errBlobCorrupted
+	}
+	b := rec[blobHeaderLen:]
+	klen, n := binary.Uvarint(b)
+	if n <= 0 {
+		return nil, nil, errBlobCorrupted
+	}
+	b = b[n:]
+	vlen, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) != klen+vlen {
+		return nil, nil, errBlobCorrupted
+	}
+	b = b[n:]
+	return b[:klen], b[klen:], nil
+}
+
+// readBlobRecord reads the next record of a blob file from r, with at most
+// max bytes left, and returns it whole.
+func readBlobRecord(r *bufio.Reader, max int64) ([]byte, error) {
+	var rec [blobHeaderLen + 2*binary.MaxVarintLen64]byte
+	if _, err := io.ReadFull(r, rec[:blobHeaderLen]); err != nil {
+		return nil, err
+	}
+	klen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	vlen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	head := binary.AppendUvarint(binary.AppendUvarint(rec[:blobHeaderLen], klen), vlen)
+	if klen > uint64(max) || vlen > uint64(max) || int64(len(head))+int64(klen+vlen) > max {
+		return nil, errBlobCorrupted
+	}
+	buf := make([]byte, len(head)+int(klen+vlen))
+	copy(buf, head)
+	if _, err := io.ReadFull(r, buf[len(head):]); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// blobWriter appends records to a blob file.
+type blobWriter struct {
+	fd     storage.FileDesc
+	w      storage.Writer
+	offset int64
+	buf    []byte
+}
+
+func (w *blobWriter) append(ukey, value []byte) (blobPointer, error) {
+	w.buf = appendBlobRecord(w.buf[:0], ukey, value)
+	if _, err := w.w.Write(w.buf); err != nil {
+		return blobPointer{}, err
+	}
+	p := blobPointer{num: w.fd.Num, offset: w.offset, size: int64(len(w.buf))}
+	w.offset += p.size
+	return p, nil
+}
+
+func (w *blobWriter) close() {
+	if w.w != nil {
+		w.w.Close()
+		w.w = nil
+	}
+}
+
+// Creates the blob file of the given table. It stays pending, and is
+// skipped by the GC, until the table is committed or dropped.
+func (t *tOps) createBlob(tableNum int64) (*blobWriter, error) {
+	fd := storage.FileDesc{Type: storage.TypeBlob, Num: t.s.allocFileNum()}
+	t.blobMu.Lock()
+	t.blobPending[tableNum] = fd.Num
+	t.blobMu.Unlock()
+	fw, err := t.s.stor.Create(fd)
+	if err != nil {
+		t.blobMu.Lock()
+		delete(t.blobPending, tableNum)
+		t.blobMu.Unlock()
+		return nil, err
+	}
+	return &blobWriter{fd: fd, w: limitedWriter{fw, t.limiter_s}}, nil
+}
+
+// Marks the blob files of the added tables of the record as committed.
+func (t *tOps) commitBlobs(r *sessionRecord) {
+	t.blobMu.Lock()
+	defer t.blobMu.Unlock()
+	for _, at := range r.addedTabless {
+		delete(t.blobPending, at.num)
+	}
+}
+
+// Removes the blob file of the given table, if the table isn't committed.
+func (t *tOps) dropBlob(tableNum int64) {
+	t.blobMu.Lock()
+	num, ok := t.blobPending[tableNum]
+	delete(t.blobPending, tableNum)
+	t.blobMu.Unlock()
+	if ok {
+		t.s.stor.Remove(storage.FileDesc{Type: storage.TypeBlob, Num: num})
+	}
+}
+
+// Returns true if the blob file is of a table not committed yet.
+func (t *tOps) blobPendingFile(num int64) bool {
+	t.blobMu.Lock()
+	defer t.blobMu.Unlock()
+	for _, bnum := range t.blobPending {
+		if bnum == num {
+			return true
+		}
+	}
+	return false
+}
+
+type blobReader struct {
+	storage.Reader
+}
+
+func (r *blobReader) Release() {
+	r.Close()
+}
+
+// Opens blob file. It returns a cache handle, which should be released
+// after use.
+func (t *tOps) openBlob(fd storage.FileDesc) (ch *cache.Handle, err error) {
+	ch = t.cache.Get(blobCacheNS, uint64(fd.Num), func() (size int, value cache.Value) {
+		var r storage.Reader
+		r, err = t.s.stor.Open(fd)
+		if err != nil {
+			return 0, nil
+		}
+		return 1, &blobReader{r}
+	})
+	if ch == nil && err == nil {
+		err = ErrClosed
+	}
+	return
+}
+
+// Returns the value pointed by enc, the value of a keyTypeBlob entry.
+func (t *tOps) readBlob(enc []byte) ([]byte, error) {
+	p, err := decodeBlobPointer(enc)
+	if err != nil {
+		return nil, err
+	}
+	return t.readBlobAt(p)
+}
+
+func (t *tOps) readBlobAt(p blobPointer) ([]byte, error) {
+	fd := storage.FileDesc{Type: storage.TypeBlob, Num: p.num}
+	ch, err := t.openBlob(fd)
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Release()
+	rec := make([]byte, p.size)
+	if n, err := ch.Value().(*blobReader).ReadAt(rec, p.offset); n < len(rec) {
+		if err == nil || err == io.EOF {
+			err = errBlobCorrupted
+		}
+		return nil, errors.NewErrCorrupted(fd, err)
+	}
+	_, value, err := decodeBlobRecord(rec)
+	if err != nil {
+		return nil, errors.NewErrCorrupted(fd, err)
+	}
+	return value, nil
+}
+
+// Removes blob file from persistent storage. It waits until no one use the
+// file.
+func (t *tOps) removeBlob(fd storage.FileDesc) {
+	t.cache.Delete(blobCacheNS, uint64(fd.Num), func() {
+		if err := t.s.stor.Remove(fd); err != nil {
+			t.s.logf("blob@remove removing @%d %q", fd.Num, err)
+		} else {
+			t.s.logf("blob@remove removed @%d", fd.Num)
+		}
+	})
+}
+
+// Marks the numbers of the blob files as used. The numbers of the blob files
+// only reach the manifest with their tables, those of files left by a crash
+// mustn't be reused.
+func (db *DB) markBlobFiles() error {
+	fds, err := db.s.stor.List(storage.TypeBlob)
+	if err != nil {
+		return err
+	}
+	for _, fd := range fds {
+		db.s.markFileNum(fd.Num)
+	}
+	return nil
+}
+
+// Returns true if the latest value of ukey in the secondary tree is the
+// blob record at p.
+func (db *DB) blobLive(ukey []byte, p blobPointer) (bool, error) {
+	value, blob, err := db.getRaw_s(nil, nil, ukey, db.getSeq(), nil)
+	if err == ErrNotFound {
+		return false, nil
+	} else if err != nil || !blob {
+		return false, err
+	}
+	q, err := decodeBlobPointer(value)
+	return q == p, err
+}
+
+type blobEntry struct {
+	ukey []byte
+	p    blobPointer
+}
+
+// blobGC removes the blob files no snapshot can read anymore and rewrites
+// the files whose ratio of dead values reaches BlobGCRatio. It returns the
+// number of files rewritten.
+//
+// A rewritten file is kept until every snapshot older than the rewrite is
+// released, since those may still read it.
+func (db *DB) blobGC() (int, error) {
+	db.removeObsoleteBlobs()
+	fds, err := db.s.stor.List(storage.TypeBlob)
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(fds, func(i, j int) bool { return fds[i].Num < fds[j].Num })
+	var n int
+	for _, fd := range fds {
+		db.blobMu.Lock()
+		_, obsolete := db.blobObsolete[fd.Num]
+		db.blobMu.Unlock()
+		if obsolete || db.s.tops.blobPendingFile(fd.Num) {
+			continue
+		}
+		if err := db.ok(); err != nil {
+			return n, err
+		}
+		rewritten, err := db.collectBlob(fd)
+		if err != nil {
+			return n, err
+		}
+		if rewritten {
+			n++
+		}
+	}
+	db.removeObsoleteBlobs()
+	return n, nil
+}
+
+// Removes the rewritten blob files no snapshot can read anymore.
+func (db *DB) removeObsoleteBlobs() {
+	minSeq := db.minSeq()
+	db.blobMu.Lock()
+	defer db.blobMu.Unlock()
+	for num, seq := range db.blobObsolete {
+		if seq <= minSeq {
+			db.s.tops.removeBlob(storage.FileDesc{Type: storage.TypeBlob, Num: num})
+			delete(db.blobObsolete, num)
+		}
+	}
+}
+
+// Rewrites the live values of the blob file if enough of it is dead, and
+// returns true if it did.
+func (db *DB) collectBlob(fd storage.FileDesc) (bool, error) {
+	ch, err := db.s.tops.openBlob(fd)
+	if err != nil {
+		return false, err
+	}
+	defer ch.Release()
+	// The reader is shared, it is only read with ReadAt.
+	r := ch.Value().(*blobReader)
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false, err
+	}
+
+	// A corrupted or truncated tail is left by a crash while the file was
+	// written; no table points there, so it counts as dead.
+	var (
+		live     []blobEntry
+		liveSize int64
+		br       = bufio.NewReader(io.NewSectionReader(r, 0, size))
+	)
+	for offset := int64(0); offset < size; {
+		rec, err := readBlobRecord(br, size-offset)
+		var ukey []byte
+		if err == nil {
+			ukey, _, err = decodeBlobRecord(rec)
+		}
+		if err != nil {
+			db.logf("blob@gc corrupted @%d O·%d E·%q", fd.Num, offset, err)
+			break
+		}
+		p := blobPointer{num: fd.Num, offset: offset, size: int64(len(rec))}
+		ok, err := db.blobLive(ukey, p)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			live = append(live, blobEntry{ukey: ukey, p: p})
+			liveSize += p.size
+		}
+		offset += p.size
+	}
+	if size > 0 && float64(size-liveSize)/float64(size) < db.s.o.GetBlobGCRatio() {
+		return false, nil
+	}
+
+	start := time.Now()
+	if err := db.rewriteBlobs(live); err != nil {
+		return false, err
+	}
+	db.blobMu.Lock()
+	db.blobObsolete[fd.Num] = db.getSeq()
+	db.blobMu.Unlock()
+	db.logf("blob@gc rewritten @%d N·%d S·%s T·%v", fd.Num, len(live), shortenb(int(liveSize)), time.Since(start))
+	return true, nil
+}
+
+// Writes the values of the entries again, unless they were overwritten in
+// the meantime.
+func (db *DB) rewriteBlobs(live []blobEntry) error {
+	sync := !db.s.o.GetNoSync()
+	for len(live) > 0 {
+		select {
+		case db.writeLockC <- struct{}{}:
+		case err := <-db.compPerErrC:
+			return err
+		case <-db.closeC:
+			return ErrClosed
+		}
+
+		// Liveness is checked again with the writer locked, so a newer
+		// value can't be overwritten.
+		b := new(Batch)
+		for len(live) > 0 && b.internalLen < blobGCChunkSize {
+			e := live[0]
+			live = live[1:]
+			ok, err := db.blobLive(e.ukey, e.p)
+			var value []byte
+			if err == nil && ok {
+				value, err = db.s.tops.readBlobAt(e.p)
+			}
+			if err != nil {
+				<-db.writeLockC
+				return err
+			}
+			if ok {
+				b.Put(e.ukey, value)
+			}
+		}
+		if b.Len() == 0 {
+			<-db.writeLockC
+			continue
+		}
+		if err := db.writeLocked_s(b, nil, false, sync); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *DB) blobGCLoop() {
+	defer db.closeW.Done()
+
+	for {
+		select {
+		case <-time.After(blobGCInterval):
+		case <-db.closeC:
+			return
+		}
+
+		start := time.Now()
+		n, err := db.blobGC()
+		if err != nil && err != ErrClosed {
+			db.logf("blob@gc error E·%q", err)
+		} else if n > 0 {
+			db.logf("blob@gc done N·%d T·%v", n, time.Since(start))
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+)
+
+// TTLTimestampLen is the length of the timestamp suffix of the values
+// expired by NewTTLFilter.
+const TTLTimestampLen = 8
+
+// AppendTTLTimestamp appends t, the write time of value, to it as the suffix
+// read by NewTTLFilter.
+func AppendTTLTimestamp(value []byte, t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(value, uint64(t.Unix()))
+}
+
+// SplitTTLTimestamp returns value without its timestamp suffix, and the
+// timestamp. It returns false if value is too short to have one.
+func SplitTTLTimestamp(value []byte) ([]byte, time.Time, bool) {
+	n := len(value) - TTLTimestampLen
+	if n < 0 {
+		return value, time.Time{}, false
+	}
+	return value[:n], time.Unix(int64(binary.BigEndian.Uint64(value[n:])), 0), true
+}
+
+// NewTTLFilter returns a CompactionFilter removing the keys whose value has
+// a timestamp suffix, written with AppendTTLTimestamp, older than ttl. Only
+// keys starting with one of prefixes expire, all keys do if there are none.
+// Values too short to have a timestamp are kept.
+func NewTTLFilter(ttl time.Duration, prefixes ...[]byte) opt.CompactionFilter {
+	return func(level int, key, value []byte, tree opt.Tree) (opt.CompactionDecision, []byte) {
+		if len(prefixes) > 0 && !hasAnyPrefix(key, prefixes) {
+			return opt.CompactionKeep, nil
+		}
+		if _, ts, ok := SplitTTLTimestamp(value); ok && time.Since(ts) > ttl {
+			return opt.CompactionRemove, nil
+		}
+		return opt.CompactionKeep, nil
+	}
+}
+
+func hasAnyPrefix(key []byte, prefixes [][]byte) bool {
+	for _, prefix := range prefixes {
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterEntry passes the entry, the newest one of its key in the compaction,
+// to the CompactionFilter if no snapshot sees it. It returns true if the
+// filter removed or changed the entry, what replaces it is appended to the
+// table then.
+func (b *tableCompactionBuilder) filterEntry(tree opt.Tree, ukey []byte, seq uint64, kt keyType, value []byte) (bool, error) {
+	filter := b.s.o.GetCompactionFilter()
+	if filter == nil || seq <= b.filterSeq || (kt != keyTypeVal && kt != keyTypeBlob) {
+		return false, nil
+	}
+	if kt == keyTypeBlob {
+		var err error
+		if value, err = b.s.tops.readBlob(value); err != nil {
+			return false, err
+		}
+	}
+	appendKV := b.appendKV
+	if tree == opt.SecondaryTree {
+		appendKV = b.appendKV_s
+	}
+	switch decision, newValue := filter(b.c.sourceLevel, ukey, value, tree); decision {
+	case opt.CompactionRemove:
+		// The older entries of the compaction are dropped along, unless a
+		// snapshot sees them.
+		var base bool
+		if tree == opt.SecondaryTree {
+			base = b.c.baseLevelForKey_s(ukey)
+		} else {
+			base = b.c.baseLevelForKey(ukey)
+		}
+		if base && seq <= b.minSeq {
+			b.dropCnt++
+			return true, nil
+		}
+		// Deletes the older values left.
+		return true, appendKV(makeInternalKey(nil, ukey, seq, keyTypeDel), nil)
+	case opt.CompactionChange:
+		return true, appendKV(makeInternalKey(nil, ukey, seq, keyTypeVal), newValue)
+	}
+	return false, nil
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package compression
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+var (
+	// None stores the blocks as is.
+	None Compressor = noneCompressor{}
+
+	// Snappy compresses the blocks with snappy.
+	Snappy Compressor = snappyCompressor{}
+)
+
+type noneCompressor struct{}
+
+func (noneCompressor) Type() byte   { return NoneType }
+func (noneCompressor) Name() string { return "none" }
+
+func (noneCompressor) Compress(dst, src []byte) ([]byte, error) {
+	return append(dst[:0], src...), nil
+}
+
+func (noneCompressor) Decompress(src []byte) ([]byte, error) {
+	return src, nil
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Type() byte   { return SnappyType }
+func (snappyCompressor) Name() string { return "snappy" }
+
+func (snappyCompressor) Compress(dst, src []byte) ([]byte, error) {
+	if n := snappy.MaxEncodedLen(len(src)); cap(dst) < n {
+		dst = make([]byte, n)
+	}
+	return snappy.Encode(dst[:cap(dst)], src), nil
+}
+
+func (snappyCompressor) Decompress(src []byte) ([]byte, error) {
+	return snappy.Decode(nil, src)
+}
+
+// DefaultFlateLevel is the compression level of the registered flate
+// compressor.
+const DefaultFlateLevel = flate.DefaultCompression
+
+type flateCompressor struct {
+	level   int
+	writers sync.Pool
+}
+
+// NewFlate returns a compressor deflating the blocks at the given level, from
+// flate.HuffmanOnly to flate.BestCompression. Blocks of all levels share a
+// block type.
+func NewFlate(level int) Compressor {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		panic(fmt.Sprintf("compression: invalid flate level %d", level))
+	}
+	return &flateCompressor{level: level}
+}
+
+func (c *flateCompressor) Type() byte   { return FlateType }
+func (c *flateCompressor) Name() string { return fmt.Sprintf("flate-%d", c.level) }
+
+func (c *flateCompressor) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst[:0])
+	fw, _ := c.writers.Get().(*flate.Writer)
+	if fw == nil {
+		var err error
+		if fw, err = flate.NewWriter(buf, c.level); err != nil {
+			return nil, err
+		}
+	} else {
+		fw.Reset(buf)
+	}
+	defer c.writers.Put(fw)
+	if _, err := fw.Write(src); err != nil {
+		return nil, err
+	}
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var flateReaders sync.Pool
+
+func (c *flateCompressor) Decompress(src []byte) ([]byte, error) {
+	fr, _ := flateReaders.Get().(io.ReadCloser)
+	if fr == nil {
+		fr = flate.NewReader(bytes.NewReader(src))
+	} else if err := fr.(flate.Resetter).Reset(bytes.NewReader(src), nil); err != nil {
+		return nil, err
+	}
+	defer flateReaders.Put(fr)
+	var buf bytes.Buffer
+	buf.Grow(len(src) * 2)
+	if _, err := buf.ReadFrom(fr); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package compression provides interface and implementations of the
+// 'sorted table' block compressors.
+//
+// Each compressor has a block type byte, recorded in the trailer of the
+// blocks it compressed. Readers find the compressor of a block by its type in
+// the registry, so a table may mix blocks of several compressors, e.g. after
+// the compressor of a level was changed.
+package compression
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Block types of the built-in compressors. These are part of the file format
+// and should not be changed.
+const (
+	NoneType   byte = 0
+	SnappyType byte = 1
+	FlateType  byte = 2
+)
+
+// Compressor is the block compressor.
+type Compressor interface {
+	// Type returns the block type of this compressor.
+	Type() byte
+
+	// Name returns the name of this compressor.
+	Name() string
+
+	// Compress appends the compressed src to dst[:0] and returns the
+	// result. dst is used if it has enough capacity.
+	Compress(dst, src []byte) ([]byte, error)
+
+	// Decompress decompresses src, compressed by a compressor of the same
+	// type, whatever its settings.
+	Decompress(src []byte) ([]byte, error)
+}
+
+var (
+	mu       sync.RWMutex
+	registry = map[byte]Compressor{}
+)
+
+// Register makes a compressor available to decompress the blocks of its type.
+// It panics if a compressor of that type is already registered.
+func Register(c Compressor) {
+	mu.Lock()
+	defer mu.Unlock()
+	if r, ok := registry[c.Type()]; ok {
+		panic(fmt.Sprintf("compression: block type %#x already registered by %s", c.Type(), r.Name()))
+	}
+	registry[c.Type()] = c
+}
+
+// Get returns the registered compressor of the block type, or nil if there is
+// none.
+func Get(typ byte) Compressor {
+	mu.RLock()
+	defer mu.RUnlock()
+	return registry[typ]
+}
+
+func init() {
+	Register(None)
+	Register(Snappy)
+	Register(NewFlate(DefaultFlateLevel))
+}
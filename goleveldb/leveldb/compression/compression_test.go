@@ -0,0 +1,66 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package compression
+
+import (
+	"bytes"
+	"compress/flate"
+	"testing"
+)
+
+type xorCompressor struct{}
+
+func (xorCompressor) Type() byte   { return 0x80 }
+func (xorCompressor) Name() string { return "xor" }
+
+func (xorCompressor) Compress(dst, src []byte) ([]byte, error) {
+	dst = dst[:0]
+	for _, c := range src {
+		dst = append(dst, c^0x5a)
+	}
+	return dst, nil
+}
+
+func (c xorCompressor) Decompress(src []byte) ([]byte, error) {
+	return c.Compress(nil, src)
+}
+
+func TestRoundTrip(t *testing.T) {
+	src := bytes.Repeat([]byte("leveldb block "), 1000)
+	for _, c := range []Compressor{None, Snappy, NewFlate(flate.HuffmanOnly), NewFlate(flate.BestSpeed), NewFlate(flate.BestCompression)} {
+		// Twice, to reuse the pooled writers and readers.
+		for i := 0; i < 2; i++ {
+			enc, err := c.Compress(nil, src)
+			if err != nil {
+				t.Fatalf("%s: compress: %v", c.Name(), err)
+			}
+			dec, err := Get(c.Type()).Decompress(enc)
+			if err != nil {
+				t.Fatalf("%s: decompress: %v", c.Name(), err)
+			}
+			if !bytes.Equal(dec, src) {
+				t.Fatalf("%s: round trip mismatch", c.Name())
+			}
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	if Get(0x80) != nil {
+		t.Fatal("block type 0x80 registered before Register")
+	}
+	Register(xorCompressor{})
+	if c := Get(0x80); c == nil || c.Name() != "xor" {
+		t.Fatalf("Get(0x80) = %v, want xor", c)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Register of a registered block type did not panic")
+		}
+	}()
+	Register(NewFlate(flate.BestSpeed))
+}
@@ -12,12 +12,15 @@ import (
 	//"awesomeProject1/goleveldb/leveldb/testutil"
 	"io"
 	"math/rand"
+	"strings"
 	"testing"
 	"time"
 
+	"awesomeProject1/goleveldb/leveldb/errors"
 	"awesomeProject1/goleveldb/leveldb/filter"
 	"awesomeProject1/goleveldb/leveldb/opt"
 	"awesomeProject1/goleveldb/leveldb/storage"
+	"awesomeProject1/goleveldb/leveldb/util"
 )
 
 const ctValSize = 1000
@@ -246,6 +249,85 @@ func TestCorruptDB_Journal(t *testing.T) {
 	h.check(36, 36)
 }
 
+func TestCorruptDB_JournalTail_s(t *testing.T) {
+	h := newDbCorruptHarness(t)
+	defer h.close()
+
+	for i := 0; i < 100; i++ {
+		if err := h.db.Put_s(tkey(i), tval(i, ctValSize), h.wo); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+	}
+	h.closeDB()
+	h.corrupt(storage.TypeJournals, -1, 32*1024+1000, 1)
+
+	var tail int64 = -1
+	h.stor.OnLog(func(log string) {
+		for _, line := range strings.Split(log, "\n") {
+			if i := strings.Index(line, "truncated, tail @"); i >= 0 {
+				fmt.Sscanf(line[i+len("truncated, tail @"):], "%d", &tail)
+			}
+		}
+		t.Log(log)
+	})
+	h.openDB()
+	// The tail is the end of the last intact batch before the damaged byte.
+	if tail < 32*1024+1000-ctValSize*2 || tail > 32*1024+1000 {
+		t.Errorf("tail: want just before %d, got %d", 32*1024+1000, tail)
+	}
+	for i := 0; i < 10; i++ {
+		h.getVal_s(string(tkey(i)), string(tval(i, ctValSize)))
+	}
+}
+
+func TestCorruptDB_JournalStrict_s(t *testing.T) {
+	h := newDbCorruptHarnessWopt(t, &opt.Options{Strict: opt.StrictJournal})
+	defer h.close()
+
+	for i := 0; i < 10; i++ {
+		if err := h.db.Put_s(tkey(i), tval(i, 100), h.wo); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+	}
+	h.closeDB()
+
+	// Turn the first chunk into an orphan last chunk, which the journal
+	// reader skips even if strict.
+	fds, _ := h.stor.List(storage.TypeJournals)
+	sortFds(fds)
+	fd := fds[len(fds)-1]
+	r, err := h.stor.Open(fd)
+	if err != nil {
+		t.Fatal("cannot open file: ", err)
+	}
+	buf, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal("cannot read file: ", err)
+	}
+	buf[6] = 4
+	if err := h.stor.Remove(fd); err != nil {
+		t.Fatal("cannot remove old file: ", err)
+	}
+	w, err := h.stor.Create(fd)
+	if err != nil {
+		t.Fatal("cannot create new file: ", err)
+	}
+	w.Write(buf)
+	w.Close()
+
+	if err := h.openDB0(); !errors.IsCorrupted(err) || !strings.Contains(err.Error(), "tail @0") {
+		t.Fatalf("Open: want corruption at tail @0, got %v", err)
+	}
+
+	h.o.Strict = opt.NoStrict
+	h.openDB()
+	h.get_s(string(tkey(0)), false)
+	for i := 1; i < 10; i++ {
+		h.getVal_s(string(tkey(i)), string(tval(i, 100)))
+	}
+}
+
 func TestCorruptDB_Table(t *testing.T) {
 	h := newDbCorruptHarness(t)
 	defer h.close()
@@ -311,6 +393,46 @@ func TestCorruptDB_MissingManifest(t *testing.T) {
 	h.check(1000, 1000)
 }
 
+func TestCorruptDB_MissingManifest_s(t *testing.T) {
+	h := newDbCorruptHarnessWopt(t, &opt.Options{
+		BlockCacheCapacity: 100,
+		Strict:             opt.StrictJournalChecksum,
+		WriteBuffer:        1000 * 60,
+		WriteBuffer2:       1000 * 60,
+	})
+	defer h.close()
+
+	h.build(200)
+	for i := 0; i < 200; i++ {
+		if err := h.db.Put_s(tkey(i), tval(i+1, ctValSize), h.wo); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+	}
+	h.compactMem()
+	if err := h.db.CompactRange_s(util.Range{}); err != nil {
+		t.Fatal("CompactRange_s: got error: ", err)
+	}
+	h.closeDB()
+
+	h.forceRemoveAll(storage.TypeManifest)
+	h.openAssert(false)
+
+	h.recover()
+	h.check(200, 200)
+	for i := 0; i < 200; i++ {
+		h.getVal_s(string(tkey(i)), string(tval(i+1, ctValSize)))
+	}
+	v := h.db.s.version()
+	n := 0
+	for level := range v.level_s {
+		n += v.tLen_s(level)
+	}
+	v.release()
+	if n == 0 {
+		t.Error("no table recovered into the secondary tree")
+	}
+}
+
 func TestCorruptDB_SequenceNumberRecovery(t *testing.T) {
 	h := newDbCorruptHarness(t)
 	defer h.close()
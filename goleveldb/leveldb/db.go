@@ -99,6 +99,7 @@ type DB struct {
 	tcompCmdC     chan cCmd
 	tcompPauseC   chan chan<- struct{} //同理，tcomapction<是一个死循环竟然>监听这个通道，但是里面比较复杂了就
 	mcompCmdC     chan cCmd            //rotateMem中会写这个通道，mcompaction一直监听该通道，一旦有值则调用memcompaction
+	mcompPauseC   chan chan<- struct{}
 	compErrC      chan error
 	compPerErrC   chan error
 	compErrSetC   chan error
@@ -106,6 +107,7 @@ type DB struct {
 	tcompCmdCs   chan cCmd
 	tcompPauseCs chan chan<- struct{}
 	mcompCmdCs   chan cCmd
+	mcompPauseCs chan chan<- struct{}
 	compErrCs    chan error
 	compPerErrCs chan error
 	compErrSetCs chan error
@@ -114,6 +116,14 @@ type DB struct {
 	compStats, comStatss cStats
 	memdbMaxLevel        int // For testing.
 
+	// Freezer.
+	frz *freezer
+
+	// Blob GC, rewritten blob files by the sequence number from which they
+	// aren't read anymore.
+	blobMu       sync.Mutex
+	blobObsolete map[int64]uint64
+
 	// Close.关闭
 	closeW sync.WaitGroup
 	closeC chan struct{}
@@ -121,36 +131,6 @@ type DB struct {
 	closer io.Closer
 }
 
-func (db *DB) HasAncient(kind string, number uint64) (bool, error) {
-	//TODO implement me
-	panic("implement me")
-}
-
-func (db *DB) Ancient(kind string, number uint64) ([]byte, error) {
-	//TODO implement me
-	panic("implement me")
-}
-
-func (db *DB) AncientRange(kind string, start, count, maxBytes uint64) ([][]byte, error) {
-	//TODO implement me
-	panic("implement me")
-}
-
-func (db *DB) Ancients() (uint64, error) {
-	//TODO implement me
-	panic("implement me")
-}
-
-func (db *DB) Tail() (uint64, error) {
-	//TODO implement me
-	panic("implement me")
-}
-
-func (db *DB) AncientSize(kind string) (uint64, error) {
-	//TODO implement me
-	panic("implement me")
-}
-
 func openDB(s *session) (*DB, error) {
 	s.log("db@open opening")
 	start := time.Now()
@@ -178,6 +158,7 @@ func openDB(s *session) (*DB, error) {
 		tcompCmdC:   make(chan cCmd),
 		tcompPauseC: make(chan chan<- struct{}),
 		mcompCmdC:   make(chan cCmd),
+		mcompPauseC: make(chan chan<- struct{}),
 		compErrC:    make(chan error),
 		compPerErrC: make(chan error),
 		compErrSetC: make(chan error),
@@ -185,9 +166,12 @@ func openDB(s *session) (*DB, error) {
 		tcompCmdCs:   make(chan cCmd),
 		tcompPauseCs: make(chan chan<- struct{}),
 		mcompCmdCs:   make(chan cCmd),
+		mcompPauseCs: make(chan chan<- struct{}),
 		compErrCs:    make(chan error),
 		compPerErrCs: make(chan error),
 		compErrSetCs: make(chan error),
+		// Blob GC
+		blobObsolete: make(map[int64]uint64),
 		// Close
 		closeC: make(chan struct{}),
 	} //给DB赋值
@@ -195,29 +179,29 @@ func openDB(s *session) (*DB, error) {
 	// Read-only mode.
 	readOnly := s.o.GetReadOnly() //只读模式
 
+	// Open the freezer.
+	frz, err := openFreezer(s.stor, s.o.Options)
+	if err != nil {
+		return nil, err
+	}
+	db.frz = frz
+
 	if readOnly {
 		// Recover journals (read-only mode).
 		if err := db.recoverJournalRO(); err != nil {
+			db.frz.close()
 			return nil, err
 		}
 	} else { //必走这一条，从两个log中恢复，这里会有问题
-		// Recover journals.
-		if db.WhichLogFirst() {
-			//fmt.Println("HHHH")
-			if err := db.recoverJournal(); err != nil {
-				return nil, err
-			}
-			if err := db.recoverJournal_s(); err != nil {
-				return nil, err
-			}
-		} else {
-			//fmt.Println("GGGG")
-			if err := db.recoverJournal_s(); err != nil {
-				return nil, err
-			}
-			if err := db.recoverJournal(); err != nil {
-				return nil, err
-			}
+		// Blob files are written by the journal recovery already.
+		if err := db.markBlobFiles(); err != nil {
+			db.frz.close()
+			return nil, err
+		}
+		// Recover journals of both trees.
+		if err := db.recoverJournals(); err != nil {
+			db.frz.close()
+			return nil, err
 		}
 		/*if err := db.RJ(); err != nil {
 			return nil, err
@@ -234,6 +218,7 @@ func openDB(s *session) (*DB, error) {
 				db.journal2.Close()
 				db.journalWriter2.Close()
 			}
+			db.frz.close()
 			return nil, err
 		}
 	}
@@ -251,6 +236,14 @@ func openDB(s *session) (*DB, error) {
 		go db.mCompaction()   //minor
 		go db.tCompaction_s() //major
 		go db.mCompaction_s() //minor
+		if db.s.o.GetAncientDistance() > 0 {
+			db.closeW.Add(1)
+			go db.freezeLoop()
+		}
+		if db.s.o.GetBlobThreshold() > 0 {
+			db.closeW.Add(1)
+			go db.blobGCLoop()
+		}
 		// go db.jWriter()
 	}
 
@@ -402,7 +395,7 @@ func recoverTable(s *session, o *opt.Options) error {
 		rec   = &sessionRecord{}
 		bpool = util.NewBufferPool(o.GetBlockSize() + 5)
 	)
-	buildTable := func(iter iterator.Iterator) (tmpFd storage.FileDesc, size int64, err error) {
+	buildTable := func(iter iterator.Iterator, tree opt.Tree) (tmpFd storage.FileDesc, size int64, err error) {
 		tmpFd = s.newTemp()
 		writer, err := s.stor.Create(tmpFd)
 		if err != nil {
@@ -418,6 +411,7 @@ func recoverTable(s *session, o *opt.Options) error {
 
 		// Copy entries.
 		tw := table.NewWriter(writer, o)
+		tw.SetTree(tree)
 		for iter.Next() {
 			key := iter.Key()
 			if validInternalKey(key) {
@@ -520,7 +514,7 @@ func recoverTable(s *session, o *opt.Options) error {
 				// Rebuild the table.
 				s.logf("table@recovery rebuilding @%d", fd.Num)
 				iter := tr.NewIterator(nil, nil)
-				tmpFd, newSize, err := buildTable(iter)
+				tmpFd, newSize, err := buildTable(iter, tr.Tree())
 				iter.Release()
 				if err != nil {
 					return err
@@ -536,9 +530,13 @@ func recoverTable(s *session, o *opt.Options) error {
 				maxSeq = tSeq
 			}
 			recoveredKey += tgoodKey
-			// Add table to level 0.
-			rec.addTable(0, fd.Num, size, imin, imax)
-			s.logf("table@recovery recovered @%d Gk·%d Ck·%d Cb·%d S·%d Q·%d", fd.Num, tgoodKey, tcorruptedKey, tcorruptedBlock, size, tSeq)
+			// Add table to level 0 of its tree.
+			if tr.Tree() == opt.SecondaryTree {
+				rec.addTable_s(0, fd.Num, size, imin, imax)
+			} else {
+				rec.addTable(0, fd.Num, size, imin, imax)
+			}
+			s.logf("table@recovery recovered @%d %s Gk·%d Ck·%d Cb·%d S·%d Q·%d", fd.Num, tr.Tree(), tgoodKey, tcorruptedKey, tcorruptedBlock, size, tSeq)
 		} else {
 			droppedTable++
 			s.logf("table@recovery unrecoverable @%d Ck·%d Cb·%d S·%d", fd.Num, tcorruptedKey, tcorruptedBlock, size)
@@ -595,6 +593,9 @@ func (db *DB) Getnonlevel0Comp() (i uint32, j uint32) {
 
 // 如果logs大于log那么返回true
 // 以此来决定log的先后recover顺序
+//
+// Deprecated: the journals are no longer recovered one after the other, they
+// are merged by sequence number.
 func (db *DB) WhichLogFirst() bool {
 	rawFds, _ := db.s.stor.List(storage.TypeJournal)
 	sortFds(rawFds)
@@ -613,332 +614,316 @@ func (db *DB) WhichLogFirst() bool {
 	}
 	return num2 > num1
 }
-func (db *DB) recoverJournal() error {
-	// Get all journals and sort it by file number.
-	rawFds, err := db.s.stor.List(storage.TypeJournal) //返回值为[]FileDesc{Type FileType，num}, error
+
+// journalStream reads the batches of the journal files of one tree, in file
+// number order.
+type journalStream struct {
+	db       *DB
+	fds      []storage.FileDesc
+	strict   bool
+	checksum bool
+
+	fd  storage.FileDesc
+	fr  storage.Reader
+	jr  *journal.Reader
+	buf util.Buffer
+
+	// Current batch, valid if ok.
+	ok       bool
+	batchSeq uint64
+	batchLen int
+	batchEnd int64
+
+	// tail is the end of the last batch replayed from fd, tornAt is the tail
+	// at the first damaged journal of fd.
+	tail   int64
+	tornAt int64
+	torn   bool
+
+	// Fully replayed files.
+	done []storage.FileDesc
+}
+
+type journalDropper struct {
+	dropper
+	js *journalStream
+}
+
+func (d journalDropper) Drop(err error) {
+	d.dropper.Drop(err)
+	d.js.tear()
+}
+
+func (js *journalStream) open(fd storage.FileDesc) error {
+	js.db.logf("journal@recovery recovering %s-%d", fd.Type, fd.Num)
+	fr, err := js.db.s.stor.Open(fd)
 	if err != nil {
 		return err
 	}
-	sortFds(rawFds) //按照num排序
+	js.fd, js.fr = fd, fr
+	js.tail, js.tornAt, js.torn = 0, 0, false
 
-	// Journals that will be recovered.
-	var fds []storage.FileDesc //fds存储，把rawFds放入fds
-	for _, fd := range rawFds {
-		//fmt.Println("进入循环")
-		//if fd.Num >= db.s.stJournalNum || fd.Num == db.s.stPrevJournalNum {fds = append(fds, fd)} //因为有些frozen还没flush所以有俩
-		fds = append(fds, fd)
+	// Create or reset journal reader instance.
+	d := journalDropper{dropper{js.db.s, fd}, js}
+	if js.jr == nil {
+		js.jr = journal.NewReader(fr, d, js.strict, js.checksum)
+	} else {
+		js.jr.Reset(fr, d, js.strict, js.checksum)
 	}
-	//fmt.Println("This is in recoverJournal",len(fds))
-	var (
-		ofd storage.FileDesc // Obsolete file.
-		rec = &sessionRecord{}
-	)
-	// Recover journals.
-	if len(fds) > 0 {
-		db.logf("journal@recovery F·%d", len(fds))
+	return nil
+}
 
-		// Mark file number as used.
-		db.s.markFileNum(fds[len(fds)-1].Num)
+func (js *journalStream) close() {
+	if js.fr != nil {
+		js.fr.Close()
+		js.fr = nil
+	}
+}
 
-		var (
-			// Options.
-			strict      = db.s.o.GetStrict(opt.StrictJournal)
-			checksum    = db.s.o.GetStrict(opt.StrictJournalChecksum)
-			writeBuffer = db.s.o.GetWriteBuffer()
-
-			jr       *journal.Reader
-			mdb      = memdb.New(db.s.icmp, writeBuffer) //比较器和4M的容量
-			buf      = &util.Buffer{}
-			batchSeq uint64
-			batchLen int
-		)
+// tear records that the current file is damaged past the tail.
+func (js *journalStream) tear() {
+	if !js.torn {
+		js.torn = true
+		js.tornAt = js.tail
+	}
+}
 
-		for _, fd := range fds {
-			db.logf("journal@recovery recovering @%d", fd.Num)
+// report logs the offset up to which the current file was intact, if it is
+// damaged.
+func (js *journalStream) report() {
+	if js.torn {
+		js.db.logf("journal@recovery %s-%d truncated, tail @%d", js.fd.Type, js.fd.Num, js.tornAt)
+	}
+}
 
-			fr, err := db.s.stor.Open(fd) //为每个log文件创建一个Reader
-			if err != nil {
-				return err
-			}
+// errTorn returns the corruption of the current file, which is torn, under
+// StrictJournal.
+func (js *journalStream) errTorn() error {
+	return errors.NewErrCorrupted(js.fd, fmt.Errorf("leveldb/journal: truncated, tail @%d", js.tornAt))
+}
 
-			// Create or reset journal reader instance.
-			if jr == nil {
-				jr = journal.NewReader(fr, dropper{db.s, fd}, strict, checksum)
-			} else {
-				jr.Reset(fr, dropper{db.s, fd}, strict, checksum)
-			}
-			//fmt.Println(rec.addedTables)
-			// Flush memdb and remove obsolete journal file.
-			if !ofd.Zero() { //基本不会执行？ ofd.Zero() is true
-				//fmt.Println("ASDASDASDASADADADAD")
-				if mdb.Len() > 0 {
-					if _, err := db.s.flushMemdb(rec, mdb, 0); err != nil {
-						fr.Close()
-						return err
-					}
-				}
-				rec.setJournalNum(fd.Num)
-				rec.setSeqNum(db.seq)
-				if err := db.s.commit(rec, false); err != nil {
-					fr.Close()
-					return err
-				}
-				rec.resetAddedTables()
+// fail tears the current file at the tail and returns err bound to it.
+func (js *journalStream) fail(err error) error {
+	js.tear()
+	js.report()
+	return errors.SetFd(err, js.fd)
+}
 
-				db.s.stor.Remove(ofd)
-				ofd = storage.FileDesc{}
+// next reads the header of the next batch. It returns io.EOF once all files
+// are read.
+func (js *journalStream) next() error {
+	js.ok = false
+	for {
+		if js.fr == nil {
+			if len(js.fds) == 0 {
+				return io.EOF
 			}
-			//fmt.Println("ASDASDASDASADADADAD2222")
-			// Replay journal to memdb.
-			mdb.Reset() //初始化mdb
-			for {
-				r, err := jr.Next()
-				if err != nil {
-					if err == io.EOF {
-						break
-					}
+			if err := js.open(js.fds[0]); err != nil {
+				return err
+			}
+			js.fds = js.fds[1:]
+		}
 
-					fr.Close()
-					return errors.SetFd(err, fd)
+		r, err := js.jr.Next()
+		if err != nil {
+			if err == io.EOF {
+				js.report()
+				if js.torn && js.strict {
+					// The reader skipped damaged chunks.
+					return js.errTorn()
 				}
+				js.close()
+				js.done = append(js.done, js.fd)
+				continue
+			}
+			return js.fail(err)
+		}
 
-				buf.Reset()
-				if _, err := buf.ReadFrom(r); err != nil {
-					if err == io.ErrUnexpectedEOF {
-						// This is error returned due to corruption, with strict == false.
-						continue
-					}
-
-					fr.Close()
-					return errors.SetFd(err, fd)
-				}
-				batchSeq, batchLen, err = decodeBatchToMem(buf.Bytes(), db.seq, mdb)
-				if err != nil {
-					//fmt.Println("22222")
-					if !strict && errors.IsCorrupted(err) {
-						db.s.logf("journal error: %v (skipped)", err)
-						// We won't apply sequence number as it might be corrupted.我们不会应用序列号，因为它可能被破坏。
-						continue
-					}
-
-					fr.Close()
-					return errors.SetFd(err, fd)
-				}
-				//fmt.Println("mdb的容量：",mdb.Size())
-				// Save sequence number.
-				db.seq = batchSeq + uint64(batchLen)
-
-				// Flush it if large enough.
-				if mdb.Size() >= writeBuffer {
-					if _, err := db.s.flushMemdb(rec, mdb, 0); err != nil {
-						fr.Close()
-						return err
-					}
-					mdb.Reset()
+		js.buf.Reset()
+		if _, err := js.buf.ReadFrom(r); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				// This is error returned due to corruption, with strict == false.
+				js.tear()
+				if js.strict {
+					js.report()
+					return js.errTorn()
 				}
+				continue
 			}
-
-			fr.Close()
-			ofd = fd //表明日志文件需要被删除
+			return js.fail(err)
 		}
-
-		// Flush the last memdb.
-		if mdb.Len() > 0 {
-			if _, err := db.s.flushMemdb(rec, mdb, 0); err != nil {
-				return err
+		js.batchSeq, js.batchLen, err = decodeBatchHeader(js.buf.Bytes())
+		if err != nil {
+			if !js.strict && errors.IsCorrupted(err) {
+				js.db.s.logf("journal error: %v (skipped)", err)
+				js.tear()
+				continue
 			}
+			return js.fail(err)
 		}
+		js.batchEnd = js.jr.Offset()
+		js.ok = true
+		return nil
 	}
+}
 
-	// Create a new journal.
-	if _, err := db.newMem(0); err != nil {
-		return err
-	}
-	// Commit.
-	if db.journalFd.Num >= rec.journalNum {
-		rec.setJournalNum(db.journalFd.Num)
+func newJournalStream(db *DB, fds []storage.FileDesc) *journalStream {
+	return &journalStream{
+		db:       db,
+		fds:      fds,
+		strict:   db.s.o.GetStrict(opt.StrictJournal),
+		checksum: db.s.o.GetStrict(opt.StrictJournalChecksum),
 	}
-	rec.setSeqNum(db.seq)
-	if err := db.s.commit(rec, false); err != nil {
-		// Close journal on error.
-		if db.journal != nil {
-			db.journal.Close()
-			db.journalWriter.Close()
+}
+
+// replayJournals replays the batches of both streams to mdb and mdbs, the
+// lowest sequence number first, and advances db.seq past the last one. The
+// secondary half of a TreeBatch is in both streams, replaying it twice puts
+// the same keys again. If not nil, flush is called after each batch.
+func (db *DB) replayJournals(primary, secondary *journalStream, mdb *memdb.DB, mdbs *memdb.DBs, flush func() error) error {
+	defer primary.close()
+	defer secondary.close()
+	for _, js := range []*journalStream{primary, secondary} {
+		if err := js.next(); err != nil && err != io.EOF {
+			return err
 		}
-		return err
 	}
+	for primary.ok || secondary.ok {
+		js := primary
+		if !primary.ok || (secondary.ok && secondary.batchSeq < primary.batchSeq) {
+			js = secondary
+		}
 
-	// Remove the last obsolete journal file.
-	if !ofd.Zero() {
-		db.s.stor.Remove(ofd)
-	}
+		var err error
+		if js == primary {
+			_, _, err = decodeTreeBatchToMem(js.buf.Bytes(), db.seq, mdb, mdbs)
+		} else {
+			_, _, err = decodeBatchToMem_s(js.buf.Bytes(), db.seq, mdbs)
+		}
+		if err != nil {
+			if js.strict || !errors.IsCorrupted(err) {
+				return js.fail(err)
+			}
+			db.s.logf("journal error: %v (skipped)", err)
+			// We won't apply sequence number as it might be corrupted.
+			js.tear()
+		} else {
+			js.tail = js.batchEnd
+			// Save sequence number.
+			if seq := js.batchSeq + uint64(js.batchLen); seq > db.seq {
+				db.seq = seq
+			}
+		}
 
+		if flush != nil {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if err := js.next(); err != nil && err != io.EOF {
+			return err
+		}
+	}
 	return nil
 }
-func (db *DB) recoverJournal_s() error {
-	// Get all journals and sort it by file number.
-	rawFds, err := db.s.stor.List(storage.TypeJournals)
-	if err != nil {
-		return err
-	}
-	sortFds(rawFds)
 
-	// Journals that will be recovered.
-	var fds []storage.FileDesc
-	for _, fd := range rawFds {
-		/*if fd.Num >= db.s.stJournalNum || fd.Num == db.s.stPrevJournalNum {
+// recoverJournals recovers the journals of both trees. The two streams are
+// merged by batch sequence number, so the memdbs are rebuilt in write order
+// and db.seq ends up past the last batch of either tree, whatever the file
+// numbers of the journals are.
+func (db *DB) recoverJournals() error {
+	// Get all journals of both trees and sort it by file number.
+	var streams [2]*journalStream
+	for i, ft := range []storage.FileType{storage.TypeJournal, storage.TypeJournals} {
+		fds, err := db.s.stor.List(ft)
+		if err != nil {
+			return err
+		}
+		sortFds(fds)
+		if len(fds) > 0 {
+			db.logf("journal@recovery %s F·%d", ft, len(fds))
 
-		}*/
-		fds = append(fds, fd)
+			// Mark file number as used.
+			db.s.markFileNum(fds[len(fds)-1].Num)
+		}
+		streams[i] = newJournalStream(db, fds)
 	}
-	//fmt.Println("This is in recoverJournal_s",len(fds))
-	var (
-		ofd storage.FileDesc // Obsolete file.
-		rec = &sessionRecord{}
-	)
-	// Recover journals.
-	if len(fds) > 0 {
-		db.logf("journal@recovery F·%d", len(fds))
-
-		// Mark file number as used.
-		db.s.markFileNum(fds[len(fds)-1].Num)
 
-		var (
-			// Options.
-			strict      = db.s.o.GetStrict(opt.StrictJournal)         //bool
-			checksum    = db.s.o.GetStrict(opt.StrictJournalChecksum) //bool
-			writeBuffer = db.s.o.GetWriteBuffer2()                    //4mb
-
-			jr       *journal.Reader
-			mdbs     = memdb.New_s(db.s.icmp, writeBuffer)
-			buf      = &util.Buffer{}
-			batchSeq uint64
-			batchLen int
-		)
-
-		for _, fd := range fds {
-			db.logf("journal@recovery recovering @%d", fd.Num)
+	var (
+		writeBuffer  = db.s.o.GetWriteBuffer()
+		writeBuffer2 = db.s.o_s.GetWriteBuffer()
 
-			fr, err := db.s.stor.Open(fd)
-			if err != nil {
+		rec  = &sessionRecord{}
+		mdb  = memdb.New(db.s.icmp, writeBuffer)
+		mdbs = memdb.New_s(db.s.icmp, writeBuffer2)
+	)
+	// Flush it if large enough.
+	flush := func() error {
+		if mdb.Size() >= writeBuffer {
+			if _, err := db.s.flushMemdb(rec, mdb, 0); err != nil {
 				return err
 			}
-
-			// Create or reset journal reader instance.
-			if jr == nil {
-				jr = journal.NewReader(fr, dropper{db.s, fd}, strict, checksum)
-			} else {
-				jr.Reset(fr, dropper{db.s, fd}, strict, checksum)
-			}
-			// Flush memdb and remove obsolete journal file.
-			if !ofd.Zero() {
-				if mdbs.Len_s() > 0 {
-					//fmt.Println("GOODGOOD")
-					if _, err := db.s.flushMemdb_s(rec, mdbs, 0); err != nil {
-						fr.Close()
-						return err
-					}
-				}
-
-				rec.setJournalNum(fd.Num)
-				rec.setSeqNum(db.seq)
-				if err := db.s.commit(rec, false); err != nil {
-					fr.Close()
-					return err
-				}
-				rec.resetAddedTables_s()
-
-				db.s.stor.Remove(ofd)
-				ofd = storage.FileDesc{}
-			}
-			//fmt.Println("ASDASDASDASADADADAD3333")
-			// Replay journal to memdb.
-			mdbs.Reset_s()
-			for {
-				r, err := jr.Next()
-				if err != nil {
-					if err == io.EOF {
-						break
-					}
-
-					fr.Close()
-					return errors.SetFd(err, fd)
-				}
-				buf.Reset()
-				if _, err := buf.ReadFrom(r); err != nil {
-					if err == io.ErrUnexpectedEOF {
-						// This is error returned due to corruption, with strict == false.
-						continue
-					}
-
-					fr.Close()
-					return errors.SetFd(err, fd)
-				}
-				batchSeq, batchLen, err = decodeBatchToMem_s(buf.Bytes(), db.seq, mdbs)
-				//此住没有执行？
-				if err != nil {
-					if !strict && errors.IsCorrupted(err) {
-						db.s.logf("journal error: %v (skipped)", err)
-						// We won't apply sequence number as it might be corrupted.
-						continue
-					}
-
-					fr.Close()
-					return errors.SetFd(err, fd)
-				}
-				//fmt.Println("12345")
-				// Save sequence number.
-				db.seq = batchSeq + uint64(batchLen)
-				//fmt.Println("mdbs的容量：",mdbs.Size_s())
-				// Flush it if large enough.
-				if mdbs.Size_s() >= writeBuffer {
-					if _, err := db.s.flushMemdb_s(rec, mdbs, 0); err != nil {
-						fr.Close()
-						return err
-					}
-
-					mdbs.Reset_s()
-				}
-			}
-
-			fr.Close()
-			ofd = fd
+			mdb.Reset()
 		}
-
-		// Flush the last memdb.
-		if mdbs.Len_s() > 0 {
+		if mdbs.Size_s() >= writeBuffer2 {
 			if _, err := db.s.flushMemdb_s(rec, mdbs, 0); err != nil {
 				return err
 			}
+			mdbs.Reset_s()
+		}
+		return nil
+	}
+	if err := db.replayJournals(streams[0], streams[1], mdb, mdbs, flush); err != nil {
+		return err
+	}
+
+	// Flush the last memdbs.
+	if mdb.Len() > 0 {
+		if _, err := db.s.flushMemdb(rec, mdb, 0); err != nil {
+			return err
+		}
+	}
+	if mdbs.Len_s() > 0 {
+		if _, err := db.s.flushMemdb_s(rec, mdbs, 0); err != nil {
+			return err
 		}
 	}
 
-	// Create a new journal.
+	// Create new journals.
+	if _, err := db.newMem(0); err != nil {
+		return err
+	}
 	if _, err := db.newMem_s(0); err != nil {
+		db.journal.Close()
+		db.journalWriter.Close()
 		return err
 	}
 
-	// Commit.
-	if db.journalFd2.Num >= rec.journalNum {
-		rec.setJournalNum(db.journalFd2.Num)
+	// Commit. The journal number is the one of the primary journal, the
+	// read-only recovery relies on it.
+	if db.journalFd.Num >= rec.journalNum {
+		rec.setJournalNum(db.journalFd.Num)
 	}
 	rec.setSeqNum(db.seq)
 	if err := db.s.commit(rec, false); err != nil {
-		// Close journal on error.
-		if db.journal2 != nil {
-			db.journal2.Close()
-			db.journalWriter2.Close()
-		}
+		// Close journals on error.
+		db.journal.Close()
+		db.journalWriter.Close()
+		db.journal2.Close()
+		db.journalWriter2.Close()
 		return err
 	}
 
-	// Remove the last obsolete journal file.
-	if !ofd.Zero() {
-		db.s.stor.Remove(ofd)
+	// Remove the replayed journal files.
+	for _, js := range streams {
+		for _, fd := range js.done {
+			db.s.stor.Remove(fd)
+		}
 	}
 
 	return nil
-} //写日志的时候有问题，List，如果要改应该写入两个日志之中；
+}
+
 func (db *DB) recoverJournalRO() error {
 	// Get all journals and sort it by file number.
 	rawFds, err := db.s.stor.List(storage.TypeJournal)
@@ -955,128 +940,75 @@ func (db *DB) recoverJournalRO() error {
 		}
 	}
 
+	// The secondary tree has no journal number in the session, recover all
+	// of its journals.
+	fds2, err := db.s.stor.List(storage.TypeJournals)
+	if err != nil {
+		return err
+	}
+	sortFds(fds2)
+
 	var (
-		// Options.
-		strict      = db.s.o.GetStrict(opt.StrictJournal)
-		checksum    = db.s.o.GetStrict(opt.StrictJournalChecksum)
-		writeBuffer = db.s.o.GetWriteBuffer()
 		//创建一个初始化的mdb，是只添加
-		mdb  = memdb.New(db.s.icmp, writeBuffer)
-		mdbs = memdb.New_s(db.s.icmp, writeBuffer)
+		mdb  = memdb.New(db.s.icmp, db.s.o.GetWriteBuffer())
+		mdbs = memdb.New_s(db.s.icmp, db.s.o_s.GetWriteBuffer())
 	)
 
 	// Recover journals.
-	if len(fds) > 0 {
-		db.logf("journal@recovery RO·Mode F·%d", len(fds))
+	if len(fds) > 0 || len(fds2) > 0 {
+		db.logf("journal@recovery RO·Mode F·%d", len(fds)+len(fds2))
 
-		var (
-			jr       *journal.Reader
-			buf      = &util.Buffer{}
-			batchSeq uint64
-			batchLen int
-		)
-
-		for _, fd := range fds {
-			db.logf("journal@recovery recovering @%d", fd.Num)
-
-			fr, err := db.s.stor.Open(fd)
-			if err != nil {
-				return err
-			}
-
-			// Create or reset journal reader instance.
-			if jr == nil {
-				jr = journal.NewReader(fr, dropper{db.s, fd}, strict, checksum)
-			} else {
-				jr.Reset(fr, dropper{db.s, fd}, strict, checksum)
-			}
-
-			// Replay journal to memdb.
-			for {
-				r, err := jr.Next()
-				if err != nil {
-					if err == io.EOF {
-						break
-					}
-
-					fr.Close()
-					return errors.SetFd(err, fd)
-				}
-
-				buf.Reset()
-				if _, err := buf.ReadFrom(r); err != nil {
-					if err == io.ErrUnexpectedEOF {
-						// This is error returned due to corruption, with strict == false.
-						continue
-					}
-
-					fr.Close()
-					return errors.SetFd(err, fd)
-				}
-				batchSeq, batchLen, err = decodeBatchToMem(buf.Bytes(), db.seq, mdb)
-				if err != nil {
-					if !strict && errors.IsCorrupted(err) {
-						db.s.logf("journal error: %v (skipped)", err)
-						// We won't apply sequence number as it might be corrupted.
-						continue
-					}
-
-					fr.Close()
-					return errors.SetFd(err, fd)
-				}
-
-				// Save sequence number.
-				db.seq = batchSeq + uint64(batchLen)
-			}
-
-			fr.Close()
+		if err := db.replayJournals(newJournalStream(db, fds), newJournalStream(db, fds2), mdb, mdbs, nil); err != nil {
+			return err
 		}
 	}
 
-	// Set memDB.
 	//db.mem为memDB类型，
 	db.mem = &memDB{db: db, DB: mdb, ref: 1}
 	db.mems = &memDB{db: db, DBs: mdbs, refs: 1}
 	return nil
 }
 
-func memGet(mdb *memdb.DB, ikey internalKey, icmp *iComparer) (ok bool, mv []byte, err error) {
+func memGet(mdb *memdb.DB, ikey internalKey, icmp *iComparer) (ok bool, mseq uint64, mv []byte, err error) {
 	mk, mv, err := mdb.Find(ikey)
 	if err == nil {
-		ukey, _, kt, kerr := parseInternalKey(mk)
+		ukey, seq, kt, kerr := parseInternalKey(mk)
 		if kerr != nil {
 			// Shouldn't have had happen.
 			panic(kerr)
 		}
 		if icmp.uCompare(ukey, ikey.ukey()) == 0 {
 			if kt == keyTypeDel {
-				return true, nil, ErrNotFound
+				return true, seq, nil, ErrNotFound
 			}
-			return true, mv, nil
+			if kt == keyTypeMerge {
+				return true, seq, nil, errMergeOperand
+			}
+			return true, seq, mv, nil
 
 		}
 	} else if err != ErrNotFound {
-		return true, nil, err
+		return true, 0, nil, err
 	}
 	return
 }
-func memGet_s(mdb *memdb.DBs, ikey internalKey, icmp *iComparer) (ok bool, mv []byte, err error) {
+func memGet_s(mdb *memdb.DBs, ikey internalKey, icmp *iComparer) (ok bool, mseq uint64, mv []byte, err error) {
 	mk, mv, err := mdb.Find_s(ikey)
 	if err == nil {
-		ukey, _, kt, kerr := parseInternalKey(mk)
+		ukey, seq, kt, kerr := parseInternalKey(mk)
 		if kerr != nil {
 			// Shouldn't have had happen.
 			panic(kerr)
 		}
 		if icmp.uCompare(ukey, ikey.ukey()) == 0 {
 			if kt == keyTypeDel {
-				return true, nil, ErrNotFound
+				return true, seq, nil, ErrNotFound
 			}
-			return true, mv, nil
+			return true, seq, mv, nil
 
 		}
 	} else if err != ErrNotFound {
-		return true, nil, err
+		return true, 0, nil, err
 	}
 	return
 }
@@ -1085,7 +1017,7 @@ func (db *DB) get(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.R
 	ikey := makeInternalKey(nil, key, seq, keyTypeSeek) //把key变为internalKey，其实就是加个8bytes，7bytes的seq N，1byte的操作类型
 
 	if auxm != nil {
-		if ok, mv, me := memGet(auxm, ikey, db.s.icmp); ok {
+		if ok, _, mv, me := memGet(auxm, ikey, db.s.icmp); ok {
 			//内建函数append将元素追加到切片的末尾。若它有足够的容量，其目标就会
 			// 重新切片以容纳新的元素。否则，就会分配一个新的基本数组。append返回
 			// 更新后的切片，因此必须存储追加后的结果
@@ -1095,14 +1027,26 @@ func (db *DB) get(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.R
 	//从内存数据中查找
 	//getMems返回memdb和freezememdb
 	em, fm := db.getMems()
+	var rseq uint64 // newest range tombstone covering the key
 	for _, m := range [...]*memDB{em, fm} {
 		if m == nil {
 			continue
 		}
 		defer m.decref()
 
-		if ok, mv, me := memGet(m.DB, ikey, db.s.icmp); ok {
+		if tseq, err := memRangeTombstoneSeq(db.s.icmp, m, false, key, seq); err != nil {
+			return nil, err
+		} else if tseq > rseq {
+			rseq = tseq
+		}
+		if ok, mseq, mv, me := memGet(m.DB, ikey, db.s.icmp); ok {
 			fmt.Println("get from memDb")
+			if (me == nil || me == errMergeOperand) && mseq < rseq {
+				return nil, ErrNotFound
+			}
+			if me == errMergeOperand {
+				return db.getMerged(auxt, key, seq, ro)
+			}
 			return append([]byte{}, mv...), me
 		}
 	}
@@ -1111,43 +1055,65 @@ func (db *DB) get(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.R
 	//v.get为在磁盘上查询的处理
 	fmt.Println("version : ", v.id)
 	fmt.Println("get from disk")
-	value, cSched, err := v.get(auxt, ikey, ro, false)
+	value, cSched, err := v.get(auxt, ikey, ro, false, rseq)
 	v.release()
 	if cSched {
 		// Trigger table compaction.
 		db.compTrigger(db.tcompCmdC)
 	}
+	if err == errMergeOperand {
+		return db.getMerged(auxt, key, seq, ro)
+	}
 	return
 }
 func (db *DB) get_s(auxm *memdb.DBs, auxt sFiles, key []byte, seq uint64, ro *opt.ReadOptions) (value []byte, err error) {
+	value, blob, err := db.getRaw_s(auxm, auxt, key, seq, ro)
+	if err == nil && blob {
+		value, err = db.s.tops.readBlob(value)
+	}
+	return
+}
+
+// getRaw_s is get_s, leaving a value moved to a blob file as the pointer of
+// its entry, blob is true then.
+func (db *DB) getRaw_s(auxm *memdb.DBs, auxt sFiles, key []byte, seq uint64, ro *opt.ReadOptions) (value []byte, blob bool, err error) {
 	ikey := makeInternalKey(nil, key, seq, keyTypeSeek) //把key变为internalKey，其实就是加个8bytes，7bytes的seq N，1byte的操作类型
 
 	if auxm != nil {
-		if ok, mv, me := memGet_s(auxm, ikey, db.s.icmp); ok {
+		if ok, _, mv, me := memGet_s(auxm, ikey, db.s.icmp); ok {
 			//内建函数append将元素追加到切片的末尾。若它有足够的容量，其目标就会
 			// 重新切片以容纳新的元素。否则，就会分配一个新的基本数组。append返回
 			// 更新后的切片，因此必须存储追加后的结果
-			return append([]byte{}, mv...), me
+			return append([]byte{}, mv...), false, me
 		}
 	}
 	//从内存数据中查找
 	//getMems返回memdb和freezememdb
 	//fmt.Println("从Mems和Frozenmems中查找")
 	em, fm := db.getMems_s() //memtables和immuntbales
+	var rseq uint64
 	for _, m := range [...]*memDB{em, fm} {
 		if m == nil {
 			continue
 		}
 		defer m.decref_s()
 
-		if ok, mv, me := memGet_s(m.DBs, ikey, db.s.icmp); ok {
-			return append([]byte{}, mv...), me
+		if tseq, err := memRangeTombstoneSeq(db.s.icmp, m, true, key, seq); err != nil {
+			return nil, false, err
+		} else if tseq > rseq {
+			rseq = tseq
+		}
+		if ok, mseq, mv, me := memGet_s(m.DBs, ikey, db.s.icmp); ok {
+			if me == nil && mseq < rseq {
+				return nil, false, ErrNotFound
+			}
+			return append([]byte{}, mv...), false, me
 		}
 	}
 
 	v := db.s.version() //快照的版本？ //得到session当前的版本
 	//v.get为在磁盘上查询的处理
-	value, cSched, err := v.get_s(auxt, ikey, ro, false) //auxt is nil，cSched是bool类型
+	value, blob, cSched, err := v.getRaw_s(auxt, ikey, ro, false, rseq) //auxt is nil，cSched是bool类型
 	v.release()
 	if cSched {
 		// Trigger table compaction.
@@ -1167,30 +1133,88 @@ func (db *DB) has(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.R
 	ikey := makeInternalKey(nil, key, seq, keyTypeSeek)
 
 	if auxm != nil {
-		if ok, _, me := memGet(auxm, ikey, db.s.icmp); ok {
+		if ok, _, _, me := memGet(auxm, ikey, db.s.icmp); ok {
 			return me == nil, nilIfNotFound(me)
 		}
 	}
 
 	em, fm := db.getMems()
+	var rseq uint64
 	for _, m := range [...]*memDB{em, fm} {
 		if m == nil {
 			continue
 		}
 		defer m.decref()
 
-		if ok, _, me := memGet(m.DB, ikey, db.s.icmp); ok {
+		if tseq, err := memRangeTombstoneSeq(db.s.icmp, m, false, key, seq); err != nil {
+			return false, err
+		} else if tseq > rseq {
+			rseq = tseq
+		}
+		if ok, mseq, _, me := memGet(m.DB, ikey, db.s.icmp); ok {
+			if me == errMergeOperand {
+				// Merge operands make the key exist, whatever their base.
+				me = nil
+			}
+			if me == nil && mseq < rseq {
+				return false, nil
+			}
 			return me == nil, nilIfNotFound(me)
 		}
 	}
 
 	v := db.s.version()
-	_, cSched, err := v.get(auxt, ikey, ro, true)
+	_, cSched, err := v.get(auxt, ikey, ro, true, rseq)
 	v.release()
 	if cSched {
 		// Trigger table compaction.
 		db.compTrigger(db.tcompCmdC)
 	}
+	if err == nil || err == errMergeOperand {
+		ret, err = true, nil
+	} else if err == ErrNotFound {
+		err = nil
+	}
+	return
+}
+
+func (db *DB) has_s(auxm *memdb.DBs, auxt sFiles, key []byte, seq uint64, ro *opt.ReadOptions) (ret bool, err error) {
+	ikey := makeInternalKey(nil, key, seq, keyTypeSeek)
+
+	if auxm != nil {
+		if ok, _, _, me := memGet_s(auxm, ikey, db.s.icmp); ok {
+			return me == nil, nilIfNotFound(me)
+		}
+	}
+
+	em, fm := db.getMems_s()
+	var rseq uint64
+	for _, m := range [...]*memDB{em, fm} {
+		if m == nil {
+			continue
+		}
+		defer m.decref_s()
+
+		if tseq, err := memRangeTombstoneSeq(db.s.icmp, m, true, key, seq); err != nil {
+			return false, err
+		} else if tseq > rseq {
+			rseq = tseq
+		}
+		if ok, mseq, _, me := memGet_s(m.DBs, ikey, db.s.icmp); ok {
+			if me == nil && mseq < rseq {
+				return false, nil
+			}
+			return me == nil, nilIfNotFound(me)
+		}
+	}
+
+	v := db.s.version()
+	_, cSched, err := v.get_s(auxt, ikey, ro, true, rseq)
+	v.release()
+	if cSched {
+		// Trigger table compaction.
+		db.compTrigger(db.tcompCmdCs)
+	}
 	if err == nil {
 		ret = true
 	} else if err == ErrNotFound {
@@ -1199,6 +1223,14 @@ func (db *DB) has(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.R
 	return
 }
 
+// routeTree returns the tree holding the given key, see Options.KeyRouter.
+func (db *DB) routeTree(key []byte) Tree {
+	if router := db.s.o.GetKeyRouter(); router != nil {
+		return router(key)
+	}
+	return PrimaryTree
+}
+
 // Get gets the value for the given key. It returns ErrNotFound if the
 // DB does not contains the key.
 //
@@ -1215,6 +1247,9 @@ func (db *DB) Get(key []byte, ro *opt.ReadOptions) (value []byte, err error) {
 
 	se := db.acquireSnapshot() //获取数据库快照
 	defer db.releaseSnapshot(se)
+	if db.routeTree(key) == SecondaryTree {
+		return db.get_s(nil, nil, key, se.seq, ro)
+	}
 	return db.get(nil, nil, key, se.seq, ro) //然后调用get函数
 }
 func (db *DB) Get_s(key []byte, ro *opt.ReadOptions) (value []byte, err error) {
@@ -1239,9 +1274,26 @@ func (db *DB) Has(key []byte, ro *opt.ReadOptions) (ret bool, err error) {
 
 	se := db.acquireSnapshot()
 	defer db.releaseSnapshot(se)
+	if db.routeTree(key) == SecondaryTree {
+		return db.has_s(nil, nil, key, se.seq, ro)
+	}
 	return db.has(nil, nil, key, se.seq, ro)
 }
 
+// Has_s returns true if the secondary tree does contains the given key.
+//
+// It is safe to modify the contents of the argument after Has_s returns.
+func (db *DB) Has_s(key []byte, ro *opt.ReadOptions) (ret bool, err error) {
+	err = db.ok()
+	if err != nil {
+		return
+	}
+
+	se := db.acquireSnapshot()
+	defer db.releaseSnapshot(se)
+	return db.has_s(nil, nil, key, se.seq, ro)
+}
+
 // NewIterator returns an iterator for the latest snapshot of the
 // underlying DB.
 // The returned iterator is not safe for concurrent use, but it is safe to use
@@ -1272,9 +1324,32 @@ func (db *DB) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Itera
 	defer db.releaseSnapshot(se)
 	// Iterator holds 'version' lock, 'version' is immutable so snapshot
 	// can be released after iterator created.
+	if db.s.o.GetKeyRouter() != nil {
+		// Keys are spread over both trees, merge them by user key.
+		its := []iterator.Iterator{
+			db.newIterator(nil, nil, se.seq, slice, ro),
+			db.newIterator_s(nil, nil, se.seq, slice, ro),
+		}
+		return iterator.NewMergedIterator(its, db.s.icmp.ucmp, opt.GetStrict(db.s.o.Options, ro, opt.StrictReader))
+	}
 	return db.newIterator(nil, nil, se.seq, slice, ro)
 }
 
+// NewIterator_s returns an iterator for the latest snapshot of the secondary
+// tree. It merges the secondary memdb, the frozen secondary memdb and the
+// level_s tables, and shares the semantics of NewIterator.
+//
+// The iterator must be released after use, by calling Release method.
+func (db *DB) NewIterator_s(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	if err := db.ok(); err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+
+	se := db.acquireSnapshot()
+	defer db.releaseSnapshot(se)
+	return db.newIterator_s(nil, nil, se.seq, slice, ro)
+}
+
 // GetSnapshot returns a latest snapshot of the underlying DB. A snapshot
 // is a frozen snapshot of a DB state at a particular point in time. The
 // content of snapshot are guaranteed to be consistent.
@@ -1407,6 +1482,11 @@ type DBStats struct {
 	WriteDelayDuration time.Duration
 	WritePaused        bool
 
+	// Time the table writes and compaction reads of each tree waited for
+	// opt.Options.RateLimiter.
+	ThrottleDuration   time.Duration
+	ThrottleDuration_s time.Duration
+
 	AliveSnapshots int32
 	AliveIterators int32
 
@@ -1440,6 +1520,8 @@ func (db *DB) Stats(s *DBStats) error {
 	s.WriteDelayCount = atomic.LoadInt32(&db.cWriteDelayN)
 	s.WriteDelayDuration = time.Duration(atomic.LoadInt64(&db.cWriteDelay))
 	s.WritePaused = atomic.LoadInt32(&db.inWritePaused) == 1
+	s.ThrottleDuration = db.s.tops.limiter.throttleDuration()
+	s.ThrottleDuration_s = db.s.tops.limiter_s.throttleDuration()
 
 	s.OpenedTablesCount = db.s.tops.cache.Size()
 	if db.s.tops.bcache != nil {
@@ -1542,6 +1624,10 @@ func (db *DB) Close() error {
 	// Signal all goroutines.
 	close(db.closeC)
 
+	// Let the throttled compactions see closeC.
+	db.s.tops.limiter.close()
+	db.s.tops.limiter_s.close()
+
 	// Discard open transaction.
 	if db.tr != nil {
 		db.tr.Discard()
@@ -1566,6 +1652,9 @@ func (db *DB) Close() error {
 		db.journal2 = nil
 		db.journalWriter2 = nil
 	}
+	// Closes freezer.
+	db.frz.close()
+
 	if db.writeDelayN > 0 {
 		db.logf("db@write was delayed N·%d T·%v", db.writeDelayN, db.writeDelay)
 	}
@@ -0,0 +1,181 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"os"
+	"time"
+
+	"awesomeProject1/goleveldb/leveldb/errors"
+	"awesomeProject1/goleveldb/leveldb/storage"
+)
+
+// Errors returned by Checkpoint.
+var (
+	ErrCheckpointExists      = errors.New("leveldb: checkpoint directory already exists")
+	ErrCheckpointUnsupported = errors.New("leveldb: storage can't export files")
+)
+
+// Checkpoint writes into dir, which must not exist, a copy of the DB that
+// opens as an independent DB holding everything written before the call.
+//
+// The tables and blob files are never modified once written, they are hard
+// linked, so dir must be on the same file system as the DB. The manifest,
+// the journals of both trees and the freezer files are copied. Writes,
+// memdb flushes and table compactions of both trees are paused meanwhile.
+//
+// The storage of the DB must implement storage.Exporter, as the one of
+// OpenFile does.
+func (db *DB) Checkpoint(dir string) error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+	ex, ok := db.s.stor.Storage.(storage.Exporter)
+	if !ok {
+		return ErrCheckpointUnsupported
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return ErrCheckpointExists
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// Lock writer.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err := <-db.compPerErrC:
+		return err
+	case <-db.closeC:
+		return ErrClosed
+	}
+	defer func() {
+		<-db.writeLockC
+	}()
+
+	// Pause memdb flushes first, as they pause table compaction themselves
+	// while running.
+	var resumeCs []chan struct{}
+	defer func() {
+		for _, resumeC := range resumeCs {
+			select {
+			case <-resumeC:
+				close(resumeC)
+			case <-db.closeC:
+				return
+			}
+		}
+	}()
+	for _, pauseC := range []chan chan<- struct{}{db.mcompPauseC, db.mcompPauseCs, db.tcompPauseC, db.tcompPauseCs} {
+		resumeC := make(chan struct{})
+		select {
+		case pauseC <- (chan<- struct{})(resumeC):
+		case err := <-db.compPerErrC:
+			return err
+		case <-db.closeC:
+			return ErrClosed
+		}
+		resumeCs = append(resumeCs, resumeC)
+	}
+
+	start := time.Now()
+	nt, err := db.checkpoint(ex, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		db.logf("db@checkpoint failed %s E·%q", dir, err)
+		return err
+	}
+	db.logf("db@checkpoint done %s F·%d T·%v", dir, nt, time.Since(start))
+	return nil
+}
+
+// Exports the files of the DB into dir, and returns the number of linked
+// tables. Must be called with the writer locked and all compactions paused.
+func (db *DB) checkpoint(ex storage.Exporter, dir string) (int, error) {
+	dst, err := storage.OpenFile(dir, false)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	v := db.s.version()
+	defer v.release()
+	var nt int
+	for _, tables := range v.levels {
+		for _, t := range tables {
+			if err := ex.Link(t.fd, dir); err != nil {
+				return nt, err
+			}
+			nt++
+		}
+	}
+	for _, tables := range v.level_s {
+		for _, t := range tables {
+			if err := ex.Link(t.fd, dir); err != nil {
+				return nt, err
+			}
+			nt++
+		}
+	}
+	if err := db.exportBlobs(ex, dir); err != nil {
+		return nt, err
+	}
+
+	// The journals from the one of the frozen memdb on are replayed when
+	// the checkpoint is opened, the same ones checkAndCleanFiles keeps.
+	db.memMu.RLock()
+	journalNum, journalNum2 := db.journalFd.Num, db.journalFd2.Num
+	if !db.frozenJournalFd.Zero() {
+		journalNum = db.frozenJournalFd.Num
+	}
+	if !db.frozenJournalFd2.Zero() {
+		journalNum2 = db.frozenJournalFd2.Num
+	}
+	db.memMu.RUnlock()
+	fds, err := db.s.stor.List(storage.TypeJournal | storage.TypeJournals)
+	if err != nil {
+		return nt, err
+	}
+	for _, fd := range fds {
+		if (fd.Type == storage.TypeJournal && fd.Num < journalNum) || (fd.Type == storage.TypeJournals && fd.Num < journalNum2) {
+			continue
+		}
+		if err := ex.Copy(fd, dir); err != nil {
+			return nt, err
+		}
+	}
+	if err := db.frz.export(ex, dir); err != nil {
+		return nt, err
+	}
+
+	// CURRENT is written last, a checkpoint without it doesn't open.
+	manifestFd := db.s.manifestFd
+	if err := ex.Copy(manifestFd, dir); err != nil {
+		return nt, err
+	}
+	return nt, dst.SetMeta(manifestFd)
+}
+
+// Links the blob files into dir, but the ones still written and the ones
+// already rewritten by the GC.
+func (db *DB) exportBlobs(ex storage.Exporter, dir string) error {
+	// Obsolete files are removed with blobMu held.
+	db.blobMu.Lock()
+	defer db.blobMu.Unlock()
+	fds, err := db.s.stor.List(storage.TypeBlob)
+	if err != nil {
+		return err
+	}
+	for _, fd := range fds {
+		if _, obsolete := db.blobObsolete[fd.Num]; obsolete || db.s.tops.blobPendingFile(fd.Num) {
+			continue
+		}
+		if err := ex.Link(fd, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -189,6 +189,7 @@ func (db *DB) compactionTransact(name string, t compactionTransactInterface) {
 		err := t.run(&cnt)
 		if err != nil {
 			db.logf("%s error I·%d %q", name, cnt, err)
+			db.s.o.GetListener().OnBackgroundError(opt.BackgroundErrorInfo{Tree: opt.PrimaryTree, Job: name, Err: err})
 		}
 
 		// Set compaction error status.
@@ -273,6 +274,7 @@ func (db *DB) compactionTransact_s(name string, t compactionTransactInterface) {
 		err := t.run_s(&cnt) //核心处理逻辑
 		if err != nil {
 			db.logf("%s error I·%d %q", name, cnt, err)
+			db.s.o.GetListener().OnBackgroundError(opt.BackgroundErrorInfo{Tree: opt.SecondaryTree, Job: name, Err: err})
 		}
 
 		// Set compaction error status.
@@ -390,6 +392,10 @@ func (db *DB) memCompaction() {
 		db.dropFrozenMem()
 		return
 	}
+	info := opt.FlushInfo{Tree: opt.PrimaryTree, Entries: mdb.Len(), Size: mdb.Size()}
+	db.s.o.GetListener().OnFlushBegin(info)
+	start := time.Now()
+
 	//	fmt.Println("中断tablecompaction")
 	//中断tablecompaction，由此可知tablecompaction与memcompaction不会同时进行。
 	resumeC := make(chan struct{})
@@ -439,9 +445,12 @@ func (db *DB) memCompaction() {
 	// Save compaction stats
 	for _, r := range rec.addedTables {
 		stats.write += r.size
+		info.FileNum, info.TableSize = r.num, r.size
 	}
 	db.compStats.addStat(flushLevel, stats)
 	atomic.AddUint32(&db.memComp, 1)
+	info.Level, info.Duration = flushLevel, time.Since(start)
+	db.s.o.GetListener().OnFlushEnd(info)
 
 	// Drop frozen memdb.minor compaction之后把指向frozon的memory重新放回mempool中
 	db.dropFrozenMem()
@@ -477,6 +486,10 @@ func (db *DB) memCompaction_s() {
 		db.dropFrozenMem_s()
 		return
 	}
+	info := opt.FlushInfo{Tree: opt.SecondaryTree, Entries: mdb.Len_s(), Size: mdb.Size_s()}
+	db.s.o.GetListener().OnFlushBegin(info)
+	start := time.Now()
+
 	//fmt.Print(" 中断tablecompaction ")
 	//中断tablecompaction，由此可知tablecompaction与memcompaction不会同时进行。
 	resumeC := make(chan struct{})
@@ -527,9 +540,12 @@ func (db *DB) memCompaction_s() {
 	// Save compaction stats
 	for _, r := range rec.addedTabless {
 		stats.write += r.size
+		info.FileNum, info.TableSize = r.num, r.size
 	}
 	db.compStats.addStat(flushLevel, stats)
 	atomic.AddUint32(&db.memComps, 1) //记录合并次数
+	info.Level, info.Duration = flushLevel, time.Since(start)
+	db.s.o.GetListener().OnFlushEnd(info)
 
 	// Drop frozen memdb.
 	db.dropFrozenMem_s()
@@ -562,35 +578,45 @@ type tableCompactionBuilder struct {
 	snapIter        int
 	snapKerrCnt     int
 	snapDropCnt     int
+	snapRangeLower  []byte
 
 	kerrCnt int
 	dropCnt int
 
+	// Range tombstones of the input tables. rangeDels are the ones still
+	// needed, written clipped to each output table from rangeLower on.
+	rangeDelIdx *rangeDelIndex
+	rangeDels   []rangeTombstone
+	rangeLower  []byte
+
 	minSeq    uint64
+	filterSeq uint64 // entries newer than it are seen by no snapshot
 	strict    bool
 	tableSize int
 
 	tw *tWriter
 }
 
+func (b *tableCompactionBuilder) newTable() (err error) {
+	// Check for pause event.
+	if b.db != nil {
+		select {
+		case ch := <-b.db.tcompPauseC:
+			b.db.pauseCompaction(ch)
+		case <-b.db.closeC:
+			b.db.compactionExitTransact()
+		default:
+		}
+	}
+
+	// Create new table.
+	b.tw, err = b.s.tops.create(b.c.sourceLevel + 1)
+	return
+}
 func (b *tableCompactionBuilder) appendKV(key, value []byte) error {
 	// Create new table if not already.
 	if b.tw == nil {
-		// Check for pause event.
-		if b.db != nil {
-			select {
-			case ch := <-b.db.tcompPauseC:
-				b.db.pauseCompaction(ch)
-			case <-b.db.closeC:
-				b.db.compactionExitTransact()
-			default:
-			}
-		}
-
-		// Create new table.
-		var err error
-		b.tw, err = b.s.tops.create()
-		if err != nil {
+		if err := b.newTable(); err != nil {
 			return err
 		}
 	}
@@ -598,24 +624,26 @@ func (b *tableCompactionBuilder) appendKV(key, value []byte) error {
 	// Write key/value into table.
 	return b.tw.append(key, value)
 }
+func (b *tableCompactionBuilder) newTable_s() (err error) {
+	// Check for pause event.
+	if b.db != nil {
+		select {
+		case ch := <-b.db.tcompPauseCs:
+			b.db.pauseCompaction(ch)
+		case <-b.db.closeC:
+			b.db.compactionExitTransact()
+		default:
+		}
+	}
+
+	// Create new table.
+	b.tw, err = b.s.tops.create_s(b.c.sourceLevel + 1)
+	return
+}
 func (b *tableCompactionBuilder) appendKV_s(key, value []byte) error {
 	// Create new table if not already.
 	if b.tw == nil {
-		// Check for pause event.
-		if b.db != nil {
-			select {
-			case ch := <-b.db.tcompPauseCs:
-				b.db.pauseCompaction(ch)
-			case <-b.db.closeC:
-				b.db.compactionExitTransact()
-			default:
-			}
-		}
-
-		// Create new table.
-		var err error
-		b.tw, err = b.s.tops.create()
-		if err != nil {
+		if err := b.newTable_s(); err != nil {
 			return err
 		}
 	}
@@ -627,7 +655,85 @@ func (b *tableCompactionBuilder) needFlush() bool {
 	return b.tw.tw.BytesLen() >= b.tableSize
 }
 
-func (b *tableCompactionBuilder) flush() error { //run逻辑
+// Loads the range tombstones of the input tables. A tombstone seen by every
+// snapshot is dropped from the output once no deeper level holds keys it
+// covers, as the entries it deletes here are dropped too.
+func (b *tableCompactionBuilder) loadRangeDels() error {
+	var rts []rangeTombstone
+	for _, tables := range b.c.levels {
+		for _, t := range tables {
+			x, err := b.s.tops.rangeTombstones(t)
+			if err != nil {
+				return err
+			}
+			rts = append(rts, x...)
+		}
+	}
+	b.setRangeDels(rts, b.c.baseLevelForRange)
+	return nil
+}
+func (b *tableCompactionBuilder) loadRangeDels_s() error {
+	var rts []rangeTombstone
+	for _, tables := range b.c.level_s {
+		for _, t := range tables {
+			x, err := b.s.tops.rangeTombstones_s(t)
+			if err != nil {
+				return err
+			}
+			rts = append(rts, x...)
+		}
+	}
+	b.setRangeDels(rts, b.c.baseLevelForRange_s)
+	return nil
+}
+
+func (b *tableCompactionBuilder) setRangeDels(rts []rangeTombstone, baseLevel func(umin, umax []byte) bool) {
+	b.rangeDelIdx = newRangeDelIndex(b.s.icmp, rts, b.minSeq)
+	b.rangeDels = b.rangeDels[:0]
+	for _, rt := range rts {
+		if rt.seq <= b.minSeq && baseLevel(rt.start, rt.limit) {
+			continue
+		}
+		b.rangeDels = append(b.rangeDels, rt)
+	}
+}
+
+// Returns true if some kept range tombstone reaches past rangeLower, so the
+// last table is needed even without entries.
+func (b *tableCompactionBuilder) needRangeDelTable() bool {
+	for _, rt := range b.rangeDels {
+		if b.rangeLower == nil || b.s.icmp.uCompare(rt.limit, b.rangeLower) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Appends the kept range tombstones, clipped to the keys from rangeLower up
+// to upper, to the current table, nil meaning unbounded. Clipping keeps
+// the output tables from overlapping each other.
+func (b *tableCompactionBuilder) appendRangeTombstones(upper []byte) error {
+	for _, rt := range b.rangeDels {
+		if b.rangeLower != nil && b.s.icmp.uCompare(rt.start, b.rangeLower) < 0 {
+			rt.start = b.rangeLower
+		}
+		if upper != nil && b.s.icmp.uCompare(rt.limit, upper) > 0 {
+			rt.limit = upper
+		}
+		if err := b.tw.appendRangeTombstone(rt); err != nil {
+			return err
+		}
+	}
+	if upper != nil {
+		b.rangeLower = append(make([]byte, 0, len(upper)), upper...)
+	}
+	return nil
+}
+
+func (b *tableCompactionBuilder) flush(upper []byte) error { //run逻辑
+	if err := b.appendRangeTombstones(upper); err != nil {
+		return err
+	}
 	t, err := b.tw.finish() //生成一个tfile
 	if err != nil {
 		return err
@@ -638,7 +744,10 @@ func (b *tableCompactionBuilder) flush() error { //run逻辑
 	b.tw = nil
 	return nil
 } //跑在run里面
-func (b *tableCompactionBuilder) flush_s() error { //run_s逻辑
+func (b *tableCompactionBuilder) flush_s(upper []byte) error { //run_s逻辑
+	if err := b.appendRangeTombstones(upper); err != nil {
+		return err
+	}
 	t, err := b.tw.finish_s() //sfile
 	if err != nil {
 		return err
@@ -666,15 +775,20 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 	lastSeq := b.snapLastSeq
 	b.kerrCnt = b.snapKerrCnt
 	b.dropCnt = b.snapDropCnt
+	b.rangeLower = b.snapRangeLower
 	// Restore compaction state.
 	b.c.restore()
 
 	defer b.cleanup()
 
+	if err := b.loadRangeDels(); err != nil {
+		return err
+	}
+
 	b.stat1.startTimer()
 	defer b.stat1.stopTimer()
 	//read
-	iter := b.c.newIterator()
+	iter := &compactionIterator{Iterator: b.c.newIterator()}
 	defer iter.Release()
 	//sort，按照key的大小读出来，iter.Next就是当前打开文件的最小key
 	for i := 0; iter.Next(); i++ {
@@ -703,7 +817,7 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 
 				// Only rotate tables if ukey doesn't hop across.
 				if b.tw != nil && (shouldStop || b.needFlush()) {
-					if err := b.flush(); err != nil {
+					if err := b.flush(ukey); err != nil {
 						return err
 					}
 
@@ -715,14 +829,19 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 					b.snapIter = i
 					b.snapKerrCnt = b.kerrCnt
 					b.snapDropCnt = b.dropCnt
+					b.snapRangeLower = b.rangeLower
 				}
 
 				hasLastUkey = true
 				lastUkey = append(lastUkey[:0], ukey...)
 				lastSeq = keyMaxSeq
 			}
+			newest := lastSeq == keyMaxSeq
 
 			switch {
+			case b.rangeDelIdx.seq(ukey, b.minSeq) > seq:
+				// Deleted by a range tombstone seen by every snapshot.
+				fallthrough
 			case lastSeq <= b.minSeq:
 				// Dropped because newer entry for same user key exist
 				fallthrough // (A)
@@ -739,6 +858,30 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 				continue
 			default:
 				lastSeq = seq
+				if kt != keyTypeMerge || seq > b.minSeq {
+					break
+				}
+				if b.s.o.GetMergeOperator() == nil {
+					// Keep the older entries too, reads of the key fail.
+					lastSeq = keyMaxSeq
+					break
+				}
+				n, err := b.foldMerge(iter, ukey, seq)
+				if err != nil {
+					return err
+				}
+				for ; n > 0; n-- {
+					cnt.incr()
+					i++
+				}
+				continue
+			}
+			if newest {
+				if filtered, err := b.filterEntry(opt.PrimaryTree, ukey, seq, kt, iter.Value()); err != nil {
+					return err
+				} else if filtered {
+					continue
+				}
 			}
 		} else {
 			if b.strict {
@@ -761,9 +904,15 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 		return err
 	}
 
-	// Finish last table.
-	if b.tw != nil && !b.tw.empty() {
-		return b.flush()
+	// Finish last table, creating one for the range tombstones past the
+	// last key if needed.
+	if b.tw == nil && b.needRangeDelTable() {
+		if err := b.newTable(); err != nil {
+			return err
+		}
+	}
+	if b.tw != nil {
+		return b.flush(nil)
 	}
 	return nil
 }
@@ -775,11 +924,16 @@ func (b *tableCompactionBuilder) run_s(cnt *compactionTransactCounter) error {
 	lastSeq := b.snapLastSeq
 	b.kerrCnt = b.snapKerrCnt
 	b.dropCnt = b.snapDropCnt
+	b.rangeLower = b.snapRangeLower
 	// Restore compaction state.
 	b.c.restore() //ref--
 
 	defer b.cleanup()
 
+	if err := b.loadRangeDels_s(); err != nil {
+		return err
+	}
+
 	b.stat0.startTimer()
 	defer b.stat0.stopTimer()
 	//read
@@ -812,7 +966,7 @@ func (b *tableCompactionBuilder) run_s(cnt *compactionTransactCounter) error {
 
 				// Only rotate tables if ukey doesn't hop across.
 				if b.tw != nil && (shouldStop || b.needFlush()) {
-					if err := b.flush_s(); err != nil {
+					if err := b.flush_s(ukey); err != nil {
 						return err
 					}
 
@@ -824,14 +978,19 @@ func (b *tableCompactionBuilder) run_s(cnt *compactionTransactCounter) error {
 					b.snapIter = i
 					b.snapKerrCnt = b.kerrCnt
 					b.snapDropCnt = b.dropCnt
+					b.snapRangeLower = b.rangeLower
 				}
 
 				hasLastUkey = true
 				lastUkey = append(lastUkey[:0], ukey...)
 				lastSeq = keyMaxSeq
 			}
+			newest := lastSeq == keyMaxSeq
 
 			switch {
+			case b.rangeDelIdx.seq(ukey, b.minSeq) > seq:
+				// Deleted by a range tombstone seen by every snapshot.
+				fallthrough
 			case lastSeq <= b.minSeq:
 				// Dropped because newer entry for same user key exist
 				fallthrough // (A)
@@ -849,6 +1008,13 @@ func (b *tableCompactionBuilder) run_s(cnt *compactionTransactCounter) error {
 			default:
 				lastSeq = seq
 			}
+			if newest {
+				if filtered, err := b.filterEntry(opt.SecondaryTree, ukey, seq, kt, iter.Value()); err != nil {
+					return err
+				} else if filtered {
+					continue
+				}
+			}
 		} else {
 			if b.strict {
 				return kerr
@@ -870,9 +1036,15 @@ func (b *tableCompactionBuilder) run_s(cnt *compactionTransactCounter) error {
 		return err
 	}
 
-	// Finish last table.
-	if b.tw != nil && !b.tw.empty() {
-		return b.flush_s()
+	// Finish last table, creating one for the range tombstones past the
+	// last key if needed.
+	if b.tw == nil && b.needRangeDelTable() {
+		if err := b.newTable_s(); err != nil {
+			return err
+		}
+	}
+	if b.tw != nil {
+		return b.flush_s(nil)
 	}
 	return nil
 }
@@ -891,6 +1063,7 @@ func (b *tableCompactionBuilder) revert_s() error {
 		if err := b.s.stor.Remove(storage.FileDesc{Type: storage.TypeTable, Num: at.num}); err != nil {
 			return err
 		}
+		b.s.tops.dropBlob(at.num)
 	}
 	return nil
 }
@@ -905,12 +1078,18 @@ func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 	rec := &sessionRecord{}
 	rec.addCompPtr(c.sourceLevel, c.imax) //rec.compPtrs = append(p.compPtrs, cpRecord{level, ikey})
 
+	start := time.Now()
+	info := opt.CompactionInfo{Tree: opt.PrimaryTree, Level: c.sourceLevel}
 	if !noTrivial && c.trivial() {
 		t := c.levels[0][0]
 		db.logf("table@move L%d@%d -> L%d", c.sourceLevel, t.fd.Num, c.sourceLevel+1)
+		info.Trivial, info.Inputs, info.InputBytes = true, []int64{t.fd.Num}, t.size
+		db.s.o.GetListener().OnCompactionBegin(info)
 		rec.delTable(c.sourceLevel, t.fd.Num)
 		rec.addTableFile(c.sourceLevel+1, t)
 		db.compactionCommit("table-move", rec)
+		info.Outputs, info.Duration = info.Inputs, time.Since(start)
+		db.s.o.GetListener().OnCompactionEnd(info)
 		return
 	}
 
@@ -920,12 +1099,25 @@ func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 			stats[i].read += t.size
 			// Insert deleted tables into record
 			rec.delTable(c.sourceLevel+i, t.fd.Num)
+			info.Inputs = append(info.Inputs, t.fd.Num)
+			info.InputBytes += t.size
 		}
 	}
+	db.s.o.GetListener().OnCompactionBegin(info)
 	sourceSize := int(stats[0].read + stats[1].read)
 	minSeq := db.minSeq()
 	db.logf("table@compaction L%d·%d -> L%d·%d S·%s Q·%d", c.sourceLevel, len(c.levels[0]), c.sourceLevel+1, len(c.levels[1]), shortenb(sourceSize), minSeq)
 
+	// Tables deleted by a range tombstone are dropped unread, they are already
+	// in the record.
+	if dropped, err := c.dropCoveredTables(minSeq); err != nil {
+		db.logf("table@drop error E·%q", err)
+	} else {
+		for _, t := range dropped {
+			db.logf("table@drop L%d@%d covered by range tombstone", c.sourceLevel+1, t.fd.Num)
+		}
+	}
+
 	b := &tableCompactionBuilder{
 		db:        db,
 		s:         db.s,
@@ -933,6 +1125,7 @@ func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 		rec:       rec,
 		stat1:     &stats[1],
 		minSeq:    minSeq,
+		filterSeq: db.maxSnapshotSeq(),
 		strict:    db.s.o.GetStrict(opt.StrictCompaction),
 		tableSize: db.s.o.GetCompactionTableSize(c.sourceLevel + 1),
 	}
@@ -951,6 +1144,12 @@ func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 	for i := range stats {
 		db.compStats.addStat(c.sourceLevel+1, &stats[i])
 	}
+	for _, r := range rec.addedTables {
+		info.Outputs = append(info.Outputs, r.num)
+		info.OutputBytes += r.size
+	}
+	info.Duration = time.Since(start)
+	db.s.o.GetListener().OnCompactionEnd(info)
 	switch c.typ {
 	case level0Compaction:
 		atomic.AddUint32(&db.level0Comp, 1)
@@ -966,12 +1165,18 @@ func (db *DB) tableCompaction_s(c *compaction, noTrivial bool) {
 	rec := &sessionRecord{}
 	rec.addCompPtr_s(c.sourceLevel, c.imax) //这里是每次合并的断点？
 
+	start := time.Now()
+	info := opt.CompactionInfo{Tree: opt.SecondaryTree, Level: c.sourceLevel}
 	if !noTrivial && c.trivial_s() {
 		t := c.level_s[0][0] //合并的那一层的第一个sfile？
 		db.logf("table@move L%d@%d -> L%d", c.sourceLevel, t.fd.Num, c.sourceLevel+1)
+		info.Trivial, info.Inputs, info.InputBytes = true, []int64{t.fd.Num}, t.size
+		db.s.o.GetListener().OnCompactionBegin(info)
 		rec.delTable_s(c.sourceLevel, t.fd.Num)
 		rec.addTableFile_s(c.sourceLevel+1, t)
 		db.compactionCommit_s("table-move", rec)
+		info.Outputs, info.Duration = info.Inputs, time.Since(start)
+		db.s.o.GetListener().OnCompactionEnd(info)
 		return
 	}
 
@@ -981,12 +1186,25 @@ func (db *DB) tableCompaction_s(c *compaction, noTrivial bool) {
 			stats[i].read += t.size
 			// Insert deleted tables into record,~~~~i取值0、1,把要删除的两层的文件记录，放入deletedtabless中
 			rec.delTable_s(c.sourceLevel+i, t.fd.Num)
+			info.Inputs = append(info.Inputs, t.fd.Num)
+			info.InputBytes += t.size
 		}
 	}
+	db.s.o.GetListener().OnCompactionBegin(info)
 	sourceSize := int(stats[0].read + stats[1].read)
 	minSeq := db.minSeq()
 	db.logf("table@compaction L%d·%d -> L%d·%d S·%s Q·%d", c.sourceLevel, len(c.level_s[0]), c.sourceLevel+1, len(c.level_s[1]), shortenb(sourceSize), minSeq)
 
+	// Tables deleted by a range tombstone are dropped unread, they are already
+	// in the record.
+	if dropped, err := c.dropCoveredTables_s(minSeq); err != nil {
+		db.logf("table@drop error E·%q", err)
+	} else {
+		for _, t := range dropped {
+			db.logf("table@drop L%d@%d covered by range tombstone", c.sourceLevel+1, t.fd.Num)
+		}
+	}
+
 	b := &tableCompactionBuilder{
 		db:        db,
 		s:         db.s,
@@ -994,8 +1212,9 @@ func (db *DB) tableCompaction_s(c *compaction, noTrivial bool) {
 		rec:       rec,
 		stat0:     &stats[1], //第二层
 		minSeq:    minSeq,
+		filterSeq: db.maxSnapshotSeq(),
 		strict:    db.s.o.GetStrict(opt.StrictCompaction),
-		tableSize: db.s.o.GetCompactionTableSize(c.sourceLevel + 1),
+		tableSize: db.s.o_s.GetCompactionTableSize(c.sourceLevel + 1),
 	}
 	//将需要合并的表读出来，排序，写到新表,这是build的重点
 	db.compactionTransact_s("table@build", b) //addedtabless应该是记录新的sfiles了
@@ -1012,6 +1231,12 @@ func (db *DB) tableCompaction_s(c *compaction, noTrivial bool) {
 	for i := range stats {
 		db.compStats.addStat(c.sourceLevel+1, &stats[i])
 	}
+	for _, r := range rec.addedTabless {
+		info.Outputs = append(info.Outputs, r.num)
+		info.OutputBytes += r.size
+	}
+	info.Duration = time.Since(start)
+	db.s.o.GetListener().OnCompactionEnd(info)
 	switch c.typ {
 	case level0Compaction:
 		atomic.AddUint32(&db.level0Comps, 1)
@@ -1133,7 +1358,7 @@ func (db *DB) resumeWrite() bool {
 func (db *DB) resumeWrite_s() bool {
 	v := db.s.version()
 	defer v.release()
-	if v.tLen_s(0) < db.s.o.GetWriteL0PauseTrigger2() { //12,如果l0有12个，就停止写入
+	if v.tLen_s(0) < db.s.o_s.GetWriteL0PauseTrigger() { //12,如果l0有12个，就停止写入
 		return true
 	}
 	return false
@@ -1281,6 +1506,8 @@ func (db *DB) mCompaction() {
 			default:
 				panic("leveldb: unknown command")
 			}
+		case ch := <-db.mcompPauseC:
+			db.pauseCompaction(ch)
 		case <-db.closeC:
 			return
 		}
@@ -1312,6 +1539,8 @@ func (db *DB) mCompaction_s() {
 			default:
 				panic("leveldb: unknown command")
 			}
+		case ch := <-db.mcompPauseCs:
+			db.pauseCompaction(ch)
 		case <-db.closeC:
 			return
 		}
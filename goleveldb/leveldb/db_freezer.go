@@ -0,0 +1,246 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+
+	"awesomeProject1/goleveldb/leveldb/util"
+)
+
+const (
+	// Interval between two freezer runs, unless the last one hit the batch
+	// limit.
+	freezerRecheckInterval = time.Minute
+
+	// Maximum number of blocks moved by one freezer run.
+	freezerBatchLimit = 30000
+
+	// Number of deletions written at once by deleteFrozen.
+	freezerDeleteChunkLen = 1000
+)
+
+// Keys of the chain data moved to the freezer, following the geth rawdb
+// schema.
+var (
+	headBlockKey       = []byte("LastBlock") // -> hash of the head block
+	headerNumberPrefix = []byte("H")         // H + hash -> num (uint64 big endian)
+	headerPrefix       = []byte("h")         // h + num + hash -> header
+	headerTDSuffix     = []byte("t")         // h + num + hash + t -> td
+	headerHashSuffix   = []byte("n")         // h + num + n -> hash
+	blockBodyPrefix    = []byte("b")         // b + num + hash -> body
+	blockReceiptPrefix = []byte("r")         // r + num + hash -> receipts
+)
+
+func chainKey(prefix []byte, number uint64, suffix ...[]byte) []byte {
+	key := binary.BigEndian.AppendUint64(append([]byte(nil), prefix...), number)
+	for _, s := range suffix {
+		key = append(key, s...)
+	}
+	return key
+}
+
+// chainGet gets the chain data of the given key from the tree holding it.
+// The tree KeyRouter picks is read first, then the other one, as the chain
+// data, e.g. bodies and receipts, may be written to the secondary tree with
+// Put_s.
+func (db *DB) chainGet(key []byte) ([]byte, error) {
+	se := db.acquireSnapshot()
+	defer db.releaseSnapshot(se)
+	tree := db.routeTree(key)
+	value, err := db.treeGet(tree, key, se.seq)
+	if err == ErrNotFound {
+		other := SecondaryTree
+		if tree == SecondaryTree {
+			other = PrimaryTree
+		}
+		value, err = db.treeGet(other, key, se.seq)
+	}
+	return value, err
+}
+
+// chainHead returns the number of the head block, ok is false if the DB
+// holds no chain.
+func (db *DB) chainHead() (number uint64, ok bool, err error) {
+	hash, err := db.chainGet(headBlockKey)
+	if err == ErrNotFound {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	enc, err := db.chainGet(append(append([]byte(nil), headerNumberPrefix...), hash...))
+	if err == ErrNotFound {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	if len(enc) != 8 {
+		return 0, false, nil
+	}
+	return binary.BigEndian.Uint64(enc), true, nil
+}
+
+// freezeBlock appends the canonical block number of the LSM to every kind of
+// the freezer. It returns ErrNotFound, with nothing appended, if part of the
+// block is missing.
+func (db *DB) freezeBlock(op ethdb.AncientWriteOp, number uint64) error {
+	hash, err := db.chainGet(chainKey(headerPrefix, number, headerHashSuffix))
+	if err != nil {
+		return err
+	}
+	items := make(map[string][]byte, len(freezerKinds))
+	items["hashes"] = hash
+	for kind, key := range map[string][]byte{
+		"headers":  chainKey(headerPrefix, number, hash),
+		"bodies":   chainKey(blockBodyPrefix, number, hash),
+		"receipts": chainKey(blockReceiptPrefix, number, hash),
+		"diffs":    chainKey(headerPrefix, number, hash, headerTDSuffix),
+	} {
+		if items[kind], err = db.chainGet(key); err != nil {
+			return err
+		}
+	}
+	for _, kind := range freezerKinds {
+		if err := op.AppendRaw(kind, number, items[kind]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// freezeChain moves the canonical blocks older than AncientDistance from the
+// LSM to the freezer, at most freezerBatchLimit of them, and returns the
+// number of blocks moved. The blocks are deleted from the LSM once the
+// freezer holds them; if that is interrupted the leftovers stay in the LSM,
+// while reads are served by the freezer, until deleteFrozenLeftovers.
+func (db *DB) freezeChain() (int, error) {
+	distance := db.s.o.GetAncientDistance()
+	if distance <= 0 {
+		return 0, nil
+	}
+	head, ok, err := db.chainHead()
+	if err != nil || !ok || head < uint64(distance) {
+		return 0, err
+	}
+	limit := head - uint64(distance) + 1
+	if frozen := db.frz.ancients(); frozen >= limit {
+		return 0, nil
+	} else if limit-frozen > freezerBatchLimit {
+		limit = frozen + freezerBatchLimit
+	}
+
+	var first, next uint64
+	_, err = db.frz.modify(func(op ethdb.AncientWriteOp) error {
+		// The freezer is locked by modify, frozen can't move.
+		first = db.frz.frozen
+		for next = first; next < limit; next++ {
+			err := db.freezeBlock(op, next)
+			if err == ErrNotFound {
+				db.logf("freezer@freeze block missing N·%d", next)
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil || next == first {
+		return 0, err
+	}
+	return int(next - first), db.deleteFrozen(first, next)
+}
+
+// deleteFrozen deletes blocks [first, next) from both trees of the LSM, side
+// chains included. The genesis block and the hash to number mappings are
+// kept.
+func (db *DB) deleteFrozen(first, next uint64) error {
+	if first == 0 {
+		first = 1
+	}
+	b := new(TreeBatch)
+	for number := first; number < next; number++ {
+		for _, prefix := range [][]byte{headerPrefix, blockBodyPrefix, blockReceiptPrefix} {
+			for _, tree := range []Tree{PrimaryTree, SecondaryTree} {
+				iter := db.treeIterator(tree, util.BytesPrefix(chainKey(prefix, number)))
+				for iter.Next() {
+					b.Delete(tree, iter.Key())
+				}
+				iter.Release()
+				if err := iter.Error(); err != nil {
+					return err
+				}
+			}
+		}
+		if b.Len() >= freezerDeleteChunkLen {
+			if err := db.WriteTree(b, nil); err != nil {
+				return err
+			}
+			b.Reset()
+		}
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	return db.WriteTree(b, nil)
+}
+
+// deleteFrozenLeftovers deletes the blocks held by the freezer which are
+// still in the LSM, left by a freezeChain interrupted before deleteFrozen was
+// done. As deleteFrozen goes by increasing number, they are the blocks from
+// the lowest one left up to the frozen count.
+func (db *DB) deleteFrozenLeftovers() error {
+	frozen := db.frz.ancients()
+	first := frozen
+	for _, prefix := range [][]byte{headerPrefix, blockBodyPrefix, blockReceiptPrefix} {
+		for _, tree := range []Tree{PrimaryTree, SecondaryTree} {
+			// The genesis block is kept.
+			iter := db.treeIterator(tree, &util.Range{Start: chainKey(prefix, 1), Limit: chainKey(prefix, first)})
+			if iter.Next() && len(iter.Key()) >= len(prefix)+8 {
+				first = binary.BigEndian.Uint64(iter.Key()[len(prefix):])
+			}
+			iter.Release()
+			if err := iter.Error(); err != nil {
+				return err
+			}
+		}
+	}
+	if first >= frozen {
+		return nil
+	}
+	db.logf("freezer@cleanup N·%d", frozen-first)
+	return db.deleteFrozen(first, frozen)
+}
+
+func (db *DB) freezeLoop() {
+	defer db.closeW.Done()
+
+	if err := db.deleteFrozenLeftovers(); err != nil && err != ErrClosed {
+		db.logf("freezer@cleanup error E·%q", err)
+	}
+	for {
+		start := time.Now()
+		n, err := db.freezeChain()
+		if err != nil && err != ErrClosed {
+			db.logf("freezer@freeze error E·%q", err)
+		} else if n > 0 {
+			db.logf("freezer@freeze done N·%d T·%v", n, time.Since(start))
+		}
+
+		wait := freezerRecheckInterval
+		if n == freezerBatchLimit {
+			wait = 0
+		}
+		select {
+		case <-time.After(wait):
+		case <-db.closeC:
+			return
+		}
+	}
+}
@@ -0,0 +1,447 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"awesomeProject1/goleveldb/leveldb/comparer"
+	"awesomeProject1/goleveldb/leveldb/errors"
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+	"awesomeProject1/goleveldb/leveldb/table"
+)
+
+// Errors returned by IngestExternalFiles.
+var (
+	ErrIngestTree    = errors.New("leveldb: invalid ingestion tree")
+	ErrIngestOverlap = errors.New("leveldb: ingested files overlap")
+)
+
+// ingestFile is an external table to ingest, its keys are user keys.
+type ingestFile struct {
+	path       string
+	size       int64
+	umin, umax []byte
+	adopt      bool // The file can be linked into the DB as is
+}
+
+// IngestExternalFiles adds the tables at paths to the given tree without
+// going through the journal and the memdb. The tables must be written by
+// SSTWriter, or by table.Writer with user keys and the user comparer of the
+// DB, hold no range tombstones and not overlap each other.
+//
+// All the entries are given the same sequence number, newer than anything in
+// the DB, and each table goes to the deepest level where no older entry of
+// its key range lives. The tables of SSTWriter with the comparer of the DB
+// are hard linked into the DB as is, the sequence number being kept in the
+// manifest; they must not be modified afterwards. The other tables, or all
+// of them if the storage can't link files, are copied and split to the
+// table size of the level. The tables are added with a single manifest
+// commit, so either all of them are visible or none is. The memdb is flushed
+// first if it overlaps the tables.
+//
+// As their sequence number isn't in the files, Recover takes linked
+// tables for tables of the primary tree older than the rest of the DB.
+func (db *DB) IngestExternalFiles(paths []string, tree Tree) error {
+	if tree > SecondaryTree {
+		return ErrIngestTree
+	}
+	if err := db.ok(); err != nil {
+		return err
+	}
+
+	var files []*ingestFile
+	for _, path := range paths {
+		f, err := db.scanIngestFile(path)
+		if err != nil {
+			return err
+		}
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	ucmp := db.s.icmp.ucmp
+	sort.Slice(files, func(i, j int) bool {
+		return ucmp.Compare(files[i].umin, files[j].umin) < 0
+	})
+	for i := 1; i < len(files); i++ {
+		if ucmp.Compare(files[i-1].umax, files[i].umin) >= 0 {
+			return ErrIngestOverlap
+		}
+	}
+
+	// Lock writer.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err := <-db.compPerErrC:
+		return err
+	case <-db.closeC:
+		return ErrClosed
+	}
+	defer func() {
+		<-db.writeLockC
+	}()
+
+	// The memdb is read before the tables, so it can't hold older entries
+	// of the ingested keys.
+	if err := db.flushIngestOverlaps(tree, files[0].umin, files[len(files)-1].umax); err != nil {
+		return err
+	}
+
+	// Pause table compaction, the levels are picked from the current version.
+	pauseC, compC := db.tcompPauseC, db.tcompCmdC
+	if tree == SecondaryTree {
+		pauseC, compC = db.tcompPauseCs, db.tcompCmdCs
+	}
+	resumeC := make(chan struct{})
+	select {
+	case pauseC <- (chan<- struct{})(resumeC):
+	case err := <-db.compPerErrC:
+		return err
+	case <-db.closeC:
+		return ErrClosed
+	}
+
+	err := db.ingest(files, tree)
+
+	// Resume table compaction.
+	select {
+	case <-resumeC:
+		close(resumeC)
+	case <-db.closeC:
+		return ErrClosed
+	}
+	if err == nil {
+		db.compTrigger(compC)
+	}
+	return err
+}
+
+// ingestReader reads an external table.
+type ingestReader struct {
+	path  string
+	fr    *os.File
+	r     *table.Reader
+	props *table.Properties
+}
+
+// Opens the external table at path.
+func (db *DB) openIngestFile(path string) (*ingestReader, error) {
+	fr, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := fr.Stat()
+	if err != nil {
+		fr.Close()
+		return nil, err
+	}
+	o := &opt.Options{Comparer: db.s.icmp.ucmp, Strict: opt.StrictBlockChecksum}
+	r, err := table.NewReader(fr, fi.Size(), storage.FileDesc{}, nil, nil, o)
+	if err != nil {
+		fr.Close()
+		return nil, err
+	}
+	ir := &ingestReader{path: path, fr: fr, r: r}
+	if ir.props, err = r.Properties(); err != nil {
+		ir.release()
+		return nil, err
+	}
+	if rangeTombstonesOverlap(db.s.icmp, r.NewRangeTombstoneIterator(), nil, nil) {
+		ir.release()
+		return nil, ErrRangeDelUnsupported
+	}
+	return ir, nil
+}
+
+func (ir *ingestReader) release() {
+	ir.r.Release()
+	ir.fr.Close()
+}
+
+// Calls fn with the user keys of the table, their sequence numbers and their
+// values, checking that the keys are sorted. The keys of a table of SSTWriter
+// must be put entries, those of other tables have sequence number zero.
+func (ir *ingestReader) iterate(ucmp comparer.Comparer, fn func(ukey []byte, seq uint64, value []byte) error) error {
+	iter := ir.r.NewIterator(nil, nil)
+	defer iter.Release()
+	var last []byte
+	for n := 0; iter.Next(); n++ {
+		key, seq := iter.Key(), uint64(0)
+		if ir.props != nil && ir.props.InternalKeys {
+			ukey, kseq, kt, kerr := parseInternalKey(key)
+			if kerr != nil || kt != keyTypeVal {
+				return fmt.Errorf("leveldb: ingested file %s: bad internal key", ir.path)
+			}
+			key, seq = ukey, kseq
+		}
+		if n > 0 && ucmp.Compare(last, key) >= 0 {
+			return fmt.Errorf("leveldb: ingested file %s: keys are not sorted", ir.path)
+		}
+		if err := fn(key, seq, iter.Value()); err != nil {
+			return err
+		}
+		last = append(last[:0], key...)
+	}
+	return iter.Error()
+}
+
+// Returns the key range of the external table at path, or nil if it is
+// empty. Tables without properties are read through to find it, and the
+// tables that may be adopted as is through to validate their keys.
+func (db *DB) scanIngestFile(path string) (*ingestFile, error) {
+	ir, err := db.openIngestFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer ir.release()
+
+	fi, err := ir.fr.Stat()
+	if err != nil {
+		return nil, err
+	}
+	f := &ingestFile{path: path, size: fi.Size()}
+	if p := ir.props; p != nil {
+		if p.NumEntries == 0 {
+			return nil, nil
+		}
+		f.umin, f.umax = p.SmallestKey, p.LargestKey
+		if !p.InternalKeys || p.Comparer != db.s.icmp.uName() {
+			return f, nil
+		}
+		// Only entries of sequence number zero can be read with another.
+		f.adopt = true
+		err := ir.iterate(db.s.icmp.ucmp, func(_ []byte, seq uint64, _ []byte) error {
+			if seq != 0 {
+				f.adopt = false
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+
+	n := 0
+	err = ir.iterate(db.s.icmp.ucmp, func(ukey []byte, _ uint64, _ []byte) error {
+		if n == 0 {
+			f.umin = append([]byte{}, ukey...)
+		}
+		f.umax = append(f.umax[:0], ukey...)
+		n++
+		return nil
+	})
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	if f.umax == nil {
+		f.umax = []byte{}
+	}
+	return f, nil
+}
+
+// Flushes the memdbs of the tree if they may hold keys from umin to umax.
+func (db *DB) flushIngestOverlaps(tree Tree, umin, umax []byte) error {
+	if tree == SecondaryTree {
+		mdb := db.getEffectiveMem_s()
+		if mdb == nil {
+			return ErrClosed
+		}
+		defer mdb.decref_s()
+		if isMemOverlaps_s(db.s.icmp, mdb.DBs, umin, umax) {
+			_, err := db.rotateMem_s(0, true)
+			return err
+		}
+		return db.compTriggerWait(db.mcompCmdCs)
+	}
+	mdb := db.getEffectiveMem()
+	if mdb == nil {
+		return ErrClosed
+	}
+	defer mdb.decref()
+	if isMemOverlaps(db.s.icmp, mdb.DB, umin, umax) {
+		_, err := db.rotateMem(0, true)
+		return err
+	}
+	return db.compTriggerWait(db.mcompCmdC)
+}
+
+// Adopts or copies the files into tables and commits them. Must be called
+// with the writer locked and table compaction of the tree paused.
+func (db *DB) ingest(files []*ingestFile, tree Tree) (err error) {
+	start := time.Now()
+	seq := db.seq + 1
+	rec := &sessionRecord{}
+	defer func() {
+		if err != nil {
+			db.revertIngest(rec)
+		}
+	}()
+
+	o := db.s.o
+	if tree == SecondaryTree {
+		o = db.s.o_s
+	}
+	var size int64
+	for _, f := range files {
+		size += f.size
+	}
+	v := db.s.version()
+	defer v.release()
+	for _, f := range files {
+		var level int
+		if tree == SecondaryTree {
+			level = v.pickIngestLevel_s(f.umin, f.umax, ingestMaxLevel(o.Options, len(v.level_s), size))
+		} else {
+			level = v.pickIngestLevel(f.umin, f.umax, ingestMaxLevel(o.Options, len(v.levels), size))
+		}
+		if err = db.ingestFile(rec, f, tree, seq, level, o.GetCompactionTableSize(level)); err != nil {
+			return
+		}
+	}
+
+	rec.setSeqNum(seq)
+	db.compCommitLk.Lock()
+	err = db.s.commit(rec, false)
+	db.compCommitLk.Unlock()
+	if err != nil {
+		return
+	}
+	db.setSeq(seq)
+	db.logf("table@ingest committed F·%d Q·%d T·%v", len(rec.addedTables)+len(rec.addedTabless), seq, time.Since(start))
+	return nil
+}
+
+// Adds f to the given level as a table whose entries are read with seq, or
+// copies it into tables of the level, giving every entry seq.
+func (db *DB) ingestFile(rec *sessionRecord, f *ingestFile, tree Tree, seq uint64, level, tableSize int) error {
+	if f.adopt && db.adoptIngestFile(rec, f, tree, seq, level) {
+		return nil
+	}
+
+	ir, err := db.openIngestFile(f.path)
+	if err != nil {
+		return err
+	}
+	defer ir.release()
+
+	var tw *tWriter
+	defer func() {
+		if tw != nil {
+			tw.drop()
+		}
+	}()
+	finish := func() error {
+		n := tw.tw.EntriesLen()
+		if tree == SecondaryTree {
+			t, err := tw.finish_s()
+			if err != nil {
+				return err
+			}
+			rec.addTableFile_s(level, t)
+			db.logf("table@ingest created L%d@%d N·%d S·%s %q:%q", level, t.fd.Num, n, shortenb(int(t.size)), t.imin, t.imax)
+		} else {
+			t, err := tw.finish()
+			if err != nil {
+				return err
+			}
+			rec.addTableFile(level, t)
+			db.logf("table@ingest created L%d@%d N·%d S·%s %q:%q", level, t.fd.Num, n, shortenb(int(t.size)), t.imin, t.imax)
+		}
+		tw = nil
+		return nil
+	}
+
+	var ikey internalKey
+	err = ir.iterate(db.s.icmp.ucmp, func(ukey []byte, _ uint64, value []byte) (err error) {
+		if tw == nil {
+			if tree == SecondaryTree {
+				tw, err = db.s.tops.create_s(level)
+			} else {
+				tw, err = db.s.tops.create(level)
+			}
+			if err != nil {
+				return
+			}
+		}
+		ikey = makeInternalKey(ikey, ukey, seq, keyTypeVal)
+		if err = tw.append(ikey, value); err != nil {
+			return
+		}
+		if tw.tw.BytesLen() >= tableSize {
+			err = finish()
+		}
+		return
+	})
+	if err == nil && tw != nil {
+		err = finish()
+	}
+	return err
+}
+
+// Links f into the DB as a table of the given level whose entries are read
+// with seq, and reports whether it could. It can't if the storage doesn't
+// support it, or if f lives on another file system.
+func (db *DB) adoptIngestFile(rec *sessionRecord, f *ingestFile, tree Tree, seq uint64, level int) bool {
+	im, ok := db.s.stor.Storage.(storage.Importer)
+	if !ok {
+		return false
+	}
+	fd := storage.FileDesc{Type: storage.TypeTable, Num: db.s.allocFileNum()}
+	if err := im.Import(f.path, fd); err != nil {
+		db.logf("table@ingest linking %s failed, copying: %v", f.path, err)
+		db.s.reuseFileNum(fd.Num)
+		return false
+	}
+	imin := makeInternalKey(nil, f.umin, seq, keyTypeVal)
+	imax := makeInternalKey(nil, f.umax, seq, keyTypeVal)
+	if tree == SecondaryTree {
+		t := newTableFile_s(fd, f.size, imin, imax)
+		t.seq = seq
+		rec.addTableFile_s(level, t)
+	} else {
+		t := newTableFile(fd, f.size, imin, imax)
+		t.seq = seq
+		rec.addTableFile(level, t)
+	}
+	db.logf("table@ingest linked L%d@%d S·%s %q:%q", level, fd.Num, shortenb(int(f.size)), imin, imax)
+	return true
+}
+
+// Removes the tables created by a failed ingestion.
+func (db *DB) revertIngest(rec *sessionRecord) {
+	for _, r := range rec.addedTables {
+		db.logf("table@ingest revert @%d", r.num)
+		db.s.stor.Remove(storage.FileDesc{Type: storage.TypeTable, Num: r.num})
+	}
+	for _, r := range rec.addedTabless {
+		db.logf("table@ingest revert @%d", r.num)
+		db.s.stor.Remove(storage.FileDesc{Type: storage.TypeTable, Num: r.num})
+		db.s.tops.dropBlob(r.num)
+	}
+}
+
+// ingestMaxLevel returns the level ingested tables go to when no level holds
+// their keys: the last level of the tree, or deeper if needed to fit size.
+func ingestMaxLevel(o *opt.Options, numLevel int, size int64) int {
+	level := numLevel - 1
+	if level < 1 {
+		level = 1
+	}
+	for o.GetCompactionTotalSize(level) < size {
+		level++
+	}
+	return level
+}
@@ -33,11 +33,41 @@ func (mr *memdbReleaser) Release() {
 	})
 }
 
-func (db *DB) newRawIterator(auxm *memDB, auxt tFiles, slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+type memdbReleaser_s struct {
+	once sync.Once
+	m    *memDB
+}
+
+func (mr *memdbReleaser_s) Release() {
+	mr.once.Do(func() {
+		mr.m.decref_s()
+	})
+}
+
+// newRawIterator returns the merged iterator of the memdbs and the tables,
+// and the index of the range tombstones visible at seq in the same memdbs and
+// tables.
+func (db *DB) newRawIterator(auxm *memDB, auxt tFiles, slice *util.Range, ro *opt.ReadOptions, seq uint64) (iterator.Iterator, *rangeDelIndex) {
 	strict := opt.GetStrict(db.s.o.Options, ro, opt.StrictReader)
 	em, fm := db.getMems()
 	v := db.s.version()
 
+	mems := []*memDB{em}
+	if fm != nil {
+		mems = append(mems, fm)
+	}
+	rdi, err := db.newRangeDelIndex(mems, v, slice, seq, false)
+	if err != nil {
+		for _, m := range mems {
+			m.decref()
+		}
+		v.release()
+		if auxm != nil {
+			auxm.decref()
+		}
+		return iterator.NewEmptyIterator(err), nil
+	}
+
 	tableIts := v.getIterators(slice, ro)
 	n := len(tableIts) + len(auxt) + 3
 	its := make([]iterator.Iterator, 0, n)
@@ -62,7 +92,58 @@ func (db *DB) newRawIterator(auxm *memDB, auxt tFiles, slice *util.Range, ro *op
 	its = append(its, tableIts...)
 	mi := iterator.NewMergedIterator(its, db.s.icmp, strict)
 	mi.SetReleaser(&versionReleaser{v: v})
-	return mi
+	return mi, rdi
+}
+
+// newRawIterator_s returns the merged iterator of the memdbs and the tables,
+// and the index of the range tombstones visible at seq in the same memdbs and
+// tables.
+func (db *DB) newRawIterator_s(auxm *memDB, auxt sFiles, slice *util.Range, ro *opt.ReadOptions, seq uint64) (iterator.Iterator, *rangeDelIndex) {
+	strict := opt.GetStrict(db.s.o.Options, ro, opt.StrictReader)
+	em, fm := db.getMems_s()
+	v := db.s.version()
+
+	mems := []*memDB{em}
+	if fm != nil {
+		mems = append(mems, fm)
+	}
+	rdi, err := db.newRangeDelIndex(mems, v, slice, seq, true)
+	if err != nil {
+		for _, m := range mems {
+			m.decref_s()
+		}
+		v.release()
+		if auxm != nil {
+			auxm.decref_s()
+		}
+		return iterator.NewEmptyIterator(err), nil
+	}
+
+	tableIts := v.getIterators_s(slice, ro)
+	n := len(tableIts) + len(auxt) + 3
+	its := make([]iterator.Iterator, 0, n)
+
+	if auxm != nil {
+		ami := auxm.NewIterator_s(slice)
+		ami.SetReleaser(&memdbReleaser_s{m: auxm})
+		its = append(its, ami)
+	}
+	for _, t := range auxt {
+		its = append(its, v.s.tops.newIterator_s(t, slice, ro))
+	}
+
+	emi := em.NewIterator_s(slice)
+	emi.SetReleaser(&memdbReleaser_s{m: em})
+	its = append(its, emi)
+	if fm != nil {
+		fmi := fm.NewIterator_s(slice)
+		fmi.SetReleaser(&memdbReleaser_s{m: fm})
+		its = append(its, fmi)
+	}
+	its = append(its, tableIts...)
+	mi := iterator.NewMergedIterator(its, db.s.icmp, strict)
+	mi.SetReleaser(&versionReleaser{v: v})
+	return mi, rdi
 }
 
 func (db *DB) newIterator(auxm *memDB, auxt tFiles, seq uint64, slice *util.Range, ro *opt.ReadOptions) *dbIter {
@@ -76,11 +157,12 @@ func (db *DB) newIterator(auxm *memDB, auxt tFiles, seq uint64, slice *util.Rang
 			islice.Limit = makeInternalKey(nil, slice.Limit, keyMaxSeq, keyTypeSeek)
 		}
 	}
-	rawIter := db.newRawIterator(auxm, auxt, islice, ro)
+	rawIter, rdi := db.newRawIterator(auxm, auxt, islice, ro, seq)
 	iter := &dbIter{
 		db:              db,
 		icmp:            db.s.icmp,
 		iter:            rawIter,
+		rangeDels:       rdi,
 		seq:             seq,
 		strict:          opt.GetStrict(db.s.o.Options, ro, opt.StrictReader),
 		disableSampling: db.s.o.GetDisableSeeksCompaction() || db.s.o.GetIteratorSamplingRate() <= 0,
@@ -95,6 +177,42 @@ func (db *DB) newIterator(auxm *memDB, auxt tFiles, seq uint64, slice *util.Rang
 	return iter
 }
 
+func (db *DB) newIterator_s(auxm *memDB, auxt sFiles, seq uint64, slice *util.Range, ro *opt.ReadOptions) *dbIter {
+	var islice *util.Range
+	if slice != nil {
+		islice = &util.Range{}
+		if slice.Start != nil {
+			islice.Start = makeInternalKey(nil, slice.Start, keyMaxSeq, keyTypeSeek)
+		}
+		if slice.Limit != nil {
+			islice.Limit = makeInternalKey(nil, slice.Limit, keyMaxSeq, keyTypeSeek)
+		}
+	}
+	rawIter, rdi := db.newRawIterator_s(auxm, auxt, islice, ro, seq)
+	iter := &dbIter{
+		db:              db,
+		icmp:            db.s.icmp,
+		iter:            rawIter,
+		rangeDels:       rdi,
+		seq:             seq,
+		strict:          opt.GetStrict(db.s.o.Options, ro, opt.StrictReader),
+		disableSampling: db.s.o.GetDisableSeeksCompaction() || db.s.o.GetIteratorSamplingRate() <= 0,
+		secondary:       true,
+		key:             make([]byte, 0),
+		value:           make([]byte, 0),
+	}
+	if db.s.o.GetBlobThreshold() > 0 {
+		// The blob GC keeps the files read by the snapshot.
+		iter.se = db.refSnapshot(seq)
+	}
+	if !iter.disableSampling {
+		iter.samplingGap = db.iterSamplingRate()
+	}
+	atomic.AddInt32(&db.aliveIters, 1)
+	runtime.SetFinalizer(iter, (*dbIter).Release)
+	return iter
+}
+
 func (db *DB) iterSamplingRate() int {
 	return rand.Intn(2 * db.s.o.GetIteratorSamplingRate())
 }
@@ -117,11 +235,17 @@ type dbIter struct {
 	seq             uint64
 	strict          bool
 	disableSampling bool
+	secondary       bool // iterates the secondary tree
+	rangeDels       *rangeDelIndex
+	se              *snapshotElement // held while blob values may be read
 
 	samplingGap int
 	dir         dir
 	key         []byte
 	value       []byte
+	blob        bool     // value is a blob pointer, see resolveBlob
+	operands    [][]byte // merge operands of key, see resolveMerge
+	hasBase     bool     // value is the base of operands
 	err         error
 	releaser    util.Releaser
 }
@@ -135,7 +259,11 @@ func (i *dbIter) sampleSeek() {
 	i.samplingGap -= len(ikey) + len(i.iter.Value())
 	for i.samplingGap < 0 {
 		i.samplingGap += i.db.iterSamplingRate()
-		i.db.sampleSeek(ikey)
+		if i.secondary {
+			i.db.sampleSeek_s(ikey)
+		} else {
+			i.db.sampleSeek(ikey)
+		}
 	}
 }
 
@@ -211,17 +339,27 @@ func (i *dbIter) next() bool {
 		if ukey, seq, kt, kerr := parseInternalKey(i.iter.Key()); kerr == nil {
 			i.sampleSeek()
 			if seq <= i.seq {
+				if kt != keyTypeDel && i.rangeDels.seq(ukey, i.seq) > seq {
+					kt = keyTypeDel
+				}
 				switch kt {
 				case keyTypeDel:
 					// Skip deleted key.
 					i.key = append(i.key[:0], ukey...)
 					i.dir = dirForward
-				case keyTypeVal:
+				case keyTypeVal, keyTypeBlob:
 					if i.dir == dirSOI || i.icmp.uCompare(ukey, i.key) > 0 {
 						i.key = append(i.key[:0], ukey...)
 						i.value = append(i.value[:0], i.iter.Value()...)
+						i.blob = kt == keyTypeBlob
 						i.dir = dirForward
-						return true
+						return i.resolveBlob()
+					}
+				case keyTypeMerge:
+					if i.dir == dirSOI || i.icmp.uCompare(ukey, i.key) > 0 {
+						i.key = append(i.key[:0], ukey...)
+						i.dir = dirForward
+						return i.mergeNext()
 					}
 				}
 			}
@@ -238,6 +376,39 @@ func (i *dbIter) next() bool {
 	return false
 }
 
+// mergeNext reads the entries of the key older than the merge operand the
+// iterator is at, down to its base value, and merges the operands into it.
+// The iterator is left at the last entry of the key read.
+func (i *dbIter) mergeNext() bool {
+	i.operands = append(i.operands[:0], append([]byte(nil), i.iter.Value()...))
+	i.hasBase, i.blob = false, false
+	for i.iter.Next() {
+		ukey, seq, kt, kerr := parseInternalKey(i.iter.Key())
+		if kerr != nil || i.icmp.uCompare(ukey, i.key) != 0 {
+			i.iter.Prev()
+			break
+		}
+		i.sampleSeek()
+		if kt == keyTypeDel || i.rangeDels.seq(ukey, i.seq) > seq {
+			break
+		}
+		if kt == keyTypeMerge {
+			i.operands = append(i.operands, append([]byte(nil), i.iter.Value()...))
+			continue
+		}
+		i.value = append(i.value[:0], i.iter.Value()...)
+		i.blob = kt == keyTypeBlob
+		i.hasBase = true
+		break
+	}
+	if err := i.iter.Error(); err != nil {
+		i.setErr(err)
+		return false
+	}
+	reverseOperands(i.operands)
+	return i.resolveBlob() && i.resolveMerge()
+}
+
 func (i *dbIter) Next() bool {
 	if i.dir == dirEOI || i.err != nil {
 		return false
@@ -263,12 +434,28 @@ func (i *dbIter) prev() bool {
 				i.sampleSeek()
 				if seq <= i.seq {
 					if !del && i.icmp.uCompare(ukey, i.key) < 0 {
-						return true
+						return i.resolveBlob() && i.resolveMerge()
 					}
-					del = (kt == keyTypeDel)
-					if !del {
+					switch {
+					case kt == keyTypeDel || i.rangeDels.seq(ukey, i.seq) > seq:
+						del = true
+						i.operands = i.operands[:0]
+					case kt == keyTypeMerge:
+						if del {
+							// The operands have no base value so far.
+							del = false
+							i.key = append(i.key[:0], ukey...)
+							i.hasBase, i.blob = false, false
+						}
+						// Entries are read from the oldest on.
+						i.operands = append(i.operands, append([]byte(nil), i.iter.Value()...))
+					default:
+						del = false
 						i.key = append(i.key[:0], ukey...)
 						i.value = append(i.value[:0], i.iter.Value()...)
+						i.blob = kt == keyTypeBlob
+						i.operands = i.operands[:0]
+						i.hasBase = true
 					}
 				}
 			} else if i.strict {
@@ -285,6 +472,42 @@ func (i *dbIter) prev() bool {
 		i.iterErr()
 		return false
 	}
+	return i.resolveBlob() && i.resolveMerge()
+}
+
+// resolveBlob replaces the value by the one it points to if it is a blob
+// pointer.
+func (i *dbIter) resolveBlob() bool {
+	if !i.blob {
+		return true
+	}
+	i.blob = false
+	value, err := i.db.s.tops.readBlob(i.value)
+	if err != nil {
+		i.setErr(err)
+		return false
+	}
+	i.value = value
+	return true
+}
+
+// resolveMerge replaces the value by the result of merging the operands of
+// the key into it, if the key has merge operands.
+func (i *dbIter) resolveMerge() bool {
+	if len(i.operands) == 0 {
+		return true
+	}
+	var base []byte
+	if i.hasBase {
+		base = append([]byte{}, i.value...)
+	}
+	value, err := i.db.fullMerge(i.key, base, i.operands)
+	i.operands = i.operands[:0]
+	if err != nil {
+		i.setErr(err)
+		return false
+	}
+	i.value = value
 	return true
 }
 
@@ -349,6 +572,10 @@ func (i *dbIter) Release() {
 		i.value = nil
 		i.iter.Release()
 		i.iter = nil
+		if i.se != nil {
+			i.db.releaseSnapshot(i.se)
+			i.se = nil
+		}
 		atomic.AddInt32(&i.db.aliveIters, -1)
 		i.db = nil
 	}
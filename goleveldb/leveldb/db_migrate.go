@@ -0,0 +1,201 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"awesomeProject1/goleveldb/leveldb/errors"
+	"awesomeProject1/goleveldb/leveldb/iterator"
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/util"
+)
+
+// ErrMigrateTree is returned by MigrateRange when from and to are not two
+// different trees.
+var ErrMigrateTree = errors.New("leveldb: invalid migration trees")
+
+// Bounds of one atomic step of MigrateRange.
+const (
+	migrateChunkLen  = 1000
+	migrateChunkSize = 1 * opt.MiB
+)
+
+// MigrateRange moves the live entries of the given key range from one tree
+// to the other, see MigrateRangeFunc.
+func (db *DB) MigrateRange(r util.Range, from, to Tree) error {
+	return db.MigrateRangeFunc(r, from, to, nil)
+}
+
+// MigrateRangeFunc moves the live entries of the given key range from one
+// tree to the other: each key is put into the to tree and deleted from the
+// from tree. A nil Range.Start is treated as a key before all keys in the DB,
+// and a nil Range.Limit is treated as a key after all keys in the DB.
+//
+// The range is moved in chunks of bounded size, each one applied atomically
+// with WriteTree. The values are read again under the write lock before a
+// chunk is written, so writes racing with the migration are never lost: a
+// key deleted meanwhile is skipped, and a key already present in the to tree
+// keeps the newer of its two values, by sequence number.
+//
+// If not nil, fn is called after each chunk with the number of keys moved
+// so far and the last key of the chunk. The migration can be resumed from
+// that key after an error.
+func (db *DB) MigrateRangeFunc(r util.Range, from, to Tree, fn func(moved int, last []byte)) error {
+	if from == to || from > SecondaryTree || to > SecondaryTree {
+		return ErrMigrateTree
+	}
+
+	var (
+		start = append([]byte(nil), r.Start...)
+		keys  [][]byte
+		moved int
+	)
+	for {
+		if err := db.ok(); err != nil {
+			return err
+		}
+
+		// Collect the keys of the next chunk.
+		keys = keys[:0]
+		iter := db.treeIterator(from, &util.Range{Start: start, Limit: r.Limit})
+		for size := 0; len(keys) < migrateChunkLen && size < migrateChunkSize && iter.Next(); {
+			keys = append(keys, append([]byte(nil), iter.Key()...))
+			size += len(iter.Key()) + len(iter.Value())
+		}
+		err := iter.Error()
+		iter.Release()
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+
+		n, err := db.migrateKeys(keys, from, to)
+		if err != nil {
+			return err
+		}
+		moved += n
+		last := keys[len(keys)-1]
+		if fn != nil {
+			fn(moved, last)
+		}
+		// The next chunk starts right after the last key.
+		start = append(last, 0)
+	}
+}
+
+func (db *DB) treeIterator(tree Tree, slice *util.Range) iterator.Iterator {
+	se := db.acquireSnapshot()
+	defer db.releaseSnapshot(se)
+	if tree == SecondaryTree {
+		return db.newIterator_s(nil, nil, se.seq, slice, nil)
+	}
+	return db.newIterator(nil, nil, se.seq, slice, nil)
+}
+
+func (db *DB) treeGet(tree Tree, key []byte, seq uint64) ([]byte, error) {
+	if tree == SecondaryTree {
+		return db.get_s(nil, nil, key, seq, nil)
+	}
+	return db.get(nil, nil, key, seq, nil)
+}
+
+func (db *DB) treeHas(tree Tree, key []byte, seq uint64) (bool, error) {
+	if tree == SecondaryTree {
+		return db.has_s(nil, nil, key, seq, nil)
+	}
+	return db.has(nil, nil, key, seq, nil)
+}
+
+// treeSeq returns the sequence number of the newest entry of key in the
+// tree as of seq, zero if there is none.
+func (db *DB) treeSeq(tree Tree, key []byte, seq uint64) (uint64, error) {
+	slice := &util.Range{Start: makeInternalKey(nil, key, seq, keyTypeSeek)}
+	var iter iterator.Iterator
+	if tree == SecondaryTree {
+		iter, _ = db.newRawIterator_s(nil, nil, slice, nil, seq)
+	} else {
+		iter, _ = db.newRawIterator(nil, nil, slice, nil, seq)
+	}
+	defer iter.Release()
+	if !iter.Next() {
+		return 0, iter.Error()
+	}
+	ukey, kseq, _, err := parseInternalKey(iter.Key())
+	if err != nil {
+		return 0, err
+	}
+	if db.s.icmp.uCompare(ukey, key) != 0 {
+		return 0, nil
+	}
+	return kseq, nil
+}
+
+// migrateNewer reports whether the value of key in from is newer than the
+// one in to, both being live.
+func (db *DB) migrateNewer(key []byte, from, to Tree, seq uint64) (bool, error) {
+	fseq, err := db.treeSeq(from, key, seq)
+	if err != nil {
+		return false, err
+	}
+	tseq, err := db.treeSeq(to, key, seq)
+	if err != nil {
+		return false, err
+	}
+	return fseq > tseq, nil
+}
+
+// migrateKeys moves keys under the write lock and returns the number of keys
+// moved.
+func (db *DB) migrateKeys(keys [][]byte, from, to Tree) (n int, err error) {
+	// Acquire write lock.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err := <-db.compPerErrC:
+		return 0, err
+	case <-db.closeC:
+		return 0, ErrClosed
+	}
+	defer func() { <-db.writeLockC }()
+
+	// No write can happen until the lock is released, so the snapshot is
+	// the latest state of both trees.
+	se := db.acquireSnapshot()
+	defer db.releaseSnapshot(se)
+
+	b := new(TreeBatch)
+	for _, key := range keys {
+		value, err := db.treeGet(from, key, se.seq)
+		if err == ErrNotFound {
+			// Deleted meanwhile.
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		exist, err := db.treeHas(to, key, se.seq)
+		if err != nil {
+			return 0, err
+		}
+		put := !exist
+		if exist {
+			// The to tree may hold an older value, e.g. when the routing
+			// of the key was flipped back.
+			if put, err = db.migrateNewer(key, from, to, se.seq); err != nil {
+				return 0, err
+			}
+		}
+		if put {
+			b.Put(to, key, value)
+		}
+		b.Delete(from, key)
+		n++
+	}
+	if b.Len() == 0 {
+		return 0, nil
+	}
+	return n, db.writeTreeLocked(b, false)
+}
@@ -61,6 +61,21 @@ func (db *DB) releaseSnapshot(se *snapshotElement) {
 	}
 }
 
+// Takes one more reference on the snapshot element of seq, which must be
+// held by the caller.
+func (db *DB) refSnapshot(seq uint64) *snapshotElement {
+	db.snapsMu.Lock()
+	defer db.snapsMu.Unlock()
+
+	for e := db.snapsList.Back(); e != nil; e = e.Prev() {
+		if se := e.Value.(*snapshotElement); se.seq == seq {
+			se.ref++
+			return se
+		}
+	}
+	return nil
+}
+
 // Gets minimum sequence that not being snapshotted.
 func (db *DB) minSeq() uint64 {
 	db.snapsMu.Lock()
@@ -73,6 +88,17 @@ func (db *DB) minSeq() uint64 {
 	return db.getSeq()
 }
 
+// Gets the sequence of the newest snapshot, or zero if there is none.
+func (db *DB) maxSnapshotSeq() uint64 {
+	db.snapsMu.Lock()
+	defer db.snapsMu.Unlock()
+
+	if e := db.snapsList.Back(); e != nil {
+		return e.Value.(*snapshotElement).seq
+	}
+	return 0
+}
+
 // Snapshot is a DB snapshot.
 type Snapshot struct {
 	db       *DB
@@ -112,9 +138,32 @@ func (snap *Snapshot) Get(key []byte, ro *opt.ReadOptions) (value []byte, err er
 		err = ErrSnapshotReleased
 		return
 	}
+	if snap.db.routeTree(key) == SecondaryTree {
+		return snap.db.get_s(nil, nil, key, snap.elem.seq, ro)
+	}
 	return snap.db.get(nil, nil, key, snap.elem.seq, ro)
 }
 
+// Get_s gets the value for the given key from the secondary tree, as of
+// the snapshot sequence. It returns ErrNotFound if the secondary tree does
+// not contains the key.
+//
+// The caller should not modify the contents of the returned slice, but
+// it is safe to modify the contents of the argument after Get_s returns.
+func (snap *Snapshot) Get_s(key []byte, ro *opt.ReadOptions) (value []byte, err error) {
+	err = snap.db.ok()
+	if err != nil {
+		return
+	}
+	snap.mu.RLock()
+	defer snap.mu.RUnlock()
+	if snap.released {
+		err = ErrSnapshotReleased
+		return
+	}
+	return snap.db.get_s(nil, nil, key, snap.elem.seq, ro)
+}
+
 // Has returns true if the DB does contains the given key.
 //
 // It is safe to modify the contents of the argument after Get returns.
@@ -129,9 +178,30 @@ func (snap *Snapshot) Has(key []byte, ro *opt.ReadOptions) (ret bool, err error)
 		err = ErrSnapshotReleased
 		return
 	}
+	if snap.db.routeTree(key) == SecondaryTree {
+		return snap.db.has_s(nil, nil, key, snap.elem.seq, ro)
+	}
 	return snap.db.has(nil, nil, key, snap.elem.seq, ro)
 }
 
+// Has_s returns true if the secondary tree does contains the given key, as
+// of the snapshot sequence.
+//
+// It is safe to modify the contents of the argument after Has_s returns.
+func (snap *Snapshot) Has_s(key []byte, ro *opt.ReadOptions) (ret bool, err error) {
+	err = snap.db.ok()
+	if err != nil {
+		return
+	}
+	snap.mu.RLock()
+	defer snap.mu.RUnlock()
+	if snap.released {
+		err = ErrSnapshotReleased
+		return
+	}
+	return snap.db.has_s(nil, nil, key, snap.elem.seq, ro)
+}
+
 // NewIterator returns an iterator for the snapshot of the underlying DB.
 // The returned iterator is not safe for concurrent use, but it is safe to use
 // multiple iterators concurrently, with each in a dedicated goroutine.
@@ -164,9 +234,36 @@ func (snap *Snapshot) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterat
 	}
 	// Since iterator already hold version ref, it doesn't need to
 	// hold snapshot ref.
+	if snap.db.s.o.GetKeyRouter() != nil {
+		// Keys are spread over both trees, merge them by user key.
+		its := []iterator.Iterator{
+			snap.db.newIterator(nil, nil, snap.elem.seq, slice, ro),
+			snap.db.newIterator_s(nil, nil, snap.elem.seq, slice, ro),
+		}
+		return iterator.NewMergedIterator(its, snap.db.s.icmp.ucmp, opt.GetStrict(snap.db.s.o.Options, ro, opt.StrictReader))
+	}
 	return snap.db.newIterator(nil, nil, snap.elem.seq, slice, ro)
 }
 
+// NewIterator_s returns an iterator for the snapshot of the secondary tree.
+// It is pinned to the same sequence as Get_s and NewIterator, and shares the
+// semantics of NewIterator.
+//
+// The iterator must be released after use, by calling Release method.
+func (snap *Snapshot) NewIterator_s(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	if err := snap.db.ok(); err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+	snap.mu.Lock()
+	defer snap.mu.Unlock()
+	if snap.released {
+		return iterator.NewEmptyIterator(ErrSnapshotReleased)
+	}
+	// Since iterator already hold version ref, it doesn't need to
+	// hold snapshot ref.
+	return snap.db.newIterator_s(nil, nil, snap.elem.seq, slice, ro)
+}
+
 // Release releases the snapshot. This will not release any returned
 // iterators, the iterators would still be valid until released or the
 // underlying DB is closed.
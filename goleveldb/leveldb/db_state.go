@@ -58,7 +58,7 @@ func (m *memDB) decref() { //减引用
 func (m *memDB) decref_s() { //减引用
 	if refs := atomic.AddInt32(&m.refs, -1); refs == 0 { //if ref=1
 		// Only put back memdb with std capacity.
-		if m.Capacity_s() == m.db.s.o.GetWriteBuffer2() { //达到阈值4MiB
+		if m.Capacity_s() == m.db.s.o_s.GetWriteBuffer() { //达到阈值4MiB
 			m.Reset_s()            //mems置空
 			m.db.mpoolPut_s(m.DBs) //mems->mpools?
 		}
@@ -141,7 +141,7 @@ func (db *DB) mpoolGet_s(n int) *memDB {
 	default:
 	}
 	if mdb == nil || mdb.Capacity_s() < n {
-		mdb = memdb.New_s(db.s.icmp, maxInt(db.s.o.GetWriteBuffer2(), n))
+		mdb = memdb.New_s(db.s.icmp, maxInt(db.s.o_s.GetWriteBuffer(), n))
 	}
 	return &memDB{
 		db:  db,
@@ -8,6 +8,7 @@ package leveldb
 
 import (
 	"bytes"
+	"compress/flate"
 	"container/list"
 	crand "crypto/rand"
 	"encoding/binary"
@@ -15,7 +16,9 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -26,11 +29,13 @@ import (
 	"github.com/onsi/gomega"
 
 	"awesomeProject1/goleveldb/leveldb/comparer"
+	"awesomeProject1/goleveldb/leveldb/compression"
 	"awesomeProject1/goleveldb/leveldb/errors"
 	"awesomeProject1/goleveldb/leveldb/filter"
 	"awesomeProject1/goleveldb/leveldb/iterator"
 	"awesomeProject1/goleveldb/leveldb/opt"
 	"awesomeProject1/goleveldb/leveldb/storage"
+	"awesomeProject1/goleveldb/leveldb/table"
 	"awesomeProject1/goleveldb/leveldb/testutil"
 	"awesomeProject1/goleveldb/leveldb/util"
 )
@@ -167,6 +172,12 @@ func (h *dbHarness) put(key, value string) {
 	}
 }
 
+func (h *dbHarness) put_s(key, value string) {
+	if err := h.db.Put_s([]byte(key), []byte(value), h.wo); err != nil {
+		h.t.Error("Put_s: got error: ", err)
+	}
+}
+
 func (h *dbHarness) putMulti(n int, low, hi string) {
 	for i := 0; i < n; i++ {
 		h.put(low, "begin")
@@ -217,6 +228,12 @@ func (h *dbHarness) delete(key string) {
 	}
 }
 
+func (h *dbHarness) delete_s(key string) {
+	if err := h.db.Delete_s([]byte(key), h.wo); err != nil {
+		h.t.Error("Delete_s: got error: ", err)
+	}
+}
+
 func (h *dbHarness) assertNumKeys(want int) {
 	iter := h.db.NewIterator(nil, h.ro)
 	defer iter.Release()
@@ -271,13 +288,42 @@ func (h *dbHarness) getVal(key, value string) {
 	h.getValr(h.db, key, value)
 }
 
+func (h *dbHarness) get_s(key string, expectFound bool) (found bool, v []byte) {
+	t := h.t
+	v, err := h.db.Get_s([]byte(key), h.ro)
+	switch err {
+	case ErrNotFound:
+		if expectFound {
+			t.Errorf("Get_s: key '%s' not found, want found", key)
+		}
+	case nil:
+		found = true
+		if !expectFound {
+			t.Errorf("Get_s: key '%s' found, want not found", key)
+		}
+	default:
+		t.Error("Get_s: got error: ", err)
+	}
+	return
+}
+
+func (h *dbHarness) getVal_s(key, value string) {
+	found, r := h.get_s(key, true)
+	if !found {
+		return
+	}
+	if rval := string(r); rval != value {
+		h.t.Errorf("Get_s: invalid value, got '%s', want '%s'", rval, value)
+	}
+}
+
 func (h *dbHarness) allEntriesFor(key, want string) {
 	t := h.t
 	db := h.db
 	s := db.s
 
 	ikey := makeInternalKey(nil, []byte(key), keyMaxSeq, keyTypeVal)
-	iter := db.newRawIterator(nil, nil, nil, nil)
+	iter, _ := db.newRawIterator(nil, nil, nil, nil, keyMaxSeq)
 	if !iter.Seek(ikey) && iter.Error() != nil {
 		t.Error("AllEntries: error during seek, err: ", iter.Error())
 		return
@@ -298,6 +344,8 @@ func (h *dbHarness) allEntriesFor(key, want string) {
 				res += string(iter.Value())
 			case keyTypeDel:
 				res += "DEL"
+			case keyTypeMerge:
+				res += "+" + string(iter.Value())
 			}
 		} else {
 			if !first {
@@ -614,6 +662,261 @@ func TestDB_PutDeleteGet(t *testing.T) {
 	})
 }
 
+func TestDB_PutDeleteGet_s(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		h.put_s("foo", "v1")
+		h.getVal_s("foo", "v1")
+		h.put_s("foo", "v2")
+		h.getVal_s("foo", "v2")
+		h.delete_s("foo")
+		h.get_s("foo", false)
+
+		h.reopenDB()
+		h.get_s("foo", false)
+	})
+}
+
+func TestDB_Has_s(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		h.put("foo", "v1")
+		h.put_s("bar", "v2")
+		if ret, err := h.db.Has_s([]byte("bar"), h.ro); err != nil || !ret {
+			t.Errorf("Has_s: want=true got=%v err=%v", ret, err)
+		}
+		if ret, err := h.db.Has_s([]byte("foo"), h.ro); err != nil || ret {
+			t.Errorf("Has_s: primary key leaked into secondary tree, got=%v err=%v", ret, err)
+		}
+
+		if err := h.db.CompactRange_s(util.Range{}); err != nil {
+			t.Fatal("CompactRange_s: got error: ", err)
+		}
+		if ret, err := h.db.Has_s([]byte("bar"), h.ro); err != nil || !ret {
+			t.Errorf("Has_s: after compaction want=true got=%v err=%v", ret, err)
+		}
+		h.delete_s("bar")
+		if ret, err := h.db.Has_s([]byte("bar"), h.ro); err != nil || ret {
+			t.Errorf("Has_s: after delete want=false got=%v err=%v", ret, err)
+		}
+	})
+}
+
+func TestDB_DeleteSurvivesCompaction_s(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		h.put_s("foo", "v1")
+		h.put_s("bar", "v2")
+		if err := h.db.CompactRange_s(util.Range{}); err != nil {
+			t.Fatal("CompactRange_s: got error: ", err)
+		}
+		h.delete_s("foo")
+		h.get_s("foo", false)
+		if err := h.db.CompactRange_s(util.Range{}); err != nil {
+			t.Fatal("CompactRange_s: got error: ", err)
+		}
+		h.get_s("foo", false)
+		h.getVal_s("bar", "v2")
+
+		h.reopenDB()
+		h.get_s("foo", false)
+		h.getVal_s("bar", "v2")
+		h.get("foo", false)
+	})
+}
+
+func TestDB_IterMulti_s(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		h.put("a", "primary")
+		h.put_s("a", "va")
+		h.put_s("b", "vb")
+		h.put_s("c", "vc")
+		if err := h.db.CompactRange_s(util.Range{}); err != nil {
+			t.Fatal("CompactRange_s: got error: ", err)
+		}
+		h.put_s("b", "vb2")
+		h.put_s("d", "vd")
+		h.delete_s("c")
+
+		iter := h.db.NewIterator_s(nil, h.ro)
+		for _, want := range []string{"a->va", "b->vb2", "d->vd"} {
+			if !iter.Next() {
+				t.Fatalf("Next: want %q, got eoi", want)
+			}
+			testKeyVal(t, iter, want)
+		}
+		if iter.Next() {
+			t.Errorf("Next: want eoi, got %q", iter.Key())
+		}
+		for _, want := range []string{"d->vd", "b->vb2", "a->va"} {
+			if !iter.Prev() {
+				t.Fatalf("Prev: want %q, got soi", want)
+			}
+			testKeyVal(t, iter, want)
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			t.Error("iter: got error: ", err)
+		}
+
+		iter = h.db.NewIterator_s(&util.Range{Start: []byte("b"), Limit: []byte("d")}, h.ro)
+		if !iter.First() {
+			t.Fatal("First: want b, got eoi")
+		}
+		testKeyVal(t, iter, "b->vb2")
+		if iter.Next() {
+			t.Errorf("Next: want eoi, got %q", iter.Key())
+		}
+		iter.Release()
+	})
+}
+
+func TestDB_Snapshot_s(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		h.put("root", "r1")
+		h.put_s("body", "b1")
+		snap := h.getSnapshot()
+		h.put("root", "r2")
+		h.put_s("body", "b2")
+		h.put_s("receipt", "x")
+		h.delete_s("body")
+
+		h.getValr(snap, "root", "r1")
+		if v, err := snap.Get_s([]byte("body"), h.ro); err != nil || string(v) != "b1" {
+			t.Errorf("Snapshot.Get_s: want b1, got %q err=%v", v, err)
+		}
+		if ret, err := snap.Has_s([]byte("receipt"), h.ro); err != nil || ret {
+			t.Errorf("Snapshot.Has_s: want false, got %v err=%v", ret, err)
+		}
+		h.get_s("body", false)
+
+		if err := h.db.CompactRange_s(util.Range{}); err != nil {
+			t.Fatal("CompactRange_s: got error: ", err)
+		}
+		iter := snap.NewIterator_s(nil, h.ro)
+		if !iter.First() {
+			t.Fatal("Snapshot.NewIterator_s: want body, got eoi")
+		}
+		testKeyVal(t, iter, "body->b1")
+		if iter.Next() {
+			t.Errorf("Snapshot.NewIterator_s: want eoi, got %q", iter.Key())
+		}
+		iter.Release()
+
+		snap.Release()
+	})
+}
+
+func TestDB_WriteTree(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		b := new(TreeBatch)
+		b.Put(PrimaryTree, []byte("root"), []byte("r1"))
+		b.Put(SecondaryTree, []byte("body"), []byte("b1"))
+		b.Delete(SecondaryTree, []byte("receipt"))
+		seq := h.db.getSeq()
+		if err := h.db.WriteTree(b, h.wo); err != nil {
+			t.Fatal("WriteTree: got error: ", err)
+		}
+		if got := h.db.getSeq(); got != seq+3 {
+			t.Errorf("WriteTree: want seq %d, got %d", seq+3, got)
+		}
+		h.getVal("root", "r1")
+		h.get("body", false)
+		h.getVal_s("body", "b1")
+		h.get_s("root", false)
+
+		h.reopenDB()
+		h.getVal("root", "r1")
+		h.getVal_s("body", "b1")
+	})
+}
+
+func TestDB_WriteTreeRecoverFromPrimaryJournal(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	b := new(TreeBatch)
+	b.Put(PrimaryTree, []byte("root"), []byte("r1"))
+	b.Put(SecondaryTree, []byte("body"), []byte("b1"))
+	if err := h.db.WriteTree(b, h.wo); err != nil {
+		t.Fatal("WriteTree: got error: ", err)
+	}
+	h.closeDB()
+
+	// Simulate a crash before the secondary journal record hit the disk.
+	fds, err := h.stor.List(storage.TypeJournals)
+	if err != nil || len(fds) == 0 {
+		t.Fatalf("List: want secondary journals, got %d err=%v", len(fds), err)
+	}
+	for _, fd := range fds {
+		if err := h.stor.Remove(fd); err != nil {
+			t.Fatal("Remove: got error: ", err)
+		}
+	}
+
+	h.openDB()
+	h.getVal("root", "r1")
+	h.getVal_s("body", "b1")
+}
+
+func TestDB_KeyRouter(t *testing.T) {
+	router := func(key []byte) Tree {
+		if len(key) > 0 && key[0] == 's' {
+			return SecondaryTree
+		}
+		return PrimaryTree
+	}
+	truno(t, &opt.Options{KeyRouter: router}, func(h *dbHarness) {
+		h.put("p1", "v1")
+		h.put("s1", "v2")
+		h.getVal("p1", "v1")
+		h.getVal("s1", "v2")
+		h.getVal_s("s1", "v2")
+		h.get_s("p1", false)
+
+		b := new(Batch)
+		b.Put([]byte("p2"), []byte("v3"))
+		b.Put([]byte("s2"), []byte("v4"))
+		b.Delete([]byte("s1"))
+		h.write(b)
+		h.get("s1", false)
+		h.getVal_s("s2", "v4")
+		h.getVal("p2", "v3")
+		if ret, err := h.db.Has([]byte("s2"), h.ro); err != nil || !ret {
+			t.Errorf("Has: want true, got %v err=%v", ret, err)
+		}
+
+		snap := h.getSnapshot()
+		h.put("s2", "v5")
+		if v, err := snap.Get([]byte("s2"), h.ro); err != nil || string(v) != "v4" {
+			t.Errorf("Snapshot.Get: want v4, got %q err=%v", v, err)
+		}
+		if ret, err := snap.Has([]byte("s2"), h.ro); err != nil || !ret {
+			t.Errorf("Snapshot.Has: want true, got %v err=%v", ret, err)
+		}
+		iter := snap.NewIterator(nil, h.ro)
+		for _, want := range []string{"p1->v1", "p2->v3", "s2->v4"} {
+			if !iter.Next() {
+				t.Fatalf("Next: want %q, got eoi", want)
+			}
+			testKeyVal(t, iter, want)
+		}
+		iter.Release()
+		snap.Release()
+		h.put("s2", "v4")
+
+		h.reopenDB()
+		iter = h.db.NewIterator(nil, h.ro)
+		for _, want := range []string{"p1->v1", "p2->v3", "s2->v4"} {
+			if !iter.Next() {
+				t.Fatalf("Next: want %q, got eoi", want)
+			}
+			testKeyVal(t, iter, want)
+		}
+		if iter.Next() {
+			t.Errorf("Next: want eoi, got %q", iter.Key())
+		}
+		iter.Release()
+	})
+}
+
 func TestDB_EmptyBatch(t *testing.T) {
 	h := newDbHarness(t)
 	defer h.close()
@@ -2540,7 +2843,7 @@ func TestDB_TableCompactionBuilder(t *testing.T) {
 		value      = bytes.Repeat([]byte{'0'}, 100)
 	)
 	for i := 0; i < 2; i++ {
-		tw, err := s.tops.create()
+		tw, err := s.tops.create(0)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -2925,3 +3228,1285 @@ func TestDB_GracefulClose(t *testing.T) {
 	iter.Release()
 	closeWait.Wait()
 }
+
+func TestDB_SecondaryOptions(t *testing.T) {
+	o := &opt.Options{
+		WriteBuffer: 1 * opt.MiB,
+		Secondary: &opt.Options{
+			BlockSize:   256,
+			Compression: opt.NoCompression,
+			WriteBuffer: 10000,
+		},
+	}
+	h := newDbHarnessWopt(t, o)
+	defer h.close()
+
+	if got := h.db.s.o_s.GetWriteBuffer(); got != 10000 {
+		t.Errorf("secondary WriteBuffer: want 10000, got %d", got)
+	}
+	if got := h.db.s.o_s.GetCompression(); got != opt.NoCompression {
+		t.Errorf("secondary Compression: want %v, got %v", opt.NoCompression, got)
+	}
+	if got := h.db.s.o.GetWriteBuffer(); got != 1*opt.MiB {
+		t.Errorf("primary WriteBuffer: want %d, got %d", 1*opt.MiB, got)
+	}
+	if got := h.db.s.o.GetCompression(); got != opt.SnappyCompression {
+		t.Errorf("primary Compression: want snappy, got %v", got)
+	}
+
+	// Unset fields fall back to the primary values.
+	if got, want := h.db.s.o_s.GetCompactionL0Trigger(), h.db.s.o.GetCompactionL0Trigger(); got != want {
+		t.Errorf("secondary CompactionL0Trigger: want %d, got %d", want, got)
+	}
+
+	value := strings.Repeat("v", 1000)
+	for i := 0; i < 50; i++ {
+		h.put_s(fmt.Sprintf("key%03d", i), value)
+	}
+	v := h.db.s.version()
+	n := 0
+	for level := range v.level_s {
+		n += v.tLen_s(level)
+	}
+	v.release()
+	if n == 0 {
+		t.Error("secondary memdb was not flushed with the small WriteBuffer")
+	}
+
+	h.reopenDB()
+	for i := 0; i < 50; i++ {
+		h.getVal_s(fmt.Sprintf("key%03d", i), value)
+	}
+}
+
+func TestDB_RecoverInterleavedJournals(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		h.put_s("a", "v1")
+		h.put("b", "v2")
+		// Rotate the primary journal past the secondary one.
+		h.compactMem()
+		h.put("b", "v3")
+		h.put_s("a", "v4")
+		h.put_s("c", "v5")
+
+		last := h.db.seq
+		h.reopenDB()
+		if seq := h.db.seq; seq < last {
+			t.Errorf("seq: want at least %d, got %d", last, seq)
+		}
+		h.getVal_s("a", "v4")
+		h.getVal_s("c", "v5")
+		h.getVal("b", "v3")
+
+		// Writes after the recovery must not reuse sequence numbers.
+		h.put_s("a", "v6")
+		h.put("b", "v7")
+		h.put_s("c", "v8")
+		last = h.db.seq
+		h.reopenDB()
+		if seq := h.db.seq; seq < last {
+			t.Errorf("seq: want at least %d, got %d", last, seq)
+		}
+		h.getVal_s("a", "v6")
+		h.getVal("b", "v7")
+		h.getVal_s("c", "v8")
+	})
+}
+
+func TestDB_MigrateRange(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		// The secondary tree has an older c.
+		h.put_s("c", "sc")
+		for _, k := range []string{"a", "c", "d", "e", "x"} {
+			h.put(k, "p"+k)
+		}
+		h.put_s("d", "sd")
+		h.delete("e")
+
+		if err := h.db.MigrateRange(util.Range{Start: []byte("b"), Limit: []byte("x")}, PrimaryTree, SecondaryTree); err != nil {
+			t.Fatal("MigrateRange: got error: ", err)
+		}
+		h.getVal("a", "pa")
+		h.getVal("x", "px")
+		h.get_s("a", false)
+		h.get_s("x", false)
+		h.get("c", false)
+		h.get("d", false)
+		h.getVal_s("c", "pc")
+		// The secondary tree already had d.
+		h.getVal_s("d", "sd")
+		h.get_s("e", false)
+
+		h.reopenDB()
+		h.get("c", false)
+		h.getVal_s("c", "pc")
+
+		if err := h.db.MigrateRange(util.Range{}, SecondaryTree, PrimaryTree); err != nil {
+			t.Fatal("MigrateRange: got error: ", err)
+		}
+		h.getVal("c", "pc")
+		h.getVal("d", "sd")
+		h.get_s("c", false)
+		h.get_s("d", false)
+
+		if err := h.db.MigrateRange(util.Range{}, PrimaryTree, PrimaryTree); err != ErrMigrateTree {
+			t.Errorf("MigrateRange: want ErrMigrateTree, got %v", err)
+		}
+	})
+}
+
+func TestDB_MigrateRangeChunks(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	const n = migrateChunkLen*2 + 500
+	for i := 0; i < n; i++ {
+		h.put(fmt.Sprintf("key%05d", i), fmt.Sprintf("v%d", i))
+	}
+	var calls, moved int
+	err := h.db.MigrateRangeFunc(util.Range{}, PrimaryTree, SecondaryTree, func(m int, last []byte) {
+		calls++
+		moved = m
+	})
+	if err != nil {
+		t.Fatal("MigrateRangeFunc: got error: ", err)
+	}
+	if calls != 3 || moved != n {
+		t.Errorf("progress: want 3 calls and %d keys, got %d calls and %d keys", n, calls, moved)
+	}
+	for _, i := range []int{0, migrateChunkLen, n - 1} {
+		k := fmt.Sprintf("key%05d", i)
+		h.get(k, false)
+		h.getVal_s(k, fmt.Sprintf("v%d", i))
+	}
+}
+
+func TestDB_DeleteRange(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		for _, k := range []string{"a", "b", "c", "d"} {
+			h.put(k, "v"+k)
+		}
+		if err := h.db.DeleteRange([]byte("b"), []byte("d"), h.wo); err != nil {
+			t.Fatal("DeleteRange: got error: ", err)
+		}
+		h.getVal("a", "va")
+		h.get("b", false)
+		h.get("c", false)
+		h.getVal("d", "vd")
+		h.getKeyVal("(a->va)(d->vd)")
+
+		// Newer writes aren't covered.
+		h.put("c", "vc2")
+		h.getKeyVal("(a->va)(c->vc2)(d->vd)")
+
+		h.reopenDB()
+		h.getKeyVal("(a->va)(c->vc2)(d->vd)")
+
+		h.compactRange("", "")
+		h.get("b", false)
+		h.getVal("c", "vc2")
+		h.getKeyVal("(a->va)(c->vc2)(d->vd)")
+
+		h.reopenDB()
+		h.get("b", false)
+		h.getKeyVal("(a->va)(c->vc2)(d->vd)")
+	})
+}
+
+func TestDB_DeleteRangeSnapshot(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		h.put("k1", "v1")
+		h.put("k2", "v2")
+		h.compactMem()
+		snap := h.getSnapshot()
+		defer snap.Release()
+
+		b := new(Batch)
+		b.DeleteRange([]byte("k"), []byte("l"))
+		h.write(b)
+		h.get("k1", false)
+		h.getValr(snap, "k1", "v1")
+
+		h.compactMem()
+		h.compactRange("", "")
+		h.get("k2", false)
+		h.getValr(snap, "k2", "v2")
+		iter := snap.NewIterator(nil, nil)
+		n := 0
+		for iter.Next() {
+			n++
+		}
+		iter.Release()
+		if n != 2 {
+			t.Errorf("snapshot iterator: want 2 keys, got %d", n)
+		}
+	})
+}
+
+func TestDB_DeleteRangeDropsTables(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	for i := 0; i < 100; i++ {
+		h.put(fmt.Sprintf("key%03d", i), fmt.Sprintf("v%d", i))
+	}
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.put("other", "v")
+	if err := h.db.DeleteRange([]byte("key"), []byte("key999"), h.wo); err != nil {
+		t.Fatal("DeleteRange: got error: ", err)
+	}
+	h.compactMem()
+	h.compactRange("", "")
+	h.get("key000", false)
+	h.get("key099", false)
+	h.getVal("other", "v")
+	if n := h.totalTables(); n != 1 {
+		t.Errorf("want the deleted keys and tombstone gone leaving 1 table, got %d tables (%s)", n, h.getTablesPerLevel())
+	}
+
+	h.reopenDB()
+	h.get("key050", false)
+	h.getKeyVal("(other->v)")
+}
+
+func TestDB_DeleteRange_s(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		h.put("a", "pa")
+		h.put_s("a", "sa")
+		h.put_s("b", "sb")
+		if err := h.db.DeleteRange_s([]byte("a"), []byte("c"), h.wo); err != nil {
+			t.Fatal("DeleteRange_s: got error: ", err)
+		}
+		h.get_s("a", false)
+		h.get_s("b", false)
+		h.getVal("a", "pa")
+
+		if err := h.db.CompactRange_s(util.Range{}); err != nil {
+			t.Fatal("CompactRange_s: got error: ", err)
+		}
+		h.get_s("a", false)
+		h.get_s("b", false)
+		h.getVal("a", "pa")
+
+		h.reopenDB()
+		h.get_s("b", false)
+		h.getVal("a", "pa")
+	})
+}
+
+func TestDB_DeleteRangeRotateMem(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		WriteBuffer:                  10000,
+		CompactionL0Trigger:          100,
+	})
+	defer h.close()
+
+	// Memdbs only taking range tombstones are rotated once they fill the
+	// write buffer.
+	prefix := strings.Repeat("k", 100)
+	for i := 0; i < 200; i++ {
+		start := []byte(fmt.Sprintf("%s%04d", prefix, i))
+		limit := append(start, 'z')
+		if err := h.db.DeleteRange(start, limit, h.wo); err != nil {
+			t.Fatal("DeleteRange: got error: ", err)
+		}
+		if err := h.db.DeleteRange_s(start, limit, h.wo); err != nil {
+			t.Fatal("DeleteRange_s: got error: ", err)
+		}
+	}
+	if err := h.db.compTriggerWait(h.db.mcompCmdC); err != nil {
+		t.Fatal("compaction error: ", err)
+	}
+	if err := h.db.compTriggerWait(h.db.mcompCmdCs); err != nil {
+		t.Fatal("compaction error: ", err)
+	}
+	if n, n_s := h.db.s.tLen(0), h.db.s.tLen_s(0); n == 0 || n_s == 0 {
+		t.Errorf("level-0 tables: want some in both trees, got %d and %d", n, n_s)
+	}
+}
+
+func writeExternalTable(t *testing.T, path string, kvs ...string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal("Create: got error: ", err)
+	}
+	defer f.Close()
+	tw := table.NewWriter(f, &opt.Options{})
+	for i := 0; i < len(kvs); i += 2 {
+		if err := tw.Append([]byte(kvs[i]), []byte(kvs[i+1])); err != nil {
+			t.Fatal("Append: got error: ", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal("Close: got error: ", err)
+	}
+}
+
+func TestDB_IngestExternalFiles(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	dir := t.TempDir()
+	f1, f2 := filepath.Join(dir, "1.ldb"), filepath.Join(dir, "2.ldb")
+	writeExternalTable(t, f1, "a", "a1", "b", "b1")
+	writeExternalTable(t, f2, "x", "x1", "y", "y1")
+
+	h.put("b", "old")
+	h.put("m", "m0")
+	h.compactMem()
+	h.put("y", "old")
+	if err := h.db.IngestExternalFiles([]string{f2, f1}, PrimaryTree); err != nil {
+		t.Fatal("IngestExternalFiles: got error: ", err)
+	}
+	h.getKeyVal("(a->a1)(b->b1)(m->m0)(x->x1)(y->y1)")
+	h.get_s("a", false)
+
+	// Later writes are newer.
+	h.put("a", "a2")
+	h.getVal("a", "a2")
+
+	h.reopenDB()
+	h.getKeyVal("(a->a2)(b->b1)(m->m0)(x->x1)(y->y1)")
+	h.compactRange("", "")
+	h.getKeyVal("(a->a2)(b->b1)(m->m0)(x->x1)(y->y1)")
+
+	if err := h.db.IngestExternalFiles([]string{f1, f1}, PrimaryTree); err != ErrIngestOverlap {
+		t.Errorf("IngestExternalFiles: want ErrIngestOverlap, got %v", err)
+	}
+	if err := h.db.IngestExternalFiles([]string{f1}, SecondaryTree); err != nil {
+		t.Fatal("IngestExternalFiles: got error: ", err)
+	}
+	h.getVal_s("a", "a1")
+	h.getVal("a", "a2")
+}
+
+func TestDB_SSTWriter(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "a.sst"), filepath.Join(dir, "b.sst")}
+	var wg sync.WaitGroup
+	errs := make([]error, len(paths))
+	for i, path := range paths {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			w, err := NewSSTWriter(path, &opt.Options{Filter: filter.NewBloomFilter(10)})
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			for j := 0; j < 100; j++ {
+				if err := w.Put([]byte(fmt.Sprintf("%c%03d", 'a'+i, j)), []byte(fmt.Sprintf("v%d", j))); err != nil {
+					w.Abort()
+					errs[i] = err
+					return
+				}
+			}
+			props, err := w.Finish()
+			if err == nil && (props.NumEntries != 100 || string(props.SmallestKey) != fmt.Sprintf("%c000", 'a'+i)) {
+				err = fmt.Errorf("invalid properties %+v", props)
+			}
+			errs[i] = err
+		}(i, path)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal("SSTWriter: got error: ", err)
+		}
+	}
+
+	h.put("a050", "old")
+	if err := h.db.IngestExternalFiles(paths, PrimaryTree); err != nil {
+		t.Fatal("IngestExternalFiles: got error: ", err)
+	}
+	h.getVal("a000", "v0")
+	h.getVal("a050", "v50")
+	h.getVal("b099", "v99")
+	h.assertNumKeys(200)
+
+	w, err := NewSSTWriter(filepath.Join(dir, "c.sst"), nil)
+	if err != nil {
+		t.Fatal("NewSSTWriter: got error: ", err)
+	}
+	defer w.Abort()
+	w.Put([]byte("k2"), nil)
+	if err := w.Put([]byte("k1"), nil); err != ErrSSTKeyOrder {
+		t.Errorf("Put: want ErrSSTKeyOrder, got %v", err)
+	}
+}
+
+// renamedComparer orders keys like the bytewise comparer under another name.
+type renamedComparer struct {
+	comparer.Comparer
+}
+
+func (renamedComparer) Name() string {
+	return "test.RenamedComparer"
+}
+
+func writeSST(t *testing.T, path string, o *opt.Options, n int, value string) {
+	w, err := NewSSTWriter(path, o)
+	if err != nil {
+		t.Fatal("NewSSTWriter: got error: ", err)
+	}
+	for i := 0; i < n; i++ {
+		if err := w.Put([]byte(fmt.Sprintf("k%03d", i)), []byte(value)); err != nil {
+			t.Fatal("Put: got error: ", err)
+		}
+	}
+	if _, err := w.Finish(); err != nil {
+		t.Fatal("Finish: got error: ", err)
+	}
+}
+
+// ingestedSeqs returns the global sequence numbers of the tables of the
+// DB read with one.
+func ingestedSeqs(db *DB) (seqs []uint64) {
+	v := db.s.version()
+	defer v.release()
+	for _, tables := range v.levels {
+		for _, t := range tables {
+			if t.seq != 0 {
+				seqs = append(seqs, t.seq)
+			}
+		}
+	}
+	for _, tables := range v.level_s {
+		for _, t := range tables {
+			if t.seq != 0 {
+				seqs = append(seqs, t.seq)
+			}
+		}
+	}
+	return
+}
+
+func TestDB_IngestAdopt(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		Filter:                       filter.NewBloomFilter(10),
+	})
+	defer h.close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.sst")
+	writeSST(t, path, &opt.Options{Filter: filter.NewBloomFilter(10)}, 100, "new")
+
+	h.put("k050", "old")
+	h.put("z", "z0")
+	h.compactMem()
+	snap := h.getSnapshot()
+	if err := h.db.IngestExternalFiles([]string{path}, PrimaryTree); err != nil {
+		t.Fatal("IngestExternalFiles: got error: ", err)
+	}
+	seqs := ingestedSeqs(h.db)
+	if len(seqs) != 1 {
+		t.Fatalf("want one adopted table, got %d", len(seqs))
+	}
+	h.getVal("k000", "new")
+	h.getVal("k050", "new")
+	h.getVal("k099", "new")
+	h.assertNumKeys(101)
+
+	// The entries are newer than the snapshot.
+	h.getr(snap, "k000", false)
+	h.getValr(snap, "k050", "old")
+	iter := snap.NewIterator(nil, nil)
+	var keys []string
+	for iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	iter.Release()
+	if want := []string{"k050", "z"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("snapshot keys: want %v, got %v", want, keys)
+	}
+	iter = h.db.NewIterator(nil, nil)
+	if !iter.Seek([]byte("k050")) || string(iter.Key()) != "k050" || string(iter.Value()) != "new" {
+		t.Errorf("Seek: want k050->new, got %q->%q", iter.Key(), iter.Value())
+	}
+	iter.Release()
+	snap.Release()
+
+	// Later writes are newer, the global sequence number outlives the
+	// manifest and the ingested file.
+	h.put("k001", "later")
+	os.Remove(path)
+	h.reopenDB()
+	if got := ingestedSeqs(h.db); !reflect.DeepEqual(got, seqs) {
+		t.Fatalf("global sequence numbers: want %v, got %v", seqs, got)
+	}
+	h.getVal("k000", "new")
+	h.getVal("k001", "later")
+	h.getVal("k050", "new")
+	h.compactRange("", "")
+	if got := ingestedSeqs(h.db); len(got) != 0 {
+		t.Fatalf("want no adopted table after compaction, got %d", len(got))
+	}
+	h.getVal("k001", "later")
+	h.getVal("k050", "new")
+	h.assertNumKeys(101)
+
+	// The secondary tree adopts tables too.
+	writeSST(t, path, nil, 10, "new_s")
+	if err := h.db.IngestExternalFiles([]string{path}, SecondaryTree); err != nil {
+		t.Fatal("IngestExternalFiles: got error: ", err)
+	}
+	if got := ingestedSeqs(h.db); len(got) != 1 {
+		t.Fatalf("want one adopted table, got %d", len(got))
+	}
+	h.getVal_s("k005", "new_s")
+	h.getVal("k005", "new")
+}
+
+func TestDB_IngestAdoptFallback(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	// Tables of another comparer are copied.
+	path := filepath.Join(t.TempDir(), "a.sst")
+	writeSST(t, path, &opt.Options{Comparer: renamedComparer{comparer.DefaultComparer}}, 10, "v")
+	h.put("k005", "old")
+	if err := h.db.IngestExternalFiles([]string{path}, PrimaryTree); err != nil {
+		t.Fatal("IngestExternalFiles: got error: ", err)
+	}
+	if got := ingestedSeqs(h.db); len(got) != 0 {
+		t.Fatalf("want no adopted table, got %d", len(got))
+	}
+	h.getVal("k005", "v")
+	h.assertNumKeys(10)
+}
+
+// countingCompressor counts the blocks compressed by a compressor.
+type countingCompressor struct {
+	compression.Compressor
+	n int32
+}
+
+func (c *countingCompressor) Compress(dst, src []byte) ([]byte, error) {
+	atomic.AddInt32(&c.n, 1)
+	return c.Compressor.Compress(dst, src)
+}
+
+func TestDB_CompressorPerLevel(t *testing.T) {
+	snappyC := &countingCompressor{Compressor: compression.Snappy}
+	flateC := &countingCompressor{Compressor: compression.NewFlate(flate.BestCompression)}
+	flateCs := &countingCompressor{Compressor: compression.NewFlate(flate.BestSpeed)}
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		Compressor:                   snappyC,
+		CompressorPerLevel:           []compression.Compressor{nil, flateC},
+		Secondary:                    &opt.Options{Compressor: flateCs},
+	})
+	defer h.close()
+
+	value := strings.Repeat("v", 1000)
+	for i := 0; i < 100; i++ {
+		h.put(fmt.Sprintf("k%03d", i), value)
+		h.put_s(fmt.Sprintf("s%03d", i), value)
+	}
+	h.compactMem()
+	if atomic.LoadInt32(&snappyC.n) == 0 {
+		t.Error("memdb flush did not use the compressor of level 0")
+	}
+
+	for i := 0; i < 100; i += 2 {
+		h.put(fmt.Sprintf("k%03d", i), "new")
+	}
+	h.compactMem()
+	h.compactRange("", "")
+	if atomic.LoadInt32(&flateC.n) == 0 {
+		t.Error("table compaction did not use the compressor of level 1")
+	}
+	if err := h.db.CompactRange_s(util.Range{}); err != nil {
+		t.Fatal("CompactRange_s: got error: ", err)
+	}
+	if atomic.LoadInt32(&flateCs.n) == 0 {
+		t.Error("secondary tree did not use the secondary compressor")
+	}
+
+	// Tables mixing both compressors are read back whatever the options.
+	h.o = &opt.Options{DisableLargeBatchTransaction: true}
+	h.reopenDB()
+	for i := 0; i < 100; i++ {
+		want := value
+		if i%2 == 0 {
+			want = "new"
+		}
+		h.getVal(fmt.Sprintf("k%03d", i), want)
+		h.getVal_s(fmt.Sprintf("s%03d", i), value)
+	}
+}
+
+func TestDB_PrefixBloomFilter(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		DisableBlockCache:            true,
+		Filter:                       filter.NewBloomFilter(10),
+		PrefixExtractor:              filter.NewFixedPrefix(4),
+	})
+	defer h.close()
+
+	for i := 0; i < 100; i += 2 {
+		for j := 0; j < 100; j++ {
+			h.put(fmt.Sprintf("p%03d-%06d", i, j), strings.Repeat("v", 50))
+		}
+	}
+	h.compactMem()
+	h.compactRange("", "")
+
+	countPrefix := func(prefix string) int {
+		iter := h.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+		defer iter.Release()
+		n := 0
+		for iter.Next() {
+			n++
+		}
+		if err := iter.Error(); err != nil {
+			t.Fatal("Iterator: got error: ", err)
+		}
+		return n
+	}
+	// Open all the tables.
+	if n := countPrefix("p0"); n != 50*100 {
+		t.Fatalf("iterating over p0: want %d keys, got %d", 50*100, n)
+	}
+
+	// Prevent auto compactions triggered by seeks
+	h.stor.Stall(testutil.ModeSync, storage.TypeTable)
+	defer h.stor.Release(testutil.ModeSync, storage.TypeTable)
+
+	h.stor.ResetCounter(testutil.ModeRead, storage.TypeTable)
+	for i := 0; i < 100; i++ {
+		want := 0
+		if i%2 == 0 {
+			want = 100
+		}
+		if n := countPrefix(fmt.Sprintf("p%03d", i)); n != want {
+			t.Errorf("iterating over p%03d: want %d keys, got %d", i, want, n)
+		}
+	}
+	cnt, _ := h.stor.Counter(testutil.ModeRead, storage.TypeTable)
+	h.stor.ResetCounter(testutil.ModeRead, storage.TypeTable)
+	for i := 1; i < 100; i += 2 {
+		countPrefix(fmt.Sprintf("p%03d", i))
+	}
+	missing, _ := h.stor.Counter(testutil.ModeRead, storage.TypeTable)
+	t.Logf("iterating over 100 prefixes yield %d sstable I/O reads, %d for the 50 missing ones", cnt, missing)
+	if max := 5; missing > max {
+		t.Errorf("num of sstable I/O reads of missing prefixes was more than %d, got %d", max, missing)
+	}
+}
+
+func TestDB_BlobValues(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		BlobThreshold:                100,
+	})
+	defer h.close()
+
+	key := func(i int) string { return fmt.Sprintf("k%03d", i) }
+	value := func(i, gen int) string {
+		if i%10 == 9 {
+			return fmt.Sprintf("small-%d-%d", i, gen)
+		}
+		return fmt.Sprintf("%d-%d-", i, gen) + strings.Repeat("v", 200)
+	}
+	compact := func() {
+		if err := h.db.CompactRange_s(util.Range{}); err != nil {
+			t.Fatal("CompactRange_s: got error: ", err)
+		}
+	}
+	blobs := func() (nums []int64) {
+		fds, err := h.stor.List(storage.TypeBlob)
+		if err != nil {
+			t.Fatal("List: got error: ", err)
+		}
+		for _, fd := range fds {
+			nums = append(nums, fd.Num)
+		}
+		sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+		return
+	}
+	check := func(gen func(i int) int) {
+		for i := 0; i < 100; i++ {
+			h.getVal_s(key(i), value(i, gen(i)))
+		}
+		iter := h.db.NewIterator_s(nil, nil)
+		defer iter.Release()
+		i := 0
+		for ; iter.Next(); i++ {
+			if k, v := string(iter.Key()), string(iter.Value()); k != key(i) || v != value(i, gen(i)) {
+				t.Fatalf("Next: got %q=%q, want %q=%q", k, v, key(i), value(i, gen(i)))
+			}
+		}
+		for iter.Prev() {
+			i--
+			if k, v := string(iter.Key()), string(iter.Value()); k != key(i) || v != value(i, gen(i)) {
+				t.Fatalf("Prev: got %q=%q, want %q=%q", k, v, key(i), value(i, gen(i)))
+			}
+		}
+		if err := iter.Error(); err != nil {
+			t.Fatal("Iterator: got error: ", err)
+		}
+		if i != 0 {
+			t.Fatalf("iterating backward: stopped at key %d", i)
+		}
+	}
+	gen0 := func(int) int { return 0 }
+
+	for i := 0; i < 100; i++ {
+		h.put_s(key(i), value(i, 0))
+	}
+	compact()
+	first := blobs()
+	if len(first) != 1 {
+		t.Fatalf("want 1 blob file, got %v", first)
+	}
+	check(gen0)
+
+	// Compactions only move the pointers.
+	h.put_s(key(49), value(49, 0))
+	compact()
+	if got := blobs(); len(got) != 1 || got[0] != first[0] {
+		t.Fatalf("blob files after compaction: want %v, got %v", first, got)
+	}
+	check(gen0)
+
+	h.reopenDB()
+	check(gen0)
+
+	// Overwrite most of the values, the first blob file is then mostly dead.
+	snap, err := h.db.GetSnapshot()
+	if err != nil {
+		t.Fatal("GetSnapshot: got error: ", err)
+	}
+	for i := 0; i < 80; i++ {
+		h.put_s(key(i), value(i, 1))
+	}
+	compact()
+	gen1 := func(i int) int {
+		if i < 80 {
+			return 1
+		}
+		return 0
+	}
+	check(gen1)
+	if n, err := h.db.blobGC(); err != nil {
+		t.Fatal("blobGC: got error: ", err)
+	} else if n != 1 {
+		t.Fatalf("blobGC: want 1 file rewritten, got %d", n)
+	}
+	check(gen1)
+
+	// The snapshot still reads the first file.
+	if got := blobs(); got[0] != first[0] {
+		t.Fatalf("blob file @%d removed while a snapshot may read it", first[0])
+	}
+	for i := 0; i < 100; i++ {
+		if v, err := snap.Get_s([]byte(key(i)), nil); err != nil || string(v) != value(i, 0) {
+			t.Fatalf("Snapshot.Get_s %q: got %q, %v", key(i), v, err)
+		}
+	}
+	snap.Release()
+	if n, err := h.db.blobGC(); err != nil {
+		t.Fatal("blobGC: got error: ", err)
+	} else if n != 0 {
+		t.Fatalf("blobGC: want no file rewritten, got %d", n)
+	}
+	for _, num := range blobs() {
+		if num == first[0] {
+			t.Fatalf("blob file @%d not removed", num)
+		}
+	}
+	check(gen1)
+
+	h.reopenDB()
+	check(gen1)
+}
+
+func TestDB_Checkpoint(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		BlobThreshold:                100,
+	})
+	defer h.close()
+
+	key := func(i int) string { return fmt.Sprintf("k%03d", i) }
+	value := func(i, gen int) string {
+		if i%2 == 0 {
+			return fmt.Sprintf("%d-%d-", i, gen) + strings.Repeat("v", 200)
+		}
+		return fmt.Sprintf("%d-%d", i, gen)
+	}
+
+	// Part of the entries are in tables, the rest only in the memdbs and
+	// journals.
+	for i := 0; i < 100; i++ {
+		h.put(key(i), value(i, 0))
+		h.put_s(key(i), value(i, 0))
+	}
+	if err := h.db.CompactRange(util.Range{}); err != nil {
+		t.Fatal("CompactRange: got error: ", err)
+	}
+	if err := h.db.CompactRange_s(util.Range{}); err != nil {
+		t.Fatal("CompactRange_s: got error: ", err)
+	}
+	for i := 50; i < 150; i++ {
+		h.put(key(i), value(i, 1))
+		h.put_s(key(i), value(i, 1))
+	}
+	if _, err := appendAncients(h.db, 0, 10); err != nil {
+		t.Fatal("ModifyAncients: got error: ", err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "checkpoint")
+	if err := h.db.Checkpoint(dir); err != nil {
+		t.Fatal("Checkpoint: got error: ", err)
+	}
+	if err := h.db.Checkpoint(dir); err != ErrCheckpointExists {
+		t.Fatalf("Checkpoint: want ErrCheckpointExists, got %v", err)
+	}
+
+	// Later writes don't reach the checkpoint.
+	for i := 0; i < 150; i++ {
+		h.put(key(i), value(i, 2))
+		h.put_s(key(i), value(i, 2))
+	}
+	h.delete(key(0))
+	if err := h.db.CompactRange(util.Range{}); err != nil {
+		t.Fatal("CompactRange: got error: ", err)
+	}
+	if _, err := appendAncients(h.db, 10, 20); err != nil {
+		t.Fatal("ModifyAncients: got error: ", err)
+	}
+
+	db, err := OpenFile(dir, nil)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer db.Close()
+	for i := 0; i < 150; i++ {
+		want := value(i, 0)
+		if i >= 50 {
+			want = value(i, 1)
+		}
+		if v, err := db.Get([]byte(key(i)), nil); err != nil || string(v) != want {
+			t.Fatalf("Get %s: got %q, %v, want %q", key(i), v, err, want)
+		}
+		if v, err := db.Get_s([]byte(key(i)), nil); err != nil || string(v) != want {
+			t.Fatalf("Get_s %s: got %q, %v, want %q", key(i), v, err, want)
+		}
+	}
+	checkAncients(t, db, 0, 10)
+
+	// The checkpoint is writable and independent of the DB.
+	if err := db.Put([]byte(key(0)), []byte("checkpoint"), nil); err != nil {
+		t.Fatal("Put: got error: ", err)
+	}
+	h.getVal(key(1), value(1, 2))
+	h.get(key(0), false)
+}
+
+// appendMerger joins the existing value and the operands with commas.
+type appendMerger struct {
+	noPartial bool
+}
+
+func (m appendMerger) FullMerge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	if existing != nil {
+		operands = append([][]byte{existing}, operands...)
+	}
+	return bytes.Join(operands, []byte(",")), nil
+}
+
+func (m appendMerger) PartialMerge(key, left, right []byte) ([]byte, bool) {
+	if m.noPartial {
+		return nil, false
+	}
+	return bytes.Join([][]byte{left, right}, []byte(",")), true
+}
+
+func TestDB_Merge(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		MergeOperator:                appendMerger{},
+	})
+	defer h.close()
+
+	merge := func(key, operand string) {
+		t.Helper()
+		if err := h.db.Merge([]byte(key), []byte(operand), h.wo); err != nil {
+			t.Fatal("Merge: got error: ", err)
+		}
+	}
+	reverse := func(r Reader) string {
+		t.Helper()
+		res := ""
+		iter := r.NewIterator(nil, nil)
+		defer iter.Release()
+		for ok := iter.Last(); ok; ok = iter.Prev() {
+			res += fmt.Sprintf("(%s->%s)", iter.Key(), iter.Value())
+		}
+		if err := iter.Error(); err != nil {
+			t.Fatal("Iterator: got error: ", err)
+		}
+		return res
+	}
+
+	h.put("a", "1")
+	merge("a", "2")
+	merge("a", "3")
+	merge("b", "x")
+	h.put("c", "old")
+	h.delete("c")
+	merge("c", "new")
+	h.getVal("a", "1,2,3")
+	h.getVal("b", "x")
+	h.getVal("c", "new")
+	if ok, err := h.db.Has([]byte("b"), h.ro); !ok || err != nil {
+		t.Fatalf("Has: got %v, %v", ok, err)
+	}
+
+	snap := h.getSnapshot()
+	defer snap.Release()
+	merge("a", "4")
+	want := "(a->1,2,3,4)(b->x)(c->new)"
+	h.getKeyVal(want)
+	if got, rwant := reverse(h.db), "(c->new)(b->x)(a->1,2,3,4)"; got != rwant {
+		t.Errorf("reverse iteration: got %q, want %q", got, rwant)
+	}
+	h.getValr(snap, "a", "1,2,3")
+
+	// Changing direction on a merged key.
+	iter := h.db.NewIterator(nil, nil)
+	if !iter.Seek([]byte("b")) || !iter.Prev() || string(iter.Value()) != "1,2,3,4" ||
+		!iter.Next() || string(iter.Key()) != "b" || !iter.Next() || string(iter.Value()) != "new" {
+		t.Errorf("iterator: got %q->%q, %v", iter.Key(), iter.Value(), iter.Error())
+	}
+	iter.Release()
+
+	// Operands seen by every snapshot are folded by compactions.
+	h.compactMem()
+	h.getKeyVal(want)
+	h.compactRange("", "")
+	h.allEntriesFor("a", "[ +4, 1,2,3 ]")
+	h.allEntriesFor("c", "[ new ]")
+	h.getKeyVal(want)
+	h.getValr(snap, "a", "1,2,3")
+	snap.Release()
+
+	// Without the base value in the compaction, operands are only combined.
+	h.put("d", "base")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.compactRangeAt(1, "d", "d")
+	merge("d", "m1")
+	h.compactMem()
+	merge("d", "m2")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.tablesPerLevel("0,2,1")
+	h.allEntriesFor("d", "[ +m1,m2, base ]")
+	h.getVal("d", "base,m1,m2")
+	h.compactRange("", "")
+	h.allEntriesFor("d", "[ base,m1,m2 ]")
+
+	merge("e", "y")
+	h.reopenDB()
+	h.getKeyVal("(a->1,2,3,4)(b->x)(c->new)(d->base,m1,m2)(e->y)")
+
+	// Merges need a MergeOperator and the primary tree.
+	b := new(Batch)
+	b.Merge([]byte("f"), []byte("z"))
+	if err := h.db.Write_s(b, h.wo); err != ErrMergeUnsupported {
+		t.Errorf("Write_s: want ErrMergeUnsupported, got %v", err)
+	}
+	h2 := newDbHarness(t)
+	defer h2.close()
+	if err := h2.db.Merge([]byte("f"), []byte("z"), h2.wo); err != ErrMergeUnsupported {
+		t.Errorf("Merge: want ErrMergeUnsupported, got %v", err)
+	}
+	if err := h2.db.Write(b, h2.wo); err != ErrMergeUnsupported {
+		t.Errorf("Write: want ErrMergeUnsupported, got %v", err)
+	}
+}
+
+func TestDB_CompactionFilter(t *testing.T) {
+	ttl := NewTTLFilter(time.Hour, []byte("ttl/"))
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		BlobThreshold:                100,
+		CompactionFilter: func(level int, key, value []byte, tree opt.Tree) (opt.CompactionDecision, []byte) {
+			switch {
+			case tree == opt.SecondaryTree && bytes.HasPrefix(key, []byte("drop/")):
+				return opt.CompactionRemove, nil
+			case bytes.HasPrefix(key, []byte("up/")):
+				return opt.CompactionChange, bytes.ToUpper(value)
+			}
+			return ttl(level, key, value, tree)
+		},
+	})
+	defer h.close()
+
+	now := time.Now()
+	stamp := func(v string, age time.Duration) string {
+		return string(AppendTTLTimestamp([]byte(v), now.Add(-age)))
+	}
+	compact := func() {
+		t.Helper()
+		if err := h.db.CompactRange(util.Range{}); err != nil {
+			t.Fatal("CompactRange: got error: ", err)
+		}
+		if err := h.db.CompactRange_s(util.Range{}); err != nil {
+			t.Fatal("CompactRange_s: got error: ", err)
+		}
+	}
+	big := strings.Repeat("v", 200)
+
+	h.put("ttl/old", stamp("a", 2*time.Hour))
+	h.put("ttl/new", stamp("b", 0))
+	h.put("other/old", stamp("c", 2*time.Hour))
+	h.put("up/a", "x")
+	h.put_s("drop/a", "y")
+	h.put_s("keep/a", "z")
+	h.put_s("up/big", big)
+
+	// Nothing a snapshot sees is filtered.
+	snap := h.getSnapshot()
+	compact()
+	h.getVal("ttl/old", stamp("a", 2*time.Hour))
+	h.getVal_s("drop/a", "y")
+	snap.Release()
+
+	// Overlapping tables make the next compactions rewrite every key.
+	for _, key := range []string{"a", "z"} {
+		h.put(key, "1")
+		h.put_s(key, "1")
+	}
+	compact()
+	h.get("ttl/old", false)
+	h.getVal("ttl/new", stamp("b", 0))
+	h.getVal("other/old", stamp("c", 2*time.Hour))
+	h.getVal("up/a", "X")
+	h.get_s("drop/a", false)
+	h.getVal_s("keep/a", "z")
+	h.getVal_s("up/big", strings.ToUpper(big))
+
+	// An expired value doesn't bring back the older one it replaced.
+	h.put("ttl/x", stamp("fresh", 0))
+	compact()
+	h.put("ttl/x", stamp("stale", 2*time.Hour))
+	h.put("a", "2")
+	h.put("z", "2")
+	compact()
+	h.get("ttl/x", false)
+
+	if v, ts, ok := SplitTTLTimestamp([]byte(stamp("b", 0))); !ok || string(v) != "b" || ts.Unix() != now.Unix() {
+		t.Errorf("SplitTTLTimestamp: got %q, %v, %v", v, ts, ok)
+	}
+}
+
+type recordingListener struct {
+	opt.NopListener
+
+	mu          sync.Mutex
+	flushes     []opt.FlushInfo
+	compactions []opt.CompactionInfo
+	stalls      []opt.WriteStallInfo
+	created     map[int64]opt.Tree
+	deleted     []int64
+	begins      int
+}
+
+func (l *recordingListener) OnFlushBegin(opt.FlushInfo) {
+	l.mu.Lock()
+	l.begins++
+	l.mu.Unlock()
+}
+
+func (l *recordingListener) OnFlushEnd(info opt.FlushInfo) {
+	l.mu.Lock()
+	l.flushes = append(l.flushes, info)
+	l.mu.Unlock()
+}
+
+func (l *recordingListener) OnCompactionBegin(opt.CompactionInfo) {
+	l.mu.Lock()
+	l.begins++
+	l.mu.Unlock()
+}
+
+func (l *recordingListener) OnCompactionEnd(info opt.CompactionInfo) {
+	l.mu.Lock()
+	l.compactions = append(l.compactions, info)
+	l.mu.Unlock()
+}
+
+func (l *recordingListener) OnWriteStallEnd(info opt.WriteStallInfo) {
+	l.mu.Lock()
+	l.stalls = append(l.stalls, info)
+	l.mu.Unlock()
+}
+
+func (l *recordingListener) OnTableCreated(info opt.TableInfo) {
+	l.mu.Lock()
+	l.created[info.FileNum] = info.Tree
+	l.mu.Unlock()
+}
+
+func (l *recordingListener) OnTableDeleted(info opt.TableInfo) {
+	l.mu.Lock()
+	l.deleted = append(l.deleted, info.FileNum)
+	l.mu.Unlock()
+}
+
+func TestDB_Listener(t *testing.T) {
+	l := &recordingListener{created: make(map[int64]opt.Tree)}
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		CompactionL0Trigger:          100,
+		WriteL0SlowdownTrigger:       1,
+		Listener:                     l,
+	})
+	defer h.close()
+
+	for i := 0; i < 4; i++ {
+		h.put("k", fmt.Sprint(i))
+		h.put_s("k", fmt.Sprint(i))
+		h.compactMem()
+		if err := h.db.CompactRange_s(util.Range{}); err != nil {
+			t.Fatal("CompactRange_s: got error: ", err)
+		}
+	}
+	// Level-0 holds a table now, writes are slowed down.
+	if h.db.s.tLen(0) == 0 {
+		t.Fatal("no level-0 table")
+	}
+	h.put("k", "stalled")
+	h.compactRange("", "")
+	h.closeDB()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if got, want := l.begins, len(l.flushes)+len(l.compactions); got != want {
+		t.Errorf("begin events: got %d, want %d", got, want)
+	}
+	var trees [2]int
+	for _, info := range l.flushes {
+		trees[info.Tree]++
+		if tree, ok := l.created[info.FileNum]; !ok || tree != info.Tree || info.Entries != 1 {
+			t.Errorf("flush: unexpected %+v", info)
+		}
+	}
+	if trees[0] < 4 || trees[1] < 4 {
+		t.Errorf("flushes: got %v per tree", trees)
+	}
+	rewrites := 0
+	for _, info := range l.compactions {
+		if len(info.Inputs) == 0 || len(info.Outputs) == 0 {
+			t.Errorf("compaction: unexpected %+v", info)
+		}
+		if info.Trivial {
+			continue
+		}
+		rewrites++
+		for _, num := range info.Outputs {
+			if tree, ok := l.created[num]; !ok || tree != info.Tree {
+				t.Errorf("compaction: output @%d not created in %v", num, info.Tree)
+			}
+		}
+	}
+	if rewrites == 0 {
+		t.Error("no compaction rewrote tables")
+	}
+	if len(l.deleted) == 0 {
+		t.Error("no table deleted")
+	}
+	if len(l.stalls) == 0 || l.stalls[0].Cause != opt.WriteStallSlowdown || l.stalls[0].Level0Tables == 0 {
+		t.Errorf("write stalls: got %+v", l.stalls)
+	}
+}
+
+func TestDB_RateLimiter(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		Compression:                  opt.NoCompression,
+		RateLimiter:                  &opt.RateLimiter{BytesPerSec: 256 << 10, Burst: 16 << 10},
+		Secondary: &opt.Options{
+			RateLimiter: &opt.RateLimiter{BytesPerSec: 512 << 10},
+		},
+	})
+	defer h.close()
+
+	if rate, rate_s := h.db.s.tops.limiter.rate, h.db.s.tops.limiter_s.rate; rate != 256<<10 || rate_s != 512<<10 {
+		t.Fatalf("rates: got %d and %d", rate, rate_s)
+	}
+	value := strings.Repeat("v", 1<<10)
+	for i := 0; i < 64; i++ {
+		h.put(fmt.Sprintf("k%03d", i), value)
+		h.put_s(fmt.Sprintf("k%03d", i), value)
+	}
+	start := time.Now()
+	h.compactMem()
+	if err := h.db.CompactRange_s(util.Range{}); err != nil {
+		t.Fatal("CompactRange_s: got error: ", err)
+	}
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Errorf("flushes took %v, want throttled", d)
+	}
+	var s DBStats
+	if err := h.db.Stats(&s); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	if s.ThrottleDuration == 0 || s.ThrottleDuration_s == 0 {
+		t.Fatalf("throttle durations: got %v and %v", s.ThrottleDuration, s.ThrottleDuration_s)
+	}
+
+	for _, tree := range []Tree{PrimaryTree, SecondaryTree} {
+		if err := h.db.SetCompactionRate(tree, 0); err != nil {
+			t.Fatal("SetCompactionRate: got error: ", err)
+		}
+	}
+	for i := 0; i < 64; i++ {
+		h.put(fmt.Sprintf("k%03d", i), value+"2")
+		h.put_s(fmt.Sprintf("k%03d", i), value+"2")
+	}
+	h.compactRange("", "")
+	if err := h.db.CompactRange_s(util.Range{}); err != nil {
+		t.Fatal("CompactRange_s: got error: ", err)
+	}
+	var s2 DBStats
+	if err := h.db.Stats(&s2); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	if s2.ThrottleDuration != s.ThrottleDuration || s2.ThrottleDuration_s != s.ThrottleDuration_s {
+		t.Errorf("throttled at rate 0: got %v and %v", s2.ThrottleDuration, s2.ThrottleDuration_s)
+	}
+	h.getVal("k000", value+"2")
+	h.getVal_s("k063", value+"2")
+
+	// Only the secondary tree is throttled again.
+	if err := h.db.SetCompactionRate(SecondaryTree, 256<<10); err != nil {
+		t.Fatal("SetCompactionRate: got error: ", err)
+	}
+	for i := 0; i < 64; i++ {
+		h.put(fmt.Sprintf("k%03d", i), value+"3")
+		h.put_s(fmt.Sprintf("k%03d", i), value+"3")
+	}
+	h.compactMem()
+	if err := h.db.CompactRange_s(util.Range{}); err != nil {
+		t.Fatal("CompactRange_s: got error: ", err)
+	}
+	var s3 DBStats
+	if err := h.db.Stats(&s3); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	if s3.ThrottleDuration != s.ThrottleDuration || s3.ThrottleDuration_s == s.ThrottleDuration_s {
+		t.Errorf("throttle durations: got %v and %v", s3.ThrottleDuration, s3.ThrottleDuration_s)
+	}
+}
@@ -95,7 +95,7 @@ func (tr *Transaction) flush() error {
 	if tr.mem.Len() != 0 {
 		tr.stats.startTimer()
 		iter := tr.mem.NewIterator(nil)
-		t, n, err := tr.db.s.tops.createFrom(iter)
+		t, n, err := tr.db.s.tops.createFrom(iter, nil)
 		iter.Release()
 		tr.stats.stopTimer()
 		if err != nil {
@@ -120,7 +120,7 @@ func (tr *Transaction) flush_s() error {
 	if tr.mem.Len_s() != 0 {
 		tr.stats.startTimer()
 		iter := tr.mem.NewIterator_s(nil)
-		t, n, err := tr.db.s.tops.createFrom_s(iter)
+		t, n, err := tr.db.s.tops.createFrom_s(iter, nil)
 		iter.Release()
 		tr.stats.stopTimer()
 		if err != nil {
@@ -201,11 +201,20 @@ func (tr *Transaction) Delete(key []byte, wo *opt.WriteOptions) error {
 // Please note that the transaction is not compacted until committed, so if you
 // writes 10 same keys, then those 10 same keys are in the transaction.
 //
+// A batch holding range deletions is refused with ErrRangeDelUnsupported,
+// and one holding merge operands with ErrMergeUnsupported.
+//
 // It is safe to modify the contents of the arguments after Write returns.
 func (tr *Transaction) Write(b *Batch, wo *opt.WriteOptions) error {
 	if b == nil || b.Len() == 0 {
 		return nil
 	}
+	if b.hasRangeDel() {
+		return ErrRangeDelUnsupported
+	}
+	if b.hasMerge() {
+		return ErrMergeUnsupported
+	}
 
 	tr.lk.Lock()
 	defer tr.lk.Unlock()
@@ -53,6 +53,25 @@ func (db *DB) writeJournal_s(batches []*Batch, seq uint64, sync bool) error {
 	return nil
 }
 
+// writeJournalTree writes a TreeBatch into the primary journal as a single
+// record, so that recovery replays both halves or neither.
+func (db *DB) writeJournalTree(b *TreeBatch, seq uint64, sync bool) error {
+	wr, err := db.journal.Next()
+	if err != nil {
+		return err
+	}
+	if err := writeTreeBatchWithHeader(wr, b, seq); err != nil {
+		return err
+	}
+	if err := db.journal.Flush(); err != nil {
+		return err
+	}
+	if sync {
+		return db.journalWriter.Sync()
+	}
+	return nil
+}
+
 func (db *DB) rotateMem(n int, wait bool) (mem *memDB, err error) {
 	//fmt.Print("Mem空间不足")
 	retryLimit := 3
@@ -128,6 +147,18 @@ retry:
 	return
 }
 
+// Runs wait, the delay of a write stalled by the level-0 tables of the tree,
+// reporting the stall to the Listener.
+func (db *DB) stallWrite(tree opt.Tree, cause opt.WriteStallCause, tLen int, wait func()) {
+	listener := db.s.o.GetListener()
+	info := opt.WriteStallInfo{Tree: tree, Cause: cause, Level0Tables: tLen}
+	listener.OnWriteStallBegin(info)
+	start := time.Now()
+	wait()
+	info.Duration = time.Since(start)
+	listener.OnWriteStallEnd(info)
+}
+
 func (db *DB) flush(n int) (mdb *memDB, mdbFree int, err error) { //n为batch.internallen，是指batch的大小？
 	delayed := false
 	slowdownTrigger := db.s.o.GetWriteL0SlowdownTrigger()
@@ -151,18 +182,22 @@ func (db *DB) flush(n int) (mdb *memDB, mdbFree int, err error) { //n为batch.in
 		case tLen >= slowdownTrigger && !delayed:
 			//	fmt.Print(" case 1 ")
 			delayed = true
-			time.Sleep(time.Millisecond)
+			db.stallWrite(opt.PrimaryTree, opt.WriteStallSlowdown, tLen, func() {
+				time.Sleep(time.Millisecond)
+			})
 		case mdbFree >= n:
 			//	fmt.Print(" case 2 ")
 			return false
 		case tLen >= pauseTrigger:
 			//		fmt.Print(" case 3 ")
 			delayed = true
-			// Set the write paused flag explicitly.
-			atomic.StoreInt32(&db.inWritePaused, 1)
-			err = db.compTriggerWait(db.tcompCmdC)
-			// Unset the write paused flag.
-			atomic.StoreInt32(&db.inWritePaused, 0)
+			db.stallWrite(opt.PrimaryTree, opt.WriteStallPause, tLen, func() {
+				// Set the write paused flag explicitly.
+				atomic.StoreInt32(&db.inWritePaused, 1)
+				err = db.compTriggerWait(db.tcompCmdC)
+				// Unset the write paused flag.
+				atomic.StoreInt32(&db.inWritePaused, 0)
+			})
 			if err != nil {
 				return false
 			}
@@ -214,9 +249,9 @@ func (db *DB) flush(n int) (mdb *memDB, mdbFree int, err error) { //n为batch.in
 
 func (db *DB) flush_s(n int) (mdb *memDB, mdbFree int, err error) { //此时，mdbFree结束后应为4,参数为batch的大小
 	delayed := false
-	slowdownTrigger := db.s.o.GetWriteL0SlowdownTrigger2()
-	pauseTrigger := db.s.o.GetWriteL0PauseTrigger2() // int 1
-	flush := func() (retry bool) {                   //是一个类型，下面的有一个循环等待返回false？
+	slowdownTrigger := db.s.o_s.GetWriteL0SlowdownTrigger()
+	pauseTrigger := db.s.o_s.GetWriteL0PauseTrigger() // int 1
+	flush := func() (retry bool) {                    //是一个类型，下面的有一个循环等待返回false？
 		mdb = db.getEffectiveMem_s() //得到effective mdb
 		//fmt.Print(" GetMems ")
 		if mdb == nil {
@@ -236,18 +271,22 @@ func (db *DB) flush_s(n int) (mdb *memDB, mdbFree int, err error) { //此时，m
 		case tLen >= slowdownTrigger && !delayed:
 			//fmt.Print(" case 1 ")
 			delayed = true
-			time.Sleep(time.Millisecond)
+			db.stallWrite(opt.SecondaryTree, opt.WriteStallSlowdown, tLen, func() {
+				time.Sleep(time.Millisecond)
+			})
 		case mdbFree >= n:
 			//	fmt.Print(" case 2 ")
 			return false
 		case tLen >= pauseTrigger:
 			//	fmt.Print(" case 3 ")
 			delayed = true
-			// Set the write paused flag explicitly.
-			atomic.StoreInt32(&db.inWritePaused, 1)
-			err = db.compTriggerWait(db.tcompCmdCs)
-			// Unset the write paused flag.
-			atomic.StoreInt32(&db.inWritePaused, 0)
+			db.stallWrite(opt.SecondaryTree, opt.WriteStallPause, tLen, func() {
+				// Set the write paused flag explicitly.
+				atomic.StoreInt32(&db.inWritePaused, 1)
+				err = db.compTriggerWait(db.tcompCmdCs)
+				// Unset the write paused flag.
+				atomic.StoreInt32(&db.inWritePaused, 0)
+			})
 			if err != nil {
 				return false
 			}
@@ -317,6 +356,18 @@ func (db *DB) unlockWrite(overflow bool, merged int, err error) {
 		<-db.writeLockC
 	}
 }
+func (db *DB) unlockWrite_s(overflow bool, merged int, err error) {
+	for i := 0; i < merged; i++ {
+		db.writeAckCs <- err
+	}
+	if overflow {
+		// Pass lock to the next write (that failed to merge).
+		db.writeMergedCs <- false
+	} else {
+		// Release lock.
+		<-db.writeLockC
+	}
+}
 
 // ourBatch is batch that we can modify.
 // 是线程真正执行写入的函数，其写入流程为：
@@ -491,7 +542,7 @@ func (db *DB) writeLocked_s(batch, ourBatch *Batch, merge, sync bool) error {
 	// 返回DB的mdb以及mdb的剩余空间，如果mdbFree不够则会对mdb进行扩容操作
 	mdb, mdbFree, err := db.flush_s(batch.internalLen) //这个mdb可以调用好多方法 .db和*memdb.db？
 	if err != nil {
-		db.unlockWrite(false, 0, err)
+		db.unlockWrite_s(false, 0, err)
 		return err
 	}
 	defer mdb.decref_s() //释放当前引用数量
@@ -519,7 +570,7 @@ func (db *DB) writeLocked_s(batch, ourBatch *Batch, merge, sync bool) error {
 	merge:
 		for mergeLimit > 0 {
 			select {
-			case incoming := <-db.writeMergeC:
+			case incoming := <-db.writeMergeCs:
 				if incoming.batch != nil {
 					// Merge batch.
 					if incoming.batch.internalLen > mergeLimit {
@@ -547,7 +598,7 @@ func (db *DB) writeLocked_s(batch, ourBatch *Batch, merge, sync bool) error {
 				}
 				sync = sync || incoming.sync
 				merged++
-				db.writeMergedC <- true
+				db.writeMergedCs <- true
 
 			default:
 				break merge
@@ -565,7 +616,7 @@ func (db *DB) writeLocked_s(batch, ourBatch *Batch, merge, sync bool) error {
 	//2.batch中的信息写入日志
 	t1 := time.Now()
 	if err := db.writeJournal_s(batches, seq, sync); err != nil {
-		db.unlockWrite(overflow, merged, err)
+		db.unlockWrite_s(overflow, merged, err)
 		return err
 	}
 	t2 := time.Now()
@@ -594,7 +645,7 @@ func (db *DB) writeLocked_s(batch, ourBatch *Batch, merge, sync bool) error {
 		//fmt.Println("为什么不执行阿")
 		db.rotateMem_s(0, false)
 	}
-	db.unlockWrite(overflow, merged, nil)
+	db.unlockWrite_s(overflow, merged, nil)
 	//fmt.Println("return，一次写过程调用完成")
 	//fmt.Println("  Write Success， return")
 	return nil
@@ -605,6 +656,9 @@ func (db *DB) writeLocked_s(batch, ourBatch *Batch, merge, sync bool) error {
 // batch is small enough, write will try to merge the batches. Set NoWriteMerge
 // option to true to disable write merge.
 //
+// A batch writing merge operands without MergeOperator, or to the secondary
+// tree, is refused with ErrMergeUnsupported.
+//
 // It is safe to modify the contents of the arguments after Write returns but
 // not before. Write will not modify content of the batch.
 // batch的write的实现，
@@ -612,8 +666,30 @@ func (db *DB) Write(batch *Batch, wo *opt.WriteOptions) error {
 	if err := db.ok(); err != nil || batch == nil || batch.Len() == 0 {
 		return err
 	}
+	if router := db.s.o.GetKeyRouter(); router != nil {
+		tb := new(TreeBatch)
+		batch.replayInternal(func(i int, kt keyType, k, v []byte) error {
+			if kt == keyTypeRangeDel {
+				// A range may span both trees.
+				tb.primary.appendRec(kt, k, v)
+				tb.secondary.appendRec(kt, k, v)
+				return nil
+			}
+			tb.batch(router(k)).appendRec(kt, k, v)
+			return nil
+		})
+		switch {
+		case tb.primary.Len() == 0:
+			return db.Write_s(batch, wo)
+		case tb.secondary.Len() != 0:
+			return db.WriteTree(tb, wo)
+		}
+	}
+	if err := db.checkMerge(batch, nil); err != nil {
+		return err
+	}
 	//如果批处理大小大于写缓冲区，则可以使用事务进行写。使用事务将批处理直接写入表中，跳过日志记录。
-	if batch.internalLen > db.s.o.GetWriteBuffer() && !db.s.o.GetDisableLargeBatchTransaction() {
+	if batch.internalLen > db.s.o.GetWriteBuffer() && !db.s.o.GetDisableLargeBatchTransaction() && !batch.hasRangeDel() && !batch.hasMerge() {
 		tr, err := db.OpenTransaction()
 		if err != nil {
 			return err
@@ -665,8 +741,11 @@ func (db *DB) Write_s(batch *Batch, wo *opt.WriteOptions) error {
 	if err := db.ok(); err != nil || batch == nil || batch.Len() == 0 {
 		return err
 	}
+	if err := db.checkMerge(nil, batch); err != nil {
+		return err
+	}
 	//如果批处理大小大于写缓冲区，则可以使用事务进行写。使用事务将批处理直接写入表中，跳过日志记录。
-	if batch.internalLen > db.s.o.GetWriteBuffer2() && !db.s.o.GetDisableLargeBatchTransaction() {
+	if batch.internalLen > db.s.o_s.GetWriteBuffer() && !db.s.o.GetDisableLargeBatchTransaction() && !batch.hasRangeDel() {
 		tr, err := db.OpenTransaction()
 		if err != nil {
 			return err
@@ -684,10 +763,10 @@ func (db *DB) Write_s(batch *Batch, wo *opt.WriteOptions) error {
 	// Acquire write lock.
 	if merge {
 		select {
-		case db.writeMergeC <- writeMerge{sync: sync, batch: batch}:
-			if <-db.writeMergedC {
+		case db.writeMergeCs <- writeMerge{sync: sync, batch: batch}:
+			if <-db.writeMergedCs {
 				// Write is merged.
-				return <-db.writeAckC
+				return <-db.writeAckCs
 			}
 			// Write is not merged, the write lock is handed to us. Continue.
 		case db.writeLockC <- struct{}{}:
@@ -715,6 +794,114 @@ func (db *DB) Write_s(batch *Batch, wo *opt.WriteOptions) error {
 	return db.writeLocked_s(batch, nil, merge, sync)
 }
 
+// WriteTree applies the given TreeBatch to both trees atomically. The records
+// of both trees share one sequence range, the primary records come first.
+//
+// The whole batch is written to the primary journal as a single record, and
+// the secondary half is written to the secondary journal too, so it outlives
+// the primary journal once that is flushed. Journal recovery replays the
+// batch into both trees or neither. WriteTree never merges with concurrent
+// writes and does not use the large batch transaction.
+//
+// It is safe to modify the contents of the arguments after WriteTree returns
+// but not before. WriteTree will not modify content of the batch.
+func (db *DB) WriteTree(b *TreeBatch, wo *opt.WriteOptions) error {
+	if err := db.ok(); err != nil || b == nil || b.Len() == 0 {
+		return err
+	}
+	if err := db.checkMerge(&b.primary, &b.secondary); err != nil {
+		return err
+	}
+	sync := wo.GetSync() && !db.s.o.GetNoSync()
+
+	// Acquire write lock.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err := <-db.compPerErrC:
+		return err
+	case <-db.closeC:
+		return ErrClosed
+	}
+	defer func() { <-db.writeLockC }()
+
+	return db.writeTreeLocked(b, sync)
+}
+
+// checkMerge returns ErrMergeUnsupported if merge operands are written to
+// the secondary tree, or to the primary tree without MergeOperator.
+func (db *DB) checkMerge(primary, secondary *Batch) error {
+	if (primary != nil && primary.hasMerge() && db.s.o.GetMergeOperator() == nil) || (secondary != nil && secondary.hasMerge()) {
+		return ErrMergeUnsupported
+	}
+	return nil
+}
+
+// writeTreeLocked applies b to both trees, the caller must hold writeLockC.
+func (db *DB) writeTreeLocked(b *TreeBatch, sync bool) error {
+	primary, secondary := &b.primary, &b.secondary
+	var (
+		mdb, mdbs         *memDB
+		mdbFree, mdbsFree int
+		err               error
+	)
+	if primary.Len() > 0 {
+		mdb, mdbFree, err = db.flush(primary.internalLen)
+		if err != nil {
+			return err
+		}
+		defer mdb.decref()
+	}
+	if secondary.Len() > 0 {
+		mdbs, mdbsFree, err = db.flush_s(secondary.internalLen)
+		if err != nil {
+			return err
+		}
+		defer mdbs.decref_s()
+	}
+
+	seq := db.seq + 1
+	seq2 := seq + uint64(primary.Len())
+
+	// Write journal. The primary journal record is the commit point.
+	switch {
+	case secondary.Len() == 0:
+		err = db.writeJournal([]*Batch{primary}, seq, sync)
+	case primary.Len() == 0:
+		err = db.writeJournal_s([]*Batch{secondary}, seq2, sync)
+	default:
+		if err = db.writeJournalTree(b, seq, sync); err == nil {
+			err = db.writeJournal_s([]*Batch{secondary}, seq2, sync)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// Put batches.
+	if mdb != nil {
+		if err := primary.putMem(seq, mdb.DB); err != nil {
+			panic(err)
+		}
+	}
+	if mdbs != nil {
+		if err := secondary.putMem_s(seq2, mdbs.DBs); err != nil {
+			panic(err)
+		}
+	}
+
+	// Incr seq number.
+	db.addSeq(uint64(b.Len()))
+
+	// Rotate memdb if it's reach the threshold.
+	if mdb != nil && primary.internalLen >= mdbFree {
+		db.rotateMem(0, false)
+	}
+	if mdbs != nil && secondary.internalLen >= mdbsFree {
+		db.rotateMem_s(0, false)
+	}
+	return nil
+}
+
 // 事务写的逻辑
 // 在这里作者使用writeMergeC、writeMergedC、writeAckC和writeLockC共同控
 // 制多线程的数据插入和合并操作。这四个channel的定义如下，
@@ -796,12 +983,12 @@ func (db *DB) putRec_s(kt keyType, key, value []byte, wo *opt.WriteOptions) erro
 	if merge {
 		select {
 		//<-表示数据的流动方向，通过channel实现多线程的通信
-		case db.writeMergeC <- writeMerge{sync: sync, keyType: kt, key: key, value: value}:
+		case db.writeMergeCs <- writeMerge{sync: sync, keyType: kt, key: key, value: value}:
 			//如果能向writeMergeC 写入新插入的key value 数据
 			//则等待新的key value与老的数据进行merge操作
-			if <-db.writeMergedC {
+			if <-db.writeMergedCs {
 				// Write is merged.
-				return <-db.writeAckC
+				return <-db.writeAckCs
 			}
 			// Write is not merged, the write lock is handed to us. Continue.
 		case db.writeLockC <- struct{}{}: //尝试获取写锁
@@ -852,7 +1039,9 @@ var (
 )
 
 func (db *DB) Put(key, value []byte, wo *opt.WriteOptions) error {
-	NEW = key[1:5]
+	if db.routeTree(key) == SecondaryTree {
+		return db.putRec_s(keyTypeVal, key, value, wo)
+	}
 	return db.putRec(keyTypeVal, key, value, wo)
 }
 
@@ -868,15 +1057,80 @@ func (db *DB) Put_s(key, value []byte, wo *opt.WriteOptions) error {
 // It is safe to modify the contents of the arguments after Delete returns but
 // not before.
 func (db *DB) Delete(key []byte, wo *opt.WriteOptions) error {
+	if db.routeTree(key) == SecondaryTree {
+		return db.putRec_s(keyTypeDel, key, nil, wo)
+	}
 	return db.putRec(keyTypeDel, key, nil, wo)
 }
 
+// Delete_s deletes the value for the given key from the secondary tree. The
+// deletion is recorded as a tombstone which shadows older versions of the key
+// until compaction drops it at the base level.
+//
+// It is safe to modify the contents of the arguments after Delete_s returns
+// but not before.
+func (db *DB) Delete_s(key []byte, wo *opt.WriteOptions) error {
+	return db.putRec_s(keyTypeDel, key, nil, wo)
+}
+
+// DeleteRange deletes the keys from start, inclusive, up to limit,
+// exclusive. The deletion is recorded as a single range tombstone, which
+// shadows the older versions of the keys it covers until compaction drops
+// them. When a KeyRouter is set the range is deleted from both trees. Write
+// merge also applies for DeleteRange, see Write.
+//
+// It is safe to modify the contents of the arguments after DeleteRange
+// returns but not before.
+func (db *DB) DeleteRange(start, limit []byte, wo *opt.WriteOptions) error {
+	b := new(Batch)
+	b.DeleteRange(start, limit)
+	return db.Write(b, wo)
+}
+
+// DeleteRange_s deletes the keys from start, inclusive, up to limit,
+// exclusive, from the secondary tree, see DeleteRange.
+func (db *DB) DeleteRange_s(start, limit []byte, wo *opt.WriteOptions) error {
+	b := new(Batch)
+	b.DeleteRange(start, limit)
+	return db.Write_s(b, wo)
+}
+
+// Merge records operand as a merge operand of the given key. The value of
+// the key becomes the result of MergeOperator.FullMerge over its previous
+// value and the operands written since, which is computed when the key is
+// read or compacted rather than by Merge. Write merge also applies for
+// Merge, see Write.
+//
+// Merge returns ErrMergeUnsupported without MergeOperator, or if the key is
+// routed to the secondary tree.
+//
+// It is safe to modify the contents of the arguments after Merge returns but
+// not before.
+func (db *DB) Merge(key, operand []byte, wo *opt.WriteOptions) error {
+	if db.s.o.GetMergeOperator() == nil || db.routeTree(key) == SecondaryTree {
+		return ErrMergeUnsupported
+	}
+	return db.putRec(keyTypeMerge, key, operand, wo)
+}
+
 func isMemOverlaps(icmp *iComparer, mem *memdb.DB, min, max []byte) bool {
+	if rangeTombstonesOverlap(icmp, mem.NewRangeTombstoneIterator(), min, max) {
+		return true
+	}
 	iter := mem.NewIterator(nil)
 	defer iter.Release()
 	return (max == nil || (iter.First() && icmp.uCompare(max, internalKey(iter.Key()).ukey()) >= 0)) &&
 		(min == nil || (iter.Last() && icmp.uCompare(min, internalKey(iter.Key()).ukey()) <= 0))
 }
+func isMemOverlaps_s(icmp *iComparer, mem *memdb.DBs, min, max []byte) bool {
+	if rangeTombstonesOverlap(icmp, mem.NewRangeTombstoneIterator_s(), min, max) {
+		return true
+	}
+	iter := mem.NewIterator_s(nil)
+	defer iter.Release()
+	return (max == nil || (iter.First() && icmp.uCompare(max, internalKey(iter.Key()).ukey()) >= 0)) &&
+		(min == nil || (iter.Last() && icmp.uCompare(min, internalKey(iter.Key()).ukey()) <= 0))
+}
 
 // CompactRange compacts the underlying DB for the given key range.
 // In particular, deleted and overwritten versions are discarded,
@@ -943,8 +1197,8 @@ func (db *DB) CompactRange_s(r util.Range) error {
 	if mdb == nil {
 		return ErrClosed
 	}
-	defer mdb.decref()
-	if isMemOverlaps(db.s.icmp, mdb.DB, r.Start, r.Limit) {
+	defer mdb.decref_s()
+	if isMemOverlaps_s(db.s.icmp, mdb.DBs, r.Start, r.Limit) {
 		// Memdb compaction.
 		if _, err := db.rotateMem_s(0, false); err != nil {
 			<-db.writeLockC
@@ -12,9 +12,11 @@ import (
 
 // Common errors.
 var (
-	ErrNotFound         = errors.ErrNotFound
-	ErrReadOnly         = errors.New("leveldb: read-only mode")
-	ErrSnapshotReleased = errors.New("leveldb: snapshot released")
-	ErrIterReleased     = errors.New("leveldb: iterator released")
-	ErrClosed           = errors.New("leveldb: closed")
+	ErrNotFound            = errors.ErrNotFound
+	ErrReadOnly            = errors.New("leveldb: read-only mode")
+	ErrSnapshotReleased    = errors.New("leveldb: snapshot released")
+	ErrIterReleased        = errors.New("leveldb: iterator released")
+	ErrClosed              = errors.New("leveldb: closed")
+	ErrRangeDelUnsupported = errors.New("leveldb: range deletion not supported")
+	ErrMergeUnsupported    = errors.New("leveldb: merge not supported")
 )
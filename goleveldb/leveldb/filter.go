@@ -7,7 +7,9 @@
 package leveldb
 
 import (
+	"awesomeProject1/goleveldb/leveldb/comparer"
 	"awesomeProject1/goleveldb/leveldb/filter"
+	"awesomeProject1/goleveldb/leveldb/util"
 )
 
 type iFilter struct {
@@ -29,3 +31,47 @@ type iFilterGenerator struct {
 func (g iFilterGenerator) Add(key []byte) {
 	g.FilterGenerator.Add(internalKey(key).ukey())
 }
+
+// iPrefixFilter is the iFilter of a prefix filter, it takes internal keys
+// and user key prefixes.
+type iPrefixFilter struct {
+	iFilter
+	pf filter.PrefixFilter
+}
+
+func (f iPrefixFilter) Prefix(key []byte) []byte {
+	return f.pf.Prefix(internalKey(key).ukey())
+}
+
+func (f iPrefixFilter) ContainsPrefix(filter, prefix []byte) bool {
+	return f.pf.ContainsPrefix(filter, prefix)
+}
+
+// newIFilter wraps the user filter f to take internal keys.
+func newIFilter(f filter.Filter) filter.Filter {
+	if pf, ok := f.(filter.PrefixFilter); ok {
+		return &iPrefixFilter{iFilter{f}, pf}
+	}
+	return &iFilter{f}
+}
+
+// slicePrefix returns the user key prefix of all the keys of slice, an
+// internal key range, or nil if they may not share one or the tables have no
+// prefix filter. Keys starting with the same bytes are only contiguous with
+// the default comparer.
+func slicePrefix(icmp *iComparer, o *cachedOptions, slice *util.Range) []byte {
+	pf, ok := o.Filter.(filter.PrefixFilter)
+	if !ok || slice == nil || slice.Start == nil || icmp.ucmp.Name() != comparer.DefaultComparer.Name() {
+		return nil
+	}
+	p := pf.Prefix(slice.Start)
+	if p == nil {
+		return nil
+	}
+	if limit := util.BytesPrefix(p).Limit; limit != nil {
+		if slice.Limit == nil || icmp.uCompare(internalKey(slice.Limit).ukey(), limit) > 0 {
+			return nil
+		}
+	}
+	return p
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// PrefixExtractor is the key prefix extractor of prefix filters.
+type PrefixExtractor interface {
+	// Name returns the name of this extractor.
+	//
+	// The name is part of the name of the prefix filters using this
+	// extractor, so it must be changed if the extracted prefixes change.
+	Name() string
+
+	// Prefix returns the prefix of the key, or nil if the key has none.
+	//
+	// The returned prefix must be a leading part of the key, and all the
+	// keys starting with it must have it as prefix. The prefix may refer
+	// to the key.
+	Prefix(key []byte) []byte
+}
+
+type funcPrefixExtractor struct {
+	name string
+	fn   func(key []byte) []byte
+}
+
+func (x funcPrefixExtractor) Name() string             { return x.name }
+func (x funcPrefixExtractor) Prefix(key []byte) []byte { return x.fn(key) }
+
+// NewPrefixExtractor creates a prefix extractor calling fn, see
+// PrefixExtractor.Prefix.
+func NewPrefixExtractor(name string, fn func(key []byte) []byte) PrefixExtractor {
+	return funcPrefixExtractor{name: name, fn: fn}
+}
+
+// NewFixedPrefix creates a prefix extractor returning the first n bytes of
+// the keys. Keys shorter than n have no prefix.
+func NewFixedPrefix(n int) PrefixExtractor {
+	return NewPrefixExtractor(fmt.Sprintf("leveldb.FixedPrefix.%d", n), func(key []byte) []byte {
+		if len(key) < n {
+			return nil
+		}
+		return key[:n]
+	})
+}
+
+// PrefixFilter is a filter also holding the prefixes of the keys added to
+// it, so it can tell whether any key with a given prefix may be present.
+type PrefixFilter interface {
+	Filter
+
+	// Prefix returns the prefix of the key, or nil if the key has none.
+	Prefix(key []byte) []byte
+
+	// ContainsPrefix returns true if the filter may contain a key with the
+	// given prefix.
+	ContainsPrefix(filter, prefix []byte) bool
+}
+
+type prefixFilter struct {
+	Filter
+	x PrefixExtractor
+}
+
+func (f prefixFilter) Name() string {
+	return f.Filter.Name() + "+prefix:" + f.x.Name()
+}
+
+func (f prefixFilter) Prefix(key []byte) []byte {
+	return f.x.Prefix(key)
+}
+
+func (f prefixFilter) ContainsPrefix(filter, prefix []byte) bool {
+	return f.Filter.Contains(filter, prefix)
+}
+
+func (f prefixFilter) NewGenerator() FilterGenerator {
+	return &prefixFilterGenerator{
+		FilterGenerator: f.Filter.NewGenerator(),
+		x:               f.x,
+	}
+}
+
+type prefixFilterGenerator struct {
+	FilterGenerator
+	x PrefixExtractor
+
+	// The last prefix added, keys are usually added in order so runs of
+	// keys share it.
+	prefix    []byte
+	hasPrefix bool
+}
+
+func (g *prefixFilterGenerator) Add(key []byte) {
+	g.FilterGenerator.Add(key)
+	if p := g.x.Prefix(key); p != nil && !(g.hasPrefix && bytes.Equal(p, g.prefix)) {
+		g.FilterGenerator.Add(p)
+		g.prefix = append(g.prefix[:0], p...)
+		g.hasPrefix = true
+	}
+}
+
+func (g *prefixFilterGenerator) Generate(b Buffer) {
+	g.FilterGenerator.Generate(b)
+	g.hasPrefix = false
+}
+
+// NewPrefixFilter creates a filter holding the keys added to it along with
+// their prefixes, as given by x, in the filter f. See opt.Options.Filter and
+// opt.Options.PrefixExtractor for more information.
+func NewPrefixFilter(f Filter, x PrefixExtractor) PrefixFilter {
+	return prefixFilter{Filter: f, x: x}
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"fmt"
+	"testing"
+
+	"awesomeProject1/goleveldb/leveldb/util"
+)
+
+func TestPrefixFilter(t *testing.T) {
+	f := NewPrefixFilter(NewBloomFilter(10), NewFixedPrefix(4))
+	if name := f.Name(); name != "leveldb.BuiltinBloomFilter+prefix:leveldb.FixedPrefix.4" {
+		t.Fatalf("unexpected name %q", name)
+	}
+	g := f.NewGenerator()
+	for i := 0; i < 100; i += 2 {
+		for j := 0; j < 10; j++ {
+			g.Add([]byte(fmt.Sprintf("p%03d-%d", i, j)))
+		}
+	}
+	g.Add([]byte("abc"))
+	buf := &util.Buffer{}
+	g.Generate(buf)
+	filter := buf.Bytes()
+
+	if !f.Contains(filter, []byte("p002-3")) || !f.Contains(filter, []byte("abc")) {
+		t.Error("added key not found")
+	}
+	if f.Prefix([]byte("abc")) != nil {
+		t.Error("short key has a prefix")
+	}
+	fp := 0
+	for i := 0; i < 100; i++ {
+		contains := f.ContainsPrefix(filter, []byte(fmt.Sprintf("p%03d", i)))
+		if i%2 == 0 && !contains {
+			t.Errorf("prefix p%03d not found", i)
+		} else if i%2 != 0 && contains {
+			fp++
+		}
+	}
+	if fp > 5 {
+		t.Errorf("too many false positive prefixes, got %d of 50", fp)
+	}
+}
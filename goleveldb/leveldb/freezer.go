@@ -0,0 +1,402 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"awesomeProject1/goleveldb/leveldb/errors"
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+)
+
+var (
+	errAncientKind        = errors.New("leveldb: unknown ancient kind")
+	errAncientOutOfBounds = errors.New("leveldb: ancient item out of bounds")
+	errAncientOrder       = errors.New("leveldb: ancient item out of order")
+	errAncientIncomplete  = errors.New("leveldb: ancient kinds appended unevenly")
+	errAncientTruncate    = errors.New("leveldb: ancient truncation out of bounds")
+)
+
+// freezerKinds are the kinds of the freezer, the position of a kind is the
+// number of its files.
+var freezerKinds = []string{"headers", "hashes", "bodies", "receipts", "diffs"}
+
+// freezer is the ancient store of a DB: an append-only flat-file table per
+// kind, living in the DB storage. Items are numbered from zero, and all
+// kinds hold the same range of numbers.
+type freezer struct {
+	mu       sync.RWMutex
+	tables   map[string]*freezerTable
+	tail     uint64 // Number of the first item
+	frozen   uint64 // Number of the item past the last one
+	noSync   bool
+	readOnly bool
+}
+
+func openFreezer(stor storage.Storage, o *opt.Options) (*freezer, error) {
+	f := &freezer{
+		tables:   make(map[string]*freezerTable, len(freezerKinds)),
+		noSync:   o.GetNoSync(),
+		readOnly: o.GetReadOnly(),
+	}
+	for i, kind := range freezerKinds {
+		t, err := openFreezerTable(stor, kind, int64(i), o.GetAncientCompression(kind) == opt.SnappyCompression, f.readOnly)
+		if err != nil {
+			f.close()
+			return nil, err
+		}
+		f.tables[kind] = t
+	}
+	if err := f.repair(); err != nil {
+		f.close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// repair brings all tables to the same head and tail, finishing an
+// interrupted TruncateTail and dropping the items of an interrupted
+// ModifyAncients.
+func (f *freezer) repair() error {
+	f.frozen = ^uint64(0)
+	for _, t := range f.tables {
+		if t.head() < f.frozen {
+			f.frozen = t.head()
+		}
+		if t.tail > f.tail {
+			f.tail = t.tail
+		}
+	}
+	if f.readOnly {
+		return nil
+	}
+	for _, t := range f.tables {
+		if err := t.truncateHead(f.frozen); err != nil {
+			return err
+		}
+		if err := t.truncateTail(f.tail); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *freezer) table(kind string) (*freezerTable, error) {
+	if t := f.tables[kind]; t != nil {
+		return t, nil
+	}
+	return nil, errAncientKind
+}
+
+func (f *freezer) hasAncient(kind string, number uint64) (bool, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if _, err := f.table(kind); err != nil {
+		return false, err
+	}
+	return number >= f.tail && number < f.frozen, nil
+}
+
+func (f *freezer) ancientRange(kind string, start, count, maxBytes uint64) ([][]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	t, err := f.table(kind)
+	if err != nil {
+		return nil, err
+	}
+	if start < f.tail {
+		return nil, errAncientOutOfBounds
+	}
+	return t.retrieve(start, count, maxBytes)
+}
+
+func (f *freezer) ancients() uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.frozen
+}
+
+func (f *freezer) tailNumber() uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.tail
+}
+
+func (f *freezer) ancientSize(kind string) (uint64, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	t, err := f.table(kind)
+	if err != nil {
+		return 0, err
+	}
+	return t.dataSize(), nil
+}
+
+// modify runs fn and commits the items it appended to every kind, or none
+// of them if fn fails.
+func (f *freezer) modify(fn func(ethdb.AncientWriteOp) error) (int64, error) {
+	if f.readOnly {
+		return 0, ErrReadOnly
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rollback := func() {
+		for _, t := range f.tables {
+			t.rollback()
+		}
+	}
+	if err := fn(&freezerBatch{f}); err != nil {
+		rollback()
+		return 0, err
+	}
+	n := f.tables[freezerKinds[0]].pendingItems
+	for _, t := range f.tables {
+		if t.pendingItems != n {
+			rollback()
+			return 0, errAncientIncomplete
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	var written int64
+	for _, kind := range freezerKinds {
+		size, err := f.tables[kind].commit()
+		if err == nil && !f.noSync {
+			err = f.tables[kind].sync()
+		}
+		if err != nil {
+			rollback()
+			for _, t := range f.tables {
+				t.truncateHead(f.frozen)
+			}
+			return 0, err
+		}
+		written += size
+	}
+	f.frozen += n
+	return written, nil
+}
+
+// truncateHead drops the items from number items onwards, and returns the
+// previous number of items.
+func (f *freezer) truncateHead(items uint64) (uint64, error) {
+	if f.readOnly {
+		return 0, ErrReadOnly
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	old := f.frozen
+	if items >= old {
+		return old, nil
+	}
+	if items < f.tail {
+		return old, errAncientTruncate
+	}
+	for _, kind := range freezerKinds {
+		t := f.tables[kind]
+		err := t.truncateHead(items)
+		if err == nil && !f.noSync {
+			err = t.sync()
+		}
+		if err != nil {
+			return old, err
+		}
+	}
+	f.frozen = items
+	return old, nil
+}
+
+// truncateTail drops the items before number tail, and returns the
+// previous tail.
+func (f *freezer) truncateTail(tail uint64) (uint64, error) {
+	if f.readOnly {
+		return 0, ErrReadOnly
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	old := f.tail
+	if tail <= old {
+		return old, nil
+	}
+	if tail > f.frozen {
+		return old, errAncientTruncate
+	}
+	// Items before the new tail are no longer served from here on, even if
+	// some tables fail to drop them; repair finishes the job.
+	f.tail = tail
+	for _, kind := range freezerKinds {
+		if err := f.tables[kind].truncateTail(tail); err != nil {
+			return old, err
+		}
+	}
+	return old, nil
+}
+
+func (f *freezer) sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, kind := range freezerKinds {
+		if err := f.tables[kind].sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// export copies the files of the tables into dir. They are appended in
+// place, so they can't be linked.
+func (f *freezer) export(ex storage.Exporter, dir string) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, kind := range freezerKinds {
+		t := f.tables[kind]
+		if t.index == nil {
+			// Nothing frozen yet.
+			continue
+		}
+		for _, fd := range []storage.FileDesc{t.indexFd, t.dataFd} {
+			if err := ex.Copy(fd, dir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *freezer) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, t := range f.tables {
+		t.sync()
+		t.close()
+	}
+}
+
+// freezerBatch is the ethdb.AncientWriteOp handed to ModifyAncients.
+type freezerBatch struct {
+	f *freezer
+}
+
+func (b *freezerBatch) Append(kind string, number uint64, item interface{}) error {
+	blob, err := rlp.EncodeToBytes(item)
+	if err != nil {
+		return err
+	}
+	return b.AppendRaw(kind, number, blob)
+}
+
+func (b *freezerBatch) AppendRaw(kind string, number uint64, item []byte) error {
+	t, err := b.f.table(kind)
+	if err != nil {
+		return err
+	}
+	return t.append(number, item)
+}
+
+// HasAncient returns whether item number of the given kind is in the
+// freezer.
+func (db *DB) HasAncient(kind string, number uint64) (bool, error) {
+	if err := db.ok(); err != nil {
+		return false, err
+	}
+	return db.frz.hasAncient(kind, number)
+}
+
+// Ancient returns item number of the given kind from the freezer.
+func (db *DB) Ancient(kind string, number uint64) ([]byte, error) {
+	if err := db.ok(); err != nil {
+		return nil, err
+	}
+	items, err := db.frz.ancientRange(kind, number, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	return items[0], nil
+}
+
+// AncientRange returns at most count items of the given kind from the
+// freezer, starting at number start. If maxBytes is not zero, the items
+// past maxBytes bytes are left out, yet at least one item is returned.
+func (db *DB) AncientRange(kind string, start, count, maxBytes uint64) ([][]byte, error) {
+	if err := db.ok(); err != nil {
+		return nil, err
+	}
+	return db.frz.ancientRange(kind, start, count, maxBytes)
+}
+
+// Ancients returns the number of the item past the last one of the freezer.
+func (db *DB) Ancients() (uint64, error) {
+	if err := db.ok(); err != nil {
+		return 0, err
+	}
+	return db.frz.ancients(), nil
+}
+
+// Tail returns the number of the first item of the freezer.
+func (db *DB) Tail() (uint64, error) {
+	if err := db.ok(); err != nil {
+		return 0, err
+	}
+	return db.frz.tailNumber(), nil
+}
+
+// AncientSize returns the bytes on disk of the given kind of the freezer.
+func (db *DB) AncientSize(kind string) (uint64, error) {
+	if err := db.ok(); err != nil {
+		return 0, err
+	}
+	return db.frz.ancientSize(kind)
+}
+
+// ModifyAncients runs fn and appends the items it adds to the freezer. Each
+// call must append the same numbers to every kind, starting at Ancients. If
+// fn returns an error nothing is appended. It returns the bytes written.
+func (db *DB) ModifyAncients(fn func(ethdb.AncientWriteOp) error) (int64, error) {
+	if err := db.ok(); err != nil {
+		return 0, err
+	}
+	return db.frz.modify(fn)
+}
+
+// TruncateHead discards the items of the freezer from number n onwards, e.g.
+// to roll back a reorg, and returns the previous number of items. It fails if
+// n is below the tail.
+func (db *DB) TruncateHead(n uint64) (uint64, error) {
+	if err := db.ok(); err != nil {
+		return 0, err
+	}
+	return db.frz.truncateHead(n)
+}
+
+// TruncateTail discards the items of the freezer before number n, which
+// becomes the tail, and returns the previous tail. It fails if n is past
+// Ancients. The space is given back once the dropped items outweigh the
+// remaining ones of a kind.
+func (db *DB) TruncateTail(n uint64) (uint64, error) {
+	if err := db.ok(); err != nil {
+		return 0, err
+	}
+	return db.frz.truncateTail(n)
+}
+
+// SyncAncient flushes the freezer to stable storage, needed only if NoSync
+// is set.
+func (db *DB) SyncAncient() error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+	return db.frz.sync()
+}
@@ -0,0 +1,539 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/golang/snappy"
+
+	"awesomeProject1/goleveldb/leveldb/errors"
+	"awesomeProject1/goleveldb/leveldb/storage"
+)
+
+// A freezer table is made of two append-only files, the data file holding the
+// items one after the other, and the index file holding a header followed by
+// the end offset in the data of each item.
+//
+// Index header:
+//
+//	+-------------+-------------+-------------+
+//	| flags (8B)  | tail (8B)   | base (8B)   |
+//	+-------------+-------------+-------------+
+//
+// The tail is the number of the first item and the base is its offset in the
+// data, all numbers are big-endian.
+//
+// Data header:
+//
+//	+-------------+
+//	| start (8B)  |
+//	+-------------+
+//
+// Offsets are counted from the start of the data as a whole, the start is the
+// offset of the first byte following the header. It only moves when the data
+// dropped by truncateTail is cut from the file.
+const (
+	indexHeaderLen = 24
+	indexEntryLen  = 8
+	dataHeaderLen  = 8
+
+	// The items of the table are snappy compressed.
+	freezerFlagSnappy = 1 << 0
+
+	// Files of a table are rewritten into temporary files of the same type
+	// numbered this much higher, then renamed over the originals.
+	freezerTempNum = 1 << 16
+
+	// Chunk size of the data copied by compactData.
+	freezerCopyChunk = 1 * 1024 * 1024
+)
+
+// roAppender serves a file of a read-only storage as an Appender that
+// refuses writes.
+type roAppender struct {
+	storage.Reader
+}
+
+func (roAppender) Write([]byte) (int, error) { return 0, ErrReadOnly }
+func (roAppender) Sync() error               { return nil }
+func (roAppender) Truncate(int64) error      { return ErrReadOnly }
+
+func (r roAppender) Size() (int64, error) {
+	return r.Seek(0, io.SeekEnd)
+}
+
+// freezerTable is the store of one freezer kind, it needs external
+// synchronization.
+type freezerTable struct {
+	stor         storage.Storage
+	kind         string
+	dataFd       storage.FileDesc
+	indexFd      storage.FileDesc
+	data, index  storage.Appender
+	snappy       bool
+	readOnly     bool
+	tail, items  uint64 // Number of the first item and number of items
+	base, size   uint64 // Data offsets of the first item and past the last item
+	start        uint64 // Data offset of the first byte of the data file
+	dataBuf      []byte // Pending data, see append
+	indexBuf     []byte // Pending index entries
+	pendingItems uint64
+}
+
+func openFreezerTable(stor storage.Storage, kind string, num int64, snappy, readOnly bool) (*freezerTable, error) {
+	t := &freezerTable{
+		stor:     stor,
+		kind:     kind,
+		dataFd:   storage.FileDesc{Type: storage.TypeAncient, Num: num},
+		indexFd:  storage.FileDesc{Type: storage.TypeAncientIndex, Num: num},
+		readOnly: readOnly,
+	}
+	if !readOnly {
+		// Drop the leftovers of an interrupted rewrite.
+		for _, fd := range []storage.FileDesc{tempFd(t.dataFd), tempFd(t.indexFd)} {
+			if err := stor.Remove(fd); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	}
+	r, err := stor.Open(t.indexFd)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Nothing was ever frozen, serve an empty table whose files
+			// are created by its first commit.
+			t.snappy = snappy
+			return t, nil
+		}
+		return nil, err
+	}
+	r.Close()
+	if err := t.create(snappy); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// create opens the files of the table, creating them if needed.
+func (t *freezerTable) create(snappy bool) error {
+	var err error
+	if t.index, err = t.open(t.indexFd); err != nil {
+		return err
+	}
+	if t.data, err = t.open(t.dataFd); err != nil {
+		t.index.Close()
+		t.index = nil
+		return err
+	}
+	if err := t.repair(snappy); err != nil {
+		t.close()
+		return err
+	}
+	return nil
+}
+
+func tempFd(fd storage.FileDesc) storage.FileDesc {
+	return storage.FileDesc{Type: fd.Type, Num: fd.Num + freezerTempNum}
+}
+
+func (t *freezerTable) open(fd storage.FileDesc) (storage.Appender, error) {
+	if !t.readOnly {
+		return t.stor.Append(fd)
+	}
+	r, err := t.stor.Open(fd)
+	if err != nil {
+		return nil, err
+	}
+	return roAppender{r}, nil
+}
+
+// phys returns the position in the data file of the given data offset.
+func (t *freezerTable) phys(off uint64) int64 {
+	return int64(off-t.start) + dataHeaderLen
+}
+
+// repair loads the index header and drops whatever follows the last item
+// fully written to both files.
+func (t *freezerTable) repair(snappy bool) error {
+	isize, err := t.index.Size()
+	if err != nil {
+		return err
+	}
+	if isize < indexHeaderLen {
+		if t.readOnly {
+			if isize == 0 {
+				return nil
+			}
+			return errors.NewErrCorrupted(t.indexFd, errors.New("leveldb: short ancient index header"))
+		}
+		// New table, or its header never made it to disk.
+		t.snappy = snappy
+		if err := t.writeDataHeader(); err != nil {
+			return err
+		}
+		return t.writeHeader()
+	}
+
+	header := make([]byte, indexHeaderLen)
+	if _, err := t.index.ReadAt(header, 0); err != nil {
+		return err
+	}
+	t.snappy = binary.BigEndian.Uint64(header)&freezerFlagSnappy != 0
+	t.tail = binary.BigEndian.Uint64(header[8:])
+	t.base = binary.BigEndian.Uint64(header[16:])
+
+	psize, err := t.data.Size()
+	if err != nil {
+		return err
+	}
+	if psize < dataHeaderLen {
+		return errors.NewErrCorrupted(t.dataFd, errors.New("leveldb: short ancient data header"))
+	}
+	if _, err := t.data.ReadAt(header[:dataHeaderLen], 0); err != nil {
+		return err
+	}
+	t.start = binary.BigEndian.Uint64(header)
+	dsize := t.start + uint64(psize-dataHeaderLen)
+	if t.start > t.base || dsize < t.base {
+		return errors.NewErrCorrupted(t.dataFd, errors.New("leveldb: ancient data doesn't hold its base offset"))
+	}
+	// Drop the items whose data didn't make it to disk.
+	t.items = uint64(isize-indexHeaderLen) / indexEntryLen
+	t.size = t.base
+	for t.items > 0 {
+		end, err := t.offset(t.items - 1)
+		if err != nil {
+			return err
+		}
+		if end <= dsize {
+			t.size = end
+			break
+		}
+		t.items--
+	}
+	if t.readOnly {
+		return nil
+	}
+	if want := indexHeaderLen + int64(t.items)*indexEntryLen; isize != want {
+		if err := t.index.Truncate(want); err != nil {
+			return err
+		}
+	}
+	if dsize != t.size {
+		if err := t.data.Truncate(t.phys(t.size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *freezerTable) indexHeader(tail, base uint64) []byte {
+	header := make([]byte, indexHeaderLen)
+	if t.snappy {
+		binary.BigEndian.PutUint64(header, freezerFlagSnappy)
+	}
+	binary.BigEndian.PutUint64(header[8:], tail)
+	binary.BigEndian.PutUint64(header[16:], base)
+	return header
+}
+
+func (t *freezerTable) writeHeader() error {
+	if err := t.index.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := t.index.Write(t.indexHeader(t.tail, t.base)); err != nil {
+		return err
+	}
+	return t.index.Sync()
+}
+
+func (t *freezerTable) writeDataHeader() error {
+	if err := t.data.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := t.data.Write(binary.BigEndian.AppendUint64(nil, t.start)); err != nil {
+		return err
+	}
+	return t.data.Sync()
+}
+
+// head returns the number of the item past the last one.
+func (t *freezerTable) head() uint64 {
+	return t.tail + t.items
+}
+
+// offset returns the data offset past the i-th item of the table.
+func (t *freezerTable) offset(i uint64) (uint64, error) {
+	var buf [indexEntryLen]byte
+	if _, err := t.index.ReadAt(buf[:], indexHeaderLen+int64(i)*indexEntryLen); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+// offsets returns the data offsets of items [i, i+n) of the table, plus the
+// offset past the last one.
+func (t *freezerTable) offsets(i, n uint64) ([]uint64, error) {
+	var (
+		offs = make([]uint64, 0, n+1)
+		pos  = indexHeaderLen + int64(i)*indexEntryLen
+	)
+	if i == 0 {
+		offs = append(offs, t.base)
+	} else {
+		pos -= indexEntryLen
+		n++
+	}
+	buf := make([]byte, n*indexEntryLen)
+	if _, err := t.index.ReadAt(buf, pos); err != nil {
+		return nil, err
+	}
+	for ; len(buf) > 0; buf = buf[indexEntryLen:] {
+		offs = append(offs, binary.BigEndian.Uint64(buf))
+	}
+	return offs, nil
+}
+
+// retrieve returns at most count items starting at number start, stopping
+// before maxBytes (if not zero) is exceeded, yet returning at least one item.
+func (t *freezerTable) retrieve(start, count, maxBytes uint64) ([][]byte, error) {
+	if start < t.tail || start >= t.head() || count == 0 {
+		return nil, errAncientOutOfBounds
+	}
+	if n := t.head() - start; count > n {
+		count = n
+	}
+	offs, err := t.offsets(start-t.tail, count)
+	if err != nil {
+		return nil, err
+	}
+	for n := uint64(1); n < count; n++ {
+		if maxBytes != 0 && offs[n+1]-offs[0] > maxBytes {
+			count = n
+			break
+		}
+	}
+	buf := make([]byte, offs[count]-offs[0])
+	if _, err := t.data.ReadAt(buf, t.phys(offs[0])); err != nil {
+		return nil, err
+	}
+	items := make([][]byte, count)
+	for i := range items {
+		item := buf[offs[i]-offs[0] : offs[i+1]-offs[0]]
+		if t.snappy {
+			if item, err = snappy.Decode(nil, item); err != nil {
+				return nil, errors.NewErrCorrupted(t.dataFd, err)
+			}
+		}
+		items[i] = item
+	}
+	return items, nil
+}
+
+// dataSize returns the bytes of the table on disk.
+func (t *freezerTable) dataSize() uint64 {
+	if t.index == nil {
+		return 0
+	}
+	return uint64(t.phys(t.size)) + indexHeaderLen + t.items*indexEntryLen
+}
+
+// append adds an item to the pending writes of the table, committed
+// by commit.
+func (t *freezerTable) append(number uint64, item []byte) error {
+	if t.readOnly {
+		return ErrReadOnly
+	}
+	if number != t.head()+t.pendingItems {
+		return errAncientOrder
+	}
+	if t.snappy {
+		item = snappy.Encode(nil, item)
+	}
+	t.dataBuf = append(t.dataBuf, item...)
+	t.indexBuf = binary.BigEndian.AppendUint64(t.indexBuf, t.size+uint64(len(t.dataBuf)))
+	t.pendingItems++
+	return nil
+}
+
+// commit writes the pending items, data first so that a crash in between
+// leaves no index entry pointing past the data. It returns the number of
+// bytes written.
+func (t *freezerTable) commit() (int64, error) {
+	if t.pendingItems == 0 {
+		return 0, nil
+	}
+	defer t.rollback()
+	if t.index == nil {
+		if err := t.create(t.snappy); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := t.data.Write(t.dataBuf); err != nil {
+		t.data.Truncate(t.phys(t.size))
+		return 0, err
+	}
+	if _, err := t.index.Write(t.indexBuf); err != nil {
+		t.index.Truncate(indexHeaderLen + int64(t.items)*indexEntryLen)
+		t.data.Truncate(t.phys(t.size))
+		return 0, err
+	}
+	n := int64(len(t.dataBuf) + len(t.indexBuf))
+	t.size += uint64(len(t.dataBuf))
+	t.items += t.pendingItems
+	return n, nil
+}
+
+// rollback discards the pending items.
+func (t *freezerTable) rollback() {
+	t.dataBuf = t.dataBuf[:0]
+	t.indexBuf = t.indexBuf[:0]
+	t.pendingItems = 0
+}
+
+// truncateHead drops the items from number head onwards, the index first so
+// that a crash in between only leaves unindexed data, dropped by repair.
+func (t *freezerTable) truncateHead(head uint64) error {
+	if head >= t.head() {
+		return nil
+	}
+	if head < t.tail {
+		head = t.tail
+	}
+	items := head - t.tail
+	size := t.base
+	if items > 0 {
+		var err error
+		if size, err = t.offset(items - 1); err != nil {
+			return err
+		}
+	}
+	if err := t.index.Truncate(indexHeaderLen + int64(items)*indexEntryLen); err != nil {
+		return err
+	}
+	if err := t.data.Truncate(t.phys(size)); err != nil {
+		return err
+	}
+	t.items, t.size = items, size
+	return nil
+}
+
+// truncateTail drops the items before number tail. The index is rewritten
+// with the new tail, and the data file once the dropped data outweighs the
+// remaining one. Each file is replaced by renaming its rewritten copy over
+// it, so that a crash leaves either the old or the new file, both valid.
+func (t *freezerTable) truncateTail(tail uint64) error {
+	if tail <= t.tail {
+		return nil
+	}
+	if tail > t.head() {
+		return errAncientTruncate
+	}
+	n := tail - t.tail
+	base, err := t.offset(n - 1)
+	if err != nil {
+		return err
+	}
+	entries := make([]byte, (t.items-n)*indexEntryLen)
+	if _, err := t.index.ReadAt(entries, indexHeaderLen+int64(n)*indexEntryLen); err != nil {
+		return err
+	}
+	if err := t.replace(&t.index, t.indexFd, func(w storage.Appender) error {
+		if _, err := w.Write(t.indexHeader(tail, base)); err != nil {
+			return err
+		}
+		_, err := w.Write(entries)
+		return err
+	}); err != nil {
+		return err
+	}
+	t.tail, t.base, t.items = tail, base, t.items-n
+
+	if t.base-t.start >= t.size-t.base {
+		return t.compactData()
+	}
+	return nil
+}
+
+// compactData cuts the data before the base offset from the data file.
+func (t *freezerTable) compactData() error {
+	err := t.replace(&t.data, t.dataFd, func(w storage.Appender) error {
+		if _, err := w.Write(binary.BigEndian.AppendUint64(nil, t.base)); err != nil {
+			return err
+		}
+		buf := make([]byte, freezerCopyChunk)
+		for off := t.base; off < t.size; {
+			n := t.size - off
+			if n > freezerCopyChunk {
+				n = freezerCopyChunk
+			}
+			if _, err := t.data.ReadAt(buf[:n], t.phys(off)); err != nil {
+				return err
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			off += n
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	t.start = t.base
+	return nil
+}
+
+// replace writes a new version of the file fd with fn, and renames it over
+// fd, *f is reopened afterwards.
+func (t *freezerTable) replace(f *storage.Appender, fd storage.FileDesc, fn func(w storage.Appender) error) error {
+	tmp := tempFd(fd)
+	w, err := t.stor.Append(tmp)
+	if err != nil {
+		return err
+	}
+	if err = w.Truncate(0); err == nil {
+		if err = fn(w); err == nil {
+			err = w.Sync()
+		}
+	}
+	w.Close()
+	if err != nil {
+		t.stor.Remove(tmp)
+		return err
+	}
+	(*f).Close()
+	if err := t.stor.Rename(tmp, fd); err != nil {
+		*f, _ = t.stor.Append(fd)
+		return err
+	}
+	*f, err = t.stor.Append(fd)
+	return err
+}
+
+func (t *freezerTable) sync() error {
+	if t.readOnly || t.index == nil {
+		return nil
+	}
+	if err := t.data.Sync(); err != nil {
+		return err
+	}
+	return t.index.Sync()
+}
+
+func (t *freezerTable) close() {
+	if t.data != nil {
+		t.data.Close()
+		t.data = nil
+	}
+	if t.index != nil {
+		t.index.Close()
+		t.index = nil
+	}
+}
@@ -0,0 +1,501 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+	"awesomeProject1/goleveldb/leveldb/util"
+)
+
+func ancientItem(kind string, number uint64) []byte {
+	return bytes.Repeat([]byte(fmt.Sprintf("%s-%d;", kind, number)), int(number%7)+1)
+}
+
+// appendAncients appends items [from, to) of every kind.
+func appendAncients(db *DB, from, to uint64) (int64, error) {
+	return db.ModifyAncients(func(op ethdb.AncientWriteOp) error {
+		for number := from; number < to; number++ {
+			for _, kind := range freezerKinds {
+				if err := op.AppendRaw(kind, number, ancientItem(kind, number)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func checkAncients(t *testing.T, db *DB, from, to uint64) {
+	t.Helper()
+	if n, err := db.Ancients(); err != nil || n != to {
+		t.Fatalf("Ancients: want %d, got %d (%v)", to, n, err)
+	}
+	for _, kind := range freezerKinds {
+		for number := from; number < to; number++ {
+			item, err := db.Ancient(kind, number)
+			if err != nil {
+				t.Fatalf("Ancient(%s, %d): got error: %v", kind, number, err)
+			}
+			if want := ancientItem(kind, number); !bytes.Equal(item, want) {
+				t.Fatalf("Ancient(%s, %d): want %q, got %q", kind, number, want, item)
+			}
+		}
+		if ok, _ := db.HasAncient(kind, to); ok {
+			t.Fatalf("HasAncient(%s, %d): want false", kind, to)
+		}
+	}
+}
+
+func TestDB_Freezer(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	if n, err := h.db.Ancients(); err != nil || n != 0 {
+		t.Fatalf("Ancients: want 0, got %d (%v)", n, err)
+	}
+	if _, err := h.db.Ancient("headers", 0); err != errAncientOutOfBounds {
+		t.Fatalf("Ancient: want errAncientOutOfBounds, got %v", err)
+	}
+	if _, err := h.db.Ancient("blocks", 0); err != errAncientKind {
+		t.Fatalf("Ancient: want errAncientKind, got %v", err)
+	}
+
+	if _, err := appendAncients(h.db, 0, 50); err != nil {
+		t.Fatal("ModifyAncients: got error: ", err)
+	}
+	if _, err := appendAncients(h.db, 50, 100); err != nil {
+		t.Fatal("ModifyAncients: got error: ", err)
+	}
+	checkAncients(t, h.db, 0, 100)
+
+	h.reopenDB()
+	checkAncients(t, h.db, 0, 100)
+	if size, err := h.db.AncientSize("bodies"); err != nil || size == 0 {
+		t.Fatalf("AncientSize: want some bytes, got %d (%v)", size, err)
+	}
+
+	// Ranges are cut at maxBytes, but never empty.
+	items, err := h.db.AncientRange("hashes", 90, 20, 0)
+	if err != nil || len(items) != 10 {
+		t.Fatalf("AncientRange: want 10 items, got %d (%v)", len(items), err)
+	}
+	items, err = h.db.AncientRange("hashes", 10, 5, 1)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("AncientRange: want 1 item, got %d (%v)", len(items), err)
+	}
+	items, err = h.db.AncientRange("hashes", 10, 5, uint64(len(ancientItem("hashes", 10))+len(ancientItem("hashes", 11))))
+	if err != nil || len(items) != 2 {
+		t.Fatalf("AncientRange: want 2 items, got %d (%v)", len(items), err)
+	}
+
+	// Failed or uneven modifications leave nothing behind.
+	if _, err := appendAncients(h.db, 101, 102); err != errAncientOrder {
+		t.Fatalf("ModifyAncients: want errAncientOrder, got %v", err)
+	}
+	_, err = h.db.ModifyAncients(func(op ethdb.AncientWriteOp) error {
+		return op.AppendRaw("headers", 100, []byte("x"))
+	})
+	if err != errAncientIncomplete {
+		t.Fatalf("ModifyAncients: want errAncientIncomplete, got %v", err)
+	}
+	if _, err := appendAncients(h.db, 100, 101); err != nil {
+		t.Fatal("ModifyAncients: got error: ", err)
+	}
+	checkAncients(t, h.db, 0, 101)
+}
+
+func TestDB_FreezerRepair(t *testing.T) {
+	stor := storage.NewMemStorage()
+	f, err := openFreezer(stor, nil)
+	if err != nil {
+		t.Fatal("openFreezer: got error: ", err)
+	}
+	for number := uint64(0); number < 10; number++ {
+		for _, kind := range freezerKinds {
+			f.tables[kind].append(number, ancientItem(kind, number))
+		}
+	}
+	if _, err := f.modify(func(ethdb.AncientWriteOp) error { return nil }); err != nil {
+		t.Fatal("modify: got error: ", err)
+	}
+
+	// An interrupted modification: data without index in one table, and
+	// a partial index entry in another.
+	f.tables["bodies"].data.Write([]byte("garbage"))
+	f.tables["headers"].append(10, ancientItem("headers", 10))
+	f.tables["headers"].commit()
+	f.tables["receipts"].index.Write([]byte{1, 2, 3})
+	f.close()
+
+	f, err = openFreezer(stor, nil)
+	if err != nil {
+		t.Fatal("openFreezer: got error: ", err)
+	}
+	defer f.close()
+	if f.frozen != 10 {
+		t.Fatalf("frozen: want 10, got %d", f.frozen)
+	}
+	for _, kind := range freezerKinds {
+		if head := f.tables[kind].head(); head != 10 {
+			t.Errorf("%s head: want 10, got %d", kind, head)
+		}
+		items, err := f.ancientRange(kind, 0, 10, 0)
+		if err != nil || len(items) != 10 || !bytes.Equal(items[9], ancientItem(kind, 9)) {
+			t.Errorf("%s: invalid items after repair (%v)", kind, err)
+		}
+	}
+}
+
+func TestDB_FreezerCompression(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		AncientCompression: map[string]opt.Compression{"hashes": opt.SnappyCompression},
+	})
+	defer h.close()
+
+	if _, err := appendAncients(h.db, 0, 20); err != nil {
+		t.Fatal("ModifyAncients: got error: ", err)
+	}
+	if !h.db.frz.tables["hashes"].snappy || h.db.frz.tables["diffs"].snappy {
+		t.Fatal("invalid compression of the ancient kinds")
+	}
+
+	// The compression of existing kinds is kept.
+	h.o = &opt.Options{}
+	h.reopenDB()
+	if !h.db.frz.tables["hashes"].snappy {
+		t.Fatal("hashes: compression was not kept")
+	}
+	checkAncients(t, h.db, 0, 20)
+}
+
+func TestDB_FreezerLazyTables(t *testing.T) {
+	stor := storage.NewMemStorage()
+	countFiles := func() int {
+		fds, err := stor.List(storage.TypeAncient | storage.TypeAncientIndex)
+		if err != nil {
+			t.Fatal("List: got error: ", err)
+		}
+		return len(fds)
+	}
+
+	f, err := openFreezer(stor, nil)
+	if err != nil {
+		t.Fatal("openFreezer: got error: ", err)
+	}
+	if n := countFiles(); n != 0 {
+		t.Fatalf("fresh freezer: want no files, got %d", n)
+	}
+	if f.ancients() != 0 || f.tailNumber() != 0 {
+		t.Fatalf("fresh freezer: want empty, got [%d, %d)", f.tailNumber(), f.ancients())
+	}
+	if _, err := f.truncateHead(0); err != nil {
+		t.Fatal("truncateHead: got error: ", err)
+	}
+	if err := f.sync(); err != nil {
+		t.Fatal("sync: got error: ", err)
+	}
+	f.tables["headers"].append(0, ancientItem("headers", 0))
+	if _, err := f.modify(func(ethdb.AncientWriteOp) error { return nil }); err != errAncientIncomplete {
+		t.Fatalf("modify: want errAncientIncomplete, got %v", err)
+	}
+	f.close()
+
+	f, err = openFreezer(stor, nil)
+	if err != nil {
+		t.Fatal("openFreezer: got error: ", err)
+	}
+	if n := countFiles(); n != 0 {
+		t.Fatalf("reopened freezer: want no files, got %d", n)
+	}
+	for _, kind := range freezerKinds {
+		f.tables[kind].append(0, ancientItem(kind, 0))
+	}
+	if _, err := f.modify(func(ethdb.AncientWriteOp) error { return nil }); err != nil {
+		t.Fatal("modify: got error: ", err)
+	}
+	f.close()
+	if n := countFiles(); n != 2*len(freezerKinds) {
+		t.Fatalf("want %d files after the first append, got %d", 2*len(freezerKinds), n)
+	}
+
+	f, err = openFreezer(stor, nil)
+	if err != nil {
+		t.Fatal("openFreezer: got error: ", err)
+	}
+	defer f.close()
+	if f.ancients() != 1 {
+		t.Fatalf("frozen: want 1, got %d", f.ancients())
+	}
+}
+
+// putChainBlock writes block number of the given hash byte in the geth rawdb
+// schema, as canonical if canon is set.
+func putChainBlock(b *Batch, number uint64, h byte, canon bool) []byte {
+	hash := bytes.Repeat([]byte{h}, 32)
+	b.Put(chainKey(headerPrefix, number, hash), []byte(fmt.Sprintf("header-%d-%d", number, h)))
+	b.Put(chainKey(headerPrefix, number, hash, headerTDSuffix), []byte(fmt.Sprintf("td-%d-%d", number, h)))
+	b.Put(chainKey(blockBodyPrefix, number, hash), []byte(fmt.Sprintf("body-%d-%d", number, h)))
+	b.Put(chainKey(blockReceiptPrefix, number, hash), []byte(fmt.Sprintf("receipts-%d-%d", number, h)))
+	b.Put(append(append([]byte(nil), headerNumberPrefix...), hash...), chainKey(nil, number))
+	if canon {
+		b.Put(chainKey(headerPrefix, number, headerHashSuffix), hash)
+		b.Put(headBlockKey, hash)
+	}
+	return hash
+}
+
+// newFreezeChainHarness returns a harness whose DB moves the blocks older
+// than distance to the freezer only when the test calls freezeChain, as no
+// freezeLoop is started.
+func newFreezeChainHarness(t *testing.T, distance int) *dbHarness {
+	h := newDbHarnessWopt(t, &opt.Options{})
+	h.db.s.o.AncientDistance = distance
+	return h
+}
+
+func TestDB_FreezeChain(t *testing.T) {
+	h := newFreezeChainHarness(t, 10)
+	defer h.close()
+
+	b := new(Batch)
+	for number := uint64(0); number < 30; number++ {
+		putChainBlock(b, number, byte(number), true)
+	}
+	side := putChainBlock(b, 5, 0xff, false)
+	h.write(b)
+
+	if n, err := h.db.freezeChain(); err != nil || n != 20 {
+		t.Fatalf("freezeChain: want 20 blocks, got %d (%v)", n, err)
+	}
+	if n, err := h.db.freezeChain(); err != nil || n != 0 {
+		t.Fatalf("freezeChain: want 0 blocks, got %d (%v)", n, err)
+	}
+	if n, _ := h.db.Ancients(); n != 20 {
+		t.Fatalf("Ancients: want 20, got %d", n)
+	}
+	for number := uint64(0); number < 20; number++ {
+		hash := bytes.Repeat([]byte{byte(number)}, 32)
+		if item, _ := h.db.Ancient("hashes", number); !bytes.Equal(item, hash) {
+			t.Fatalf("Ancient(hashes, %d): got %x", number, item)
+		}
+		if item, _ := h.db.Ancient("bodies", number); string(item) != fmt.Sprintf("body-%d-%d", number, number) {
+			t.Fatalf("Ancient(bodies, %d): got %q", number, item)
+		}
+		_, err := h.db.Get(chainKey(headerPrefix, number, hash), nil)
+		if number == 0 && err != nil {
+			t.Fatalf("genesis header: got error: %v", err)
+		} else if number > 0 && err != ErrNotFound {
+			t.Fatalf("header #%d: want ErrNotFound, got %v", number, err)
+		}
+	}
+	if _, err := h.db.Get(chainKey(blockBodyPrefix, 5, side), nil); err != ErrNotFound {
+		t.Fatalf("side chain body: want ErrNotFound, got %v", err)
+	}
+	h.getVal(string(headerNumberPrefix)+string(side), string(chainKey(nil, 5)))
+	h.getVal(string(chainKey(blockBodyPrefix, 20, bytes.Repeat([]byte{20}, 32))), "body-20-20")
+
+	// The run stops at a block not fully written.
+	b.Reset()
+	for number := uint64(30); number < 35; number++ {
+		putChainBlock(b, number, byte(number), true)
+	}
+	b.Delete(chainKey(blockReceiptPrefix, 22, bytes.Repeat([]byte{22}, 32)))
+	h.write(b)
+	if n, err := h.db.freezeChain(); err != nil || n != 2 {
+		t.Fatalf("freezeChain: want 2 blocks, got %d (%v)", n, err)
+	}
+	h.reopenDB()
+	if n, _ := h.db.Ancients(); n != 22 {
+		t.Fatalf("Ancients: want 22, got %d", n)
+	}
+
+	// The migration is off by default.
+	b.Reset()
+	b.Put(chainKey(blockReceiptPrefix, 22, bytes.Repeat([]byte{22}, 32)), []byte("receipts-22-22"))
+	h.write(b)
+	if n, err := h.db.freezeChain(); err != nil || n != 0 {
+		t.Fatalf("freezeChain: want 0 blocks, got %d (%v)", n, err)
+	}
+}
+
+func TestDB_FreezeChain_s(t *testing.T) {
+	h := newFreezeChainHarness(t, 10)
+	defer h.close()
+
+	b := new(Batch)
+	for number := uint64(0); number < 30; number++ {
+		putChainBlock(b, number, byte(number), true)
+	}
+	h.write(b)
+	// Bodies and receipts go to the secondary tree.
+	for _, prefix := range [][]byte{blockBodyPrefix, blockReceiptPrefix} {
+		if err := h.db.MigrateRange(*util.BytesPrefix(prefix), PrimaryTree, SecondaryTree); err != nil {
+			t.Fatal("MigrateRange: got error: ", err)
+		}
+	}
+
+	if n, err := h.db.freezeChain(); err != nil || n != 20 {
+		t.Fatalf("freezeChain: want 20 blocks, got %d (%v)", n, err)
+	}
+	for number := uint64(1); number < 20; number++ {
+		hash := bytes.Repeat([]byte{byte(number)}, 32)
+		if item, _ := h.db.Ancient("receipts", number); string(item) != fmt.Sprintf("receipts-%d-%d", number, number) {
+			t.Fatalf("Ancient(receipts, %d): got %q", number, item)
+		}
+		h.get_s(string(chainKey(blockBodyPrefix, number, hash)), false)
+		h.get_s(string(chainKey(blockReceiptPrefix, number, hash)), false)
+	}
+	h.getVal_s(string(chainKey(blockBodyPrefix, 20, bytes.Repeat([]byte{20}, 32))), "body-20-20")
+}
+
+func TestDB_FreezerLeftovers(t *testing.T) {
+	h := newFreezeChainHarness(t, 10)
+	defer h.close()
+
+	b := new(Batch)
+	for number := uint64(0); number < 30; number++ {
+		putChainBlock(b, number, byte(number), true)
+	}
+	h.write(b)
+
+	// Blocks 0 to 19 reach the freezer, and the run is interrupted while
+	// deleting them from the LSM.
+	_, err := h.db.frz.modify(func(op ethdb.AncientWriteOp) error {
+		for number := uint64(0); number < 20; number++ {
+			if err := h.db.freezeBlock(op, number); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("ModifyAncients: got error: ", err)
+	}
+	if err := h.db.deleteFrozen(0, 8); err != nil {
+		t.Fatal("deleteFrozen: got error: ", err)
+	}
+
+	if err := h.db.deleteFrozenLeftovers(); err != nil {
+		t.Fatal("deleteFrozenLeftovers: got error: ", err)
+	}
+	for number := uint64(0); number < 30; number++ {
+		hash := bytes.Repeat([]byte{byte(number)}, 32)
+		_, err := h.db.Get(chainKey(blockBodyPrefix, number, hash), nil)
+		if (number == 0 || number >= 20) && err != nil {
+			t.Fatalf("body #%d: got error: %v", number, err)
+		} else if number > 0 && number < 20 && err != ErrNotFound {
+			t.Fatalf("body #%d: want ErrNotFound, got %v", number, err)
+		}
+	}
+	if n, err := h.db.freezeChain(); err != nil || n != 0 {
+		t.Fatalf("freezeChain: want 0 blocks, got %d (%v)", n, err)
+	}
+}
+
+func TestDB_FreezerTruncate(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	if _, err := appendAncients(h.db, 0, 100); err != nil {
+		t.Fatal("ModifyAncients: got error: ", err)
+	}
+
+	// Roll back a reorg and append the new blocks.
+	if old, err := h.db.TruncateHead(80); err != nil || old != 100 {
+		t.Fatalf("TruncateHead: want 100, got %d (%v)", old, err)
+	}
+	if _, err := appendAncients(h.db, 80, 90); err != nil {
+		t.Fatal("ModifyAncients: got error: ", err)
+	}
+	checkAncients(t, h.db, 0, 90)
+
+	if old, err := h.db.TruncateTail(30); err != nil || old != 0 {
+		t.Fatalf("TruncateTail: want 0, got %d (%v)", old, err)
+	}
+	if tail, _ := h.db.Tail(); tail != 30 {
+		t.Fatalf("Tail: want 30, got %d", tail)
+	}
+	if _, err := h.db.Ancient("headers", 29); err != errAncientOutOfBounds {
+		t.Fatalf("Ancient: want errAncientOutOfBounds, got %v", err)
+	}
+	if ok, _ := h.db.HasAncient("headers", 29); ok {
+		t.Fatal("HasAncient: want false below the tail")
+	}
+	if _, err := h.db.TruncateHead(20); err != errAncientTruncate {
+		t.Fatalf("TruncateHead: want errAncientTruncate, got %v", err)
+	}
+	if _, err := h.db.TruncateTail(91); err != errAncientTruncate {
+		t.Fatalf("TruncateTail: want errAncientTruncate, got %v", err)
+	}
+
+	h.reopenDB()
+	if tail, _ := h.db.Tail(); tail != 30 {
+		t.Fatalf("Tail: want 30, got %d", tail)
+	}
+	checkAncients(t, h.db, 30, 90)
+
+	// Dropping most of the items gives the space back.
+	size, _ := h.db.AncientSize("bodies")
+	if _, err := h.db.TruncateTail(80); err != nil {
+		t.Fatal("TruncateTail: got error: ", err)
+	}
+	if newSize, _ := h.db.AncientSize("bodies"); newSize >= size/2 {
+		t.Fatalf("AncientSize: want less than %d, got %d", size/2, newSize)
+	}
+	if _, err := appendAncients(h.db, 90, 95); err != nil {
+		t.Fatal("ModifyAncients: got error: ", err)
+	}
+	h.reopenDB()
+	checkAncients(t, h.db, 80, 95)
+}
+
+func TestDB_FreezerTruncateTailRepair(t *testing.T) {
+	stor := storage.NewMemStorage()
+	f, err := openFreezer(stor, nil)
+	if err != nil {
+		t.Fatal("openFreezer: got error: ", err)
+	}
+	for number := uint64(0); number < 10; number++ {
+		for _, kind := range freezerKinds {
+			f.tables[kind].append(number, ancientItem(kind, number))
+		}
+	}
+	if _, err := f.modify(func(ethdb.AncientWriteOp) error { return nil }); err != nil {
+		t.Fatal("modify: got error: ", err)
+	}
+
+	// A TruncateTail interrupted after the first table.
+	if err := f.tables["bodies"].truncateTail(8); err != nil {
+		t.Fatal("truncateTail: got error: ", err)
+	}
+	f.close()
+
+	f, err = openFreezer(stor, nil)
+	if err != nil {
+		t.Fatal("openFreezer: got error: ", err)
+	}
+	defer f.close()
+	if f.tail != 8 || f.frozen != 10 {
+		t.Fatalf("want tail 8 and head 10, got %d and %d", f.tail, f.frozen)
+	}
+	for _, kind := range freezerKinds {
+		if tail := f.tables[kind].tail; tail != 8 {
+			t.Errorf("%s tail: want 8, got %d", kind, tail)
+		}
+		items, err := f.ancientRange(kind, 8, 2, 0)
+		if err != nil || len(items) != 2 || !bytes.Equal(items[1], ancientItem(kind, 9)) {
+			t.Errorf("%s: invalid items after repair (%v)", kind, err)
+		}
+	}
+}
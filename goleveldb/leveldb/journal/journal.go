@@ -143,6 +143,8 @@ type Reader struct {
 	// n is the number of bytes of buf that are valid. Once reading has started,
 	// only the final block can have n < blockSize.
 	n int //表示buf的size
+	// off is the offset of buf within the underlying reader.
+	off int64
 	// last is whether the current chunk is the last chunk of the journal.
 	last bool
 	// err is any accumulated error.
@@ -242,6 +244,7 @@ func (r *Reader) nextChunk(first bool) error {
 			r.err = io.EOF
 			return r.err
 		}
+		r.off += int64(r.n)
 		r.i, r.j, r.n = 0, 0, n
 	}
 }
@@ -278,11 +281,19 @@ func (r *Reader) Reset(reader io.Reader, dropper Dropper, strict, checksum bool)
 	r.i = 0
 	r.j = 0
 	r.n = 0
+	r.off = 0
 	r.last = true
 	r.err = nil
 	return err
 }
 
+// Offset returns the offset within the underlying reader of the end of the
+// last chunk read, that is the end of the last journal once it has been
+// fully read.
+func (r *Reader) Offset() int64 {
+	return r.off + int64(r.j)
+}
+
 // //读日志记录，以32KB的BLOCK为单位，一直到Last类型，表明日志记录读取完毕，获取日志Reader
 // 获取singlReader，读取一个chunk并校验，是否是最后一个chunk
 type singleReader struct {
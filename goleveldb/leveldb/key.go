@@ -36,6 +36,12 @@ func (kt keyType) String() string {
 		return "d"
 	case keyTypeVal:
 		return "v"
+	case keyTypeRangeDel:
+		return "r"
+	case keyTypeBlob:
+		return "b"
+	case keyTypeMerge:
+		return "m"
 	}
 	return fmt.Sprintf("<invalid:%#x>", uint(kt))
 }
@@ -47,12 +53,43 @@ const (
 	keyTypeVal = keyType(1) //插入？
 )
 
+// keyTypeRangeDel is the batch and journal record type of a range deletion,
+// its key is the start of the range and its value the limit. It never ends
+// up in an internal key, range tombstones are keyed by their start with
+// keyTypeDel.
+const keyTypeRangeDel = keyType(2)
+
+// keyTypeBlob is the type of the table entries of the secondary tree whose
+// value was moved to a blob file, their value is an encoded blobPointer. It
+// only ends up in internal keys written by table writers, never in batches
+// or memdbs.
+const keyTypeBlob = keyType(3)
+
+// keyTypeMerge is the type of the merge operands of the primary tree, their
+// value is folded into the older value of the key by the MergeOperator.
+const keyTypeMerge = keyType(4)
+
+// validInternal reports whether the type may end up in an internal key.
+func (kt keyType) validInternal() bool {
+	return kt == keyTypeDel || kt == keyTypeVal || kt == keyTypeBlob || kt == keyTypeMerge
+}
+
+// validBatch reports whether the type may be the type of a batch record.
+func (kt keyType) validBatch() bool {
+	return kt == keyTypeDel || kt == keyTypeVal || kt == keyTypeRangeDel || kt == keyTypeMerge
+}
+
+// hasValue reports whether batch records of the type carry a value.
+func (kt keyType) hasValue() bool {
+	return kt == keyTypeVal || kt == keyTypeRangeDel || kt == keyTypeMerge
+}
+
 // keyTypeSeek defines the keyType that should be passed when constructing an
 // internal key for seeking to a particular sequence number (since we
 // sort sequence numbers in decreasing order and the value type is
 // embedded as the low 8 bits in the sequence number in internal keys,
 // we need to use the highest-numbered ValueType, not the lowest).
-const keyTypeSeek = keyTypeVal
+const keyTypeSeek = keyTypeMerge
 
 const (
 	// Maximum value possible for sequence number; the 8-bits are
@@ -76,7 +113,7 @@ type internalKey []byte
 func makeInternalKey(dst, ukey []byte, seq uint64, kt keyType) internalKey {
 	if seq > keyMaxSeq {
 		panic("leveldb: invalid sequence number")
-	} else if kt > keyTypeVal {
+	} else if !kt.validInternal() {
 		panic("leveldb: invalid type")
 	}
 
@@ -94,7 +131,7 @@ func parseInternalKey(ik []byte) (ukey []byte, seq uint64, kt keyType, err error
 	num := binary.LittleEndian.Uint64(ik[len(ik)-8:])
 	//获取seq N和type
 	seq, kt = uint64(num>>8), keyType(num&0xff)
-	if kt > keyTypeVal {
+	if !kt.validInternal() {
 		return nil, 0, 0, newErrInternalKeyCorrupted(ik, "invalid type")
 	}
 	ukey = ik[:len(ik)-8]
@@ -128,7 +165,7 @@ func (ik internalKey) num() uint64 {
 func (ik internalKey) parseNum() (seq uint64, kt keyType) {
 	num := ik.num()
 	seq, kt = uint64(num>>8), keyType(num&0xff)
-	if kt > keyTypeVal {
+	if !kt.validInternal() {
 		panic(fmt.Sprintf("leveldb: internal key %q, len=%d: invalid type %#x", []byte(ik), len(ik), kt))
 	}
 	return
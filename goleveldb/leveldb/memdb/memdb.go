@@ -81,7 +81,7 @@ func (i *dbIter) Valid() bool {
 
 func (i *dbIter) First() bool {
 	if i.p == nil {
-		return i.First()
+		return i.First_s()
 	} else {
 		if i.Released() {
 			i.err = ErrIterReleased
@@ -230,22 +230,26 @@ func (i *dbIter) Next_s() bool {
 }
 
 func (i *dbIter) Prev() bool {
-	if i.Released() {
-		i.err = ErrIterReleased
-		return false
-	}
+	if i.p == nil {
+		return i.Prev_s()
+	} else {
+		if i.Released() {
+			i.err = ErrIterReleased
+			return false
+		}
 
-	if i.node == 0 {
-		if i.forward {
-			return i.Last()
+		if i.node == 0 {
+			if i.forward {
+				return i.Last()
+			}
+			return false
 		}
-		return false
+		i.forward = false
+		i.p.mu.RLock()
+		defer i.p.mu.RUnlock()
+		i.node = i.p.findLT(i.key)
+		return i.fill(true, false)
 	}
-	i.forward = false
-	i.p.mu.RLock()
-	defer i.p.mu.RUnlock()
-	i.node = i.p.findLT(i.key)
-	return i.fill(true, false)
 }
 func (i *dbIter) Prev_s() bool {
 	if i.Released() {
@@ -279,6 +283,7 @@ func (i *dbIter) Error() error { return i.err }
 func (i *dbIter) Release() {
 	if !i.Released() {
 		i.p = nil
+		i.q = nil
 		i.node = 0
 		i.key = nil
 		i.value = nil
@@ -321,6 +326,9 @@ type DB struct {
 	maxHeight int
 	n         int //kv对的数量
 	kvSize    int //kv对的大小
+
+	// Range tombstones, start key to limit, created on first use.
+	rangeDels *DB
 }
 
 // 写一个结构体继承DB，为is a的关系
@@ -335,6 +343,8 @@ type DBs struct {
 	maxHeight int
 	n         int //kv对的数量
 	kvSize    int //kv对的大小
+
+	rangeDels *DB
 }
 
 // 跳表是否向上一层
@@ -744,46 +754,116 @@ func (p *DBs) Capacity_s() int {
 	return cap(p.kvData)
 }
 
-// Size returns sum of keys and values length. Note that deleted
-// key/value will not be accounted for, but it will still consume
-// the buffer, since the buffer is append only.
+// Size returns sum of keys and values length, range tombstones included.
+// Note that deleted key/value will not be accounted for, but it will still
+// consume the buffer, since the buffer is append only.
 // 返回键和值长度的和。请注意，删除的键/值将不被考虑，但它仍然会消耗缓冲区，因为缓冲区只是追加的。
 func (p *DB) Size() int {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
-	return p.kvSize
+	return p.kvSize + rangeDelSize(p.rangeDels)
 }
 func (p *DBs) Size_s() int {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
-	return p.kvSize
+	return p.kvSize + rangeDelSize(p.rangeDels)
 }
 
-// Free returns keys/values free buffer before need to grow.
+// Free returns keys/values free buffer before need to grow. The range
+// tombstones take from it too, although they are kept apart.
 // 在需要增长之前，返回KV的空闲缓存大小？
 func (p *DB) Free() int {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
-	return cap(p.kvData) - len(p.kvData)
+	return freeSize(cap(p.kvData)-len(p.kvData), p.rangeDels)
 }
 func (q *DBs) Free_s() int {
 	q.mu.RLock()
 	defer q.mu.RUnlock()
-	return cap(q.kvData) - len(q.kvData)
+	return freeSize(cap(q.kvData)-len(q.kvData), q.rangeDels)
+}
+
+// Returns the size of the range tombstones of rangeDels, which may be nil.
+func rangeDelSize(rangeDels *DB) int {
+	if rangeDels == nil {
+		return 0
+	}
+	return rangeDels.Size()
 }
 
-// Len returns the number of entries in the DB.
+// Returns free less the size of the range tombstones, down to zero.
+func freeSize(free int, rangeDels *DB) int {
+	if free -= rangeDelSize(rangeDels); free < 0 {
+		return 0
+	}
+	return free
+}
+
+// Len returns the number of entries in the DB, range tombstones included.
 func (p *DB) Len() int {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
+	if p.rangeDels != nil {
+		return p.n + p.rangeDels.Len()
+	}
 	return p.n
 }
 func (p *DBs) Len_s() int {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
+	if p.rangeDels != nil {
+		return p.n + p.rangeDels.Len()
+	}
 	return p.n
 }
 
+// PutRangeTombstone records a range tombstone starting at the given key
+// and ending before limit. The tombstones are kept apart from the entries,
+// ordered by key, and are not seen by Get, Find or NewIterator.
+//
+// It is safe to modify the contents of the arguments after
+// PutRangeTombstone returns.
+func (p *DB) PutRangeTombstone(key, limit []byte) error {
+	p.mu.Lock()
+	if p.rangeDels == nil {
+		p.rangeDels = New(p.cmp, 0)
+	}
+	rangeDels := p.rangeDels
+	p.mu.Unlock()
+	return rangeDels.Put(key, limit)
+}
+func (p *DBs) PutRangeTombstone_s(key, limit []byte) error {
+	p.mu.Lock()
+	if p.rangeDels == nil {
+		p.rangeDels = New(p.cmp, 0)
+	}
+	rangeDels := p.rangeDels
+	p.mu.Unlock()
+	return rangeDels.Put(key, limit)
+}
+
+// NewRangeTombstoneIterator returns an iterator of the range tombstones of
+// the DB, the keys are the tombstone start keys and the values their
+// limits.
+//
+// The iterator must be released after use, by calling Release method.
+func (p *DB) NewRangeTombstoneIterator() iterator.Iterator {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.rangeDels == nil {
+		return iterator.NewEmptyIterator(nil)
+	}
+	return p.rangeDels.NewIterator(nil)
+}
+func (p *DBs) NewRangeTombstoneIterator_s() iterator.Iterator {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.rangeDels == nil {
+		return iterator.NewEmptyIterator(nil)
+	}
+	return p.rangeDels.NewIterator(nil)
+}
+
 // Reset resets the DB to initial empty state. Allows reuse the buffer.
 func (p *DB) Reset() {
 	p.mu.Lock()
@@ -801,6 +881,7 @@ func (p *DB) Reset() {
 		p.nodeData[nNext+n] = 0
 		p.prevNode[n] = 0
 	}
+	p.rangeDels = nil
 	p.mu.Unlock()
 } //置空
 func (p *DBs) Reset_s() {
@@ -819,6 +900,7 @@ func (p *DBs) Reset_s() {
 		p.nodeData[nNext+n] = 0
 		p.prevNode[n] = 0
 	}
+	p.rangeDels = nil
 	p.mu.Unlock()
 }
 
@@ -0,0 +1,163 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"awesomeProject1/goleveldb/leveldb/errors"
+	"awesomeProject1/goleveldb/leveldb/iterator"
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/util"
+)
+
+// errMergeOperand is returned by the point lookups of the memdbs and the
+// tables when the newest entry of the key is a merge operand, the value is
+// then read with getMerged.
+var errMergeOperand = errors.New("leveldb: merge operand")
+
+// Returns the result of MergeOperator.FullMerge, operands are oldest first.
+func (db *DB) fullMerge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	mo := db.s.o.GetMergeOperator()
+	if mo == nil {
+		return nil, ErrMergeUnsupported
+	}
+	return mo.FullMerge(key, existing, operands)
+}
+
+func reverseOperands(operands [][]byte) {
+	for i, j := 0, len(operands)-1; i < j; i, j = i+1, j-1 {
+		operands[i], operands[j] = operands[j], operands[i]
+	}
+}
+
+// getMerged returns the value of key at seq, whose newest entry is a merge
+// operand. The entries of the key are read from the newest one down to its
+// base value, a put, a deletion or nothing, and the operands are merged into
+// it.
+func (db *DB) getMerged(auxt tFiles, key []byte, seq uint64, ro *opt.ReadOptions) ([]byte, error) {
+	slice := &util.Range{Start: makeInternalKey(nil, key, seq, keyTypeSeek)}
+	iter, rdi := db.newRawIterator(nil, auxt, slice, ro, seq)
+	defer iter.Release()
+
+	var (
+		operands [][]byte // newest first
+		base     []byte
+	)
+	for iter.Next() {
+		ukey, kseq, kt, kerr := parseInternalKey(iter.Key())
+		if kerr != nil {
+			return nil, kerr
+		}
+		if db.s.icmp.uCompare(ukey, key) != 0 || kt == keyTypeDel || rdi.seq(key, seq) > kseq {
+			break
+		}
+		if kt == keyTypeMerge {
+			operands = append(operands, append([]byte(nil), iter.Value()...))
+			continue
+		}
+		base = append([]byte{}, iter.Value()...)
+		break
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	if len(operands) == 0 {
+		if base == nil {
+			return nil, ErrNotFound
+		}
+		return base, nil
+	}
+	reverseOperands(operands)
+	return db.fullMerge(key, base, operands)
+}
+
+// compactionIterator is the input iterator of a table compaction, an entry
+// read ahead by foldMerge is given back with unread.
+type compactionIterator struct {
+	iterator.Iterator
+	unreadNext bool
+}
+
+func (it *compactionIterator) Next() bool {
+	if it.unreadNext {
+		it.unreadNext = false
+		return true
+	}
+	return it.Iterator.Next()
+}
+
+// unread makes the next call to Next stay on the current entry.
+func (it *compactionIterator) unread() {
+	it.unreadNext = true
+}
+
+// foldMerge folds the merge operand of ukey at seq the iterator is at, seen
+// by every snapshot, with the older entries of the key. Down to a base value
+// they become a single put; when the key has no older entry in the compaction
+// but may have some in the deeper levels, the operands the MergeOperator can
+// combine become single operands. The folded entries are appended to the
+// table, and the number of entries consumed past the first one returned.
+func (b *tableCompactionBuilder) foldMerge(iter *compactionIterator, ukey []byte, seq uint64) (n int, err error) {
+	mo := b.s.o.GetMergeOperator()
+	ukey = append([]byte(nil), ukey...)
+	var (
+		operands = [][]byte{append([]byte(nil), iter.Value()...)} // newest first
+		seqs     = []uint64{seq}
+		base     []byte
+		hasBase  bool
+	)
+	for !hasBase && iter.Next() {
+		fukey, fseq, fkt, kerr := parseInternalKey(iter.Key())
+		if kerr != nil || b.s.icmp.uCompare(fukey, ukey) != 0 {
+			iter.unread()
+			break
+		}
+		n++
+		switch {
+		case fkt == keyTypeDel || b.rangeDelIdx.seq(ukey, b.minSeq) > fseq:
+			hasBase = true
+		case fkt == keyTypeMerge:
+			operands = append(operands, append([]byte(nil), iter.Value()...))
+			seqs = append(seqs, fseq)
+		default:
+			base, hasBase = append([]byte{}, iter.Value()...), true
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return n, err
+	}
+	b.dropCnt += n
+	reverseOperands(operands)
+
+	if hasBase || b.c.baseLevelForKey(ukey) {
+		value, err := mo.FullMerge(ukey, base, operands)
+		if err != nil {
+			return n, err
+		}
+		return n, b.appendKV(makeInternalKey(nil, ukey, seq, keyTypeVal), value)
+	}
+
+	// Successive operands are combined oldest first, a combined operand
+	// takes the sequence number of its newest part.
+	last := len(seqs) - 1
+	out, outSeqs := [][]byte{operands[0]}, []uint64{seqs[last]}
+	for j := 1; j < len(operands); j++ {
+		if op, ok := mo.PartialMerge(ukey, out[len(out)-1], operands[j]); ok {
+			out[len(out)-1], outSeqs[len(out)-1] = op, seqs[last-j]
+		} else {
+			out, outSeqs = append(out, operands[j]), append(outSeqs, seqs[last-j])
+		}
+	}
+	b.dropCnt -= len(out) - 1
+	var ikey internalKey
+	for j := len(out) - 1; j >= 0; j-- {
+		ikey = makeInternalKey(ikey, ukey, outSeqs[j], keyTypeMerge)
+		if err := b.appendKV(ikey, out[j]); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package opt
+
+import "time"
+
+// Listener receives the events of the background work of a DB, see
+// Options.Listener. The methods are called synchronously from the goroutine
+// doing the work, possibly concurrently, and must return quickly; they must
+// not call back into the DB.
+//
+// Embed NopListener to implement only some of the methods.
+type Listener interface {
+	// OnFlushBegin is called before a frozen memdb is written to a table.
+	OnFlushBegin(info FlushInfo)
+	// OnFlushEnd is called once the table of a memdb is committed.
+	OnFlushEnd(info FlushInfo)
+
+	// OnCompactionBegin is called before the tables of a compaction are
+	// read; Outputs isn't set yet.
+	OnCompactionBegin(info CompactionInfo)
+	// OnCompactionEnd is called once the output tables of a compaction
+	// are committed.
+	OnCompactionEnd(info CompactionInfo)
+
+	// OnWriteStallBegin is called when a write is delayed or paused
+	// because level-0 holds too many tables.
+	OnWriteStallBegin(info WriteStallInfo)
+	// OnWriteStallEnd is called when the write goes on.
+	OnWriteStallEnd(info WriteStallInfo)
+
+	// OnTableCreated is called when a table file is written, before it is
+	// committed, by a flush, a compaction, a transaction or an ingestion.
+	OnTableCreated(info TableInfo)
+	// OnTableDeleted is called when an obsolete table file is removed.
+	OnTableDeleted(info TableInfo)
+
+	// OnBackgroundError is called when a flush or a compaction fails. It
+	// is retried unless the error is persistent.
+	OnBackgroundError(info BackgroundErrorInfo)
+}
+
+// FlushInfo describes a memdb flush.
+type FlushInfo struct {
+	Tree    Tree
+	Entries int // entries of the memdb
+	Size    int // size of the memdb
+
+	// Set by OnFlushEnd.
+	Level     int   // level the table went to
+	FileNum   int64 // number of the table
+	TableSize int64
+	Duration  time.Duration
+}
+
+// CompactionInfo describes a table compaction.
+type CompactionInfo struct {
+	Tree        Tree
+	Level       int     // level compacted into Level+1
+	Trivial     bool    // the only input table is moved, not rewritten
+	Inputs      []int64 // numbers of the input tables of both levels
+	InputBytes  int64
+	Outputs     []int64 // numbers of the output tables
+	OutputBytes int64
+	Duration    time.Duration
+}
+
+// WriteStallCause tells why a write is stalled.
+type WriteStallCause int
+
+const (
+	WriteStallSlowdown WriteStallCause = iota // level-0 reached WriteL0SlowdownTrigger
+	WriteStallPause                           // level-0 reached WriteL0PauseTrigger
+)
+
+func (c WriteStallCause) String() string {
+	switch c {
+	case WriteStallSlowdown:
+		return "slowdown"
+	case WriteStallPause:
+		return "pause"
+	}
+	return "invalid"
+}
+
+// WriteStallInfo describes a write stall.
+type WriteStallInfo struct {
+	Tree         Tree
+	Cause        WriteStallCause
+	Level0Tables int
+	Duration     time.Duration // set by OnWriteStallEnd
+}
+
+// TableInfo describes a table file. Only FileNum is set for a deleted one.
+type TableInfo struct {
+	Tree    Tree
+	FileNum int64
+	Size    int64
+}
+
+// BackgroundErrorInfo describes the failure of a flush or a compaction.
+type BackgroundErrorInfo struct {
+	Tree Tree
+	Job  string // the failed job, e.g. "memdb@flush" or "table@build"
+	Err  error
+}
+
+// NopListener is a Listener ignoring every event.
+type NopListener struct{}
+
+func (NopListener) OnFlushBegin(FlushInfo)                {}
+func (NopListener) OnFlushEnd(FlushInfo)                  {}
+func (NopListener) OnCompactionBegin(CompactionInfo)      {}
+func (NopListener) OnCompactionEnd(CompactionInfo)        {}
+func (NopListener) OnWriteStallBegin(WriteStallInfo)      {}
+func (NopListener) OnWriteStallEnd(WriteStallInfo)        {}
+func (NopListener) OnTableCreated(TableInfo)              {}
+func (NopListener) OnTableDeleted(TableInfo)              {}
+func (NopListener) OnBackgroundError(BackgroundErrorInfo) {}
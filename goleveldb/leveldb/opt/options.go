@@ -12,6 +12,7 @@ import (
 
 	"awesomeProject1/goleveldb/leveldb/cache"
 	"awesomeProject1/goleveldb/leveldb/comparer"
+	"awesomeProject1/goleveldb/leveldb/compression"
 	"awesomeProject1/goleveldb/leveldb/filter"
 )
 
@@ -49,6 +50,21 @@ var (
 	DefaultWriteL0SlowdownTrigger2 = 8
 )
 
+// DefaultAncientCompression is the compression of each freezer kind when
+// Options.AncientCompression doesn't name it. Hashes and difficulties don't
+// compress well.
+var DefaultAncientCompression = map[string]Compression{
+	"headers":  SnappyCompression,
+	"hashes":   NoCompression,
+	"bodies":   SnappyCompression,
+	"receipts": SnappyCompression,
+	"diffs":    NoCompression,
+}
+
+// DefaultBlobGCRatio is the ratio of dead values that makes a blob file
+// rewritten when Options.BlobGCRatio is zero.
+var DefaultBlobGCRatio = 0.5
+
 // Cacher is a caching algorithm.
 type Cacher interface {
 	New(capacity int) cache.Cacher
@@ -75,6 +91,25 @@ var (
 	NoCacher = &CacherFunc{}
 )
 
+// MergeOperator folds the merge operands of a key into its value, see
+// Options.MergeOperator.
+type MergeOperator interface {
+	// FullMerge returns the value of the key given its existing value, nil
+	// if the key has none or was deleted, and the merge operands written on
+	// top of it, oldest first. The returned value must not refer to the
+	// arguments.
+	//
+	// An error fails the read or the compaction needing the value.
+	FullMerge(key, existing []byte, operands [][]byte) ([]byte, error)
+
+	// PartialMerge combines two successive merge operands of the key, left
+	// being the older one, into a single operand having the same effect. It
+	// returns false if they can't be combined without the existing value,
+	// both operands are kept then. The returned operand must not refer to
+	// the arguments.
+	PartialMerge(key, left, right []byte) ([]byte, bool)
+}
+
 // Compression is the 'sorted table' block compression algorithm to use.
 type Compression uint
 
@@ -97,6 +132,70 @@ const (
 	nCompression                          // 3
 )
 
+// Tree selects one of the two LSM trees of a DB.
+type Tree uint
+
+func (t Tree) String() string {
+	switch t {
+	case PrimaryTree:
+		return "primary"
+	case SecondaryTree:
+		return "secondary"
+	}
+	return "invalid"
+}
+
+const (
+	PrimaryTree   Tree = iota // 0, mem/levels/journal
+	SecondaryTree             // 1, mems/level_s/journal2
+)
+
+// CompactionDecision is what a CompactionFilter does with an entry.
+type CompactionDecision int
+
+const (
+	CompactionKeep   CompactionDecision = iota // keep the entry unchanged
+	CompactionRemove                           // delete the key
+	CompactionChange                           // replace the value
+)
+
+// CompactionFilter is called by table compactions with the value of a live
+// key, the level being compacted and the tree of the key. It decides whether
+// the entry is kept, removed or given the returned value. It may be called
+// concurrently, and must not retain nor modify the key and the value.
+type CompactionFilter func(level int, key, value []byte, tree Tree) (CompactionDecision, []byte)
+
+// RateLimiter configures the token buckets throttling the background I/O
+// of a DB, see Options.RateLimiter.
+type RateLimiter struct {
+	// BytesPerSec is the rate of the bucket of each tree. Zero or less
+	// doesn't limit.
+	BytesPerSec int64
+
+	// Burst is the number of bytes a bucket holds, going through without
+	// delay after an idle time.
+	//
+	// The default value is a tenth of BytesPerSec.
+	Burst int64
+}
+
+func (r *RateLimiter) GetBytesPerSec() int64 {
+	if r == nil {
+		return 0
+	}
+	return r.BytesPerSec
+}
+
+func (r *RateLimiter) GetBurst() int64 {
+	if r == nil {
+		return 0
+	}
+	if r.Burst <= 0 {
+		return r.BytesPerSec / 10
+	}
+	return r.Burst
+}
+
 // Strict is the DB 'strict level'.
 type Strict uint
 
@@ -152,6 +251,38 @@ type Options struct {
 	// The default value is nil
 	AltFilters []filter.Filter
 
+	// AncientCompression defines the compression of the items of each
+	// freezer kind, either NoCompression or SnappyCompression. It only
+	// applies to kinds created afterwards, existing ones keep the
+	// compression they were created with.
+	//
+	// The default value is nil, DefaultAncientCompression is used.
+	AncientCompression map[string]Compression
+
+	// AncientDistance defines the number of blocks below the chain head kept
+	// in the LSM. If positive, the canonical blocks older than that are moved
+	// to the freezer in the background, and deleted from the LSM. Geth keeps
+	// 90000 blocks.
+	//
+	// The default value is 0, the migration is disabled.
+	AncientDistance int
+
+	// BlobGCRatio defines the ratio of dead values, by size, from which a
+	// blob file is rewritten by the blob GC: its live values are written
+	// again and the file is removed once no snapshot can read it.
+	//
+	// The default value is 0.5.
+	BlobGCRatio float64
+
+	// BlobThreshold defines the value length above which the values of the
+	// secondary tree are moved to blob files when written to tables, the
+	// tables then only hold a pointer to the value. This cuts the cost of
+	// compacting large values, at the price of an extra read to get them.
+	// Use zero to keep all the values in the tables.
+	//
+	// The default value is zero.
+	BlobThreshold int
+
 	// BlockCacher provides cache algorithm for LevelDB 'sorted table' block caching.
 	// Specify NoCacher to disable caching algorithm.
 	//
@@ -261,6 +392,21 @@ type Options struct {
 	// The default value (DefaultCompression) uses snappy compression.
 	Compression Compression
 
+	// Compressor defines the 'sorted table' block compressor to use,
+	// overriding Compression. Its block type must be registered, see
+	// compression.Register, for the tables to be read back.
+	//
+	// The default value is nil, the compressor of Compression is used.
+	Compressor compression.Compressor
+
+	// CompressorPerLevel defines the block compressor of the tables of each
+	// level, overriding Compressor. Tables flushed from the memdb use the
+	// compressor of level 0.
+	//
+	// The default value is nil. A nil entry, or a level past the end, uses
+	// Compressor.
+	CompressorPerLevel []compression.Compressor
+
 	// DisableBufferPool allows disable use of util.BufferPool functionality.
 	//
 	// The default value is false.
@@ -320,6 +466,19 @@ type Options struct {
 	// The default value is nil.
 	Filter filter.Filter
 
+	// PrefixExtractor defines the key prefixes added to the filter along
+	// with the keys. With it, the filter is used to skip the tables holding
+	// no key of the prefix when iterating over a range within a single
+	// prefix, see filter.NewPrefixFilter. Ranges are only known to be within
+	// a prefix with the default comparer.
+	//
+	// Changing the extractor changes the filter name, so tables written
+	// before are read without filter unless the old filter is put to the
+	// 'alternative filters'.
+	//
+	// The default value is nil.
+	PrefixExtractor filter.PrefixExtractor
+
 	// IteratorSamplingRate defines approximate gap (in bytes) between read
 	// sampling of an iterator. The samples will be used to determine when
 	// compaction should be triggered.
@@ -329,6 +488,54 @@ type Options struct {
 	// The default is 1MiB.
 	IteratorSamplingRate int
 
+	// KeyRouter picks the tree a key belongs to. If set, Put, Get, Has,
+	// Delete, Write and NewIterator of the DB, and Get, Has and NewIterator
+	// of its snapshots, route keys through it, while
+	// the '_s' methods keep targeting the secondary tree explicitly.
+	// The same router must be used over the lifetime of the DB.
+	//
+	// The default value is nil, everything goes to the primary tree.
+	KeyRouter func(key []byte) Tree
+
+	// MergeOperator folds the operands written by DB.Merge into the values
+	// of their keys. The operands are kept as they are written and folded
+	// lazily: reads merge them on the fly, and table compactions merge
+	// them once every snapshot sees them. Merge operands are only supported
+	// in the primary tree.
+	//
+	// The same operator must be used over the lifetime of the DB, a DB
+	// holding operands can't be read without one.
+	//
+	// The default value is nil, Merge fails with ErrMergeUnsupported.
+	MergeOperator MergeOperator
+
+	// CompactionFilter is called by the table compactions of both trees
+	// with the newest value of every key no snapshot sees, and may remove
+	// the key or change its value. A removed key is dropped, or
+	// written as a deletion when deeper levels may hold older values of it.
+	// Memdb flushes and merge operands don't go through the filter, and a
+	// key is only filtered once a compaction reaches it, so reads may still
+	// see it meanwhile.
+	//
+	// The default value is nil, every key is kept.
+	CompactionFilter CompactionFilter
+
+	// Listener receives the flush, compaction, write stall, table file and
+	// background error events of both trees.
+	//
+	// The default value is nil, events are dropped.
+	Listener Listener
+
+	// RateLimiter throttles the table writes of both trees, from memdb
+	// flushes, compactions and ingestions, and the reads of the table
+	// compactions, so that background work leaves I/O to the reads. Each
+	// tree has its own bucket, Secondary.RateLimiter sets the one of the
+	// secondary tree. The rate of a tree can be changed at runtime with
+	// DB.SetCompactionRate.
+	//
+	// The default value is nil, I/O is not throttled.
+	RateLimiter *RateLimiter
+
 	// NoSync allows completely disable fsync.
 	//
 	// The default is false.
@@ -356,6 +563,17 @@ type Options struct {
 	// The default value is false.
 	ReadOnly bool
 
+	// Secondary overrides the options of the secondary tree. Only the fields
+	// tuning a single tree are honoured: BlockRestartInterval, BlockSize,
+	// the Compaction* sizes, factors and multipliers, CompactionL0Trigger,
+	// Compression, Compressor, CompressorPerLevel, Filter, PrefixExtractor,
+	// RateLimiter, WriteBuffer, WriteL0PauseTrigger and
+	// WriteL0SlowdownTrigger. Fields
+	// left unset fall back to the primary values.
+	//
+	// The default value is nil.
+	Secondary *Options
+
 	// Strict defines the DB strict level.
 	Strict Strict
 
@@ -368,7 +586,8 @@ type Options struct {
 	//The default value is 4MiB.
 	WriteBuffer int
 
-	//WriteBuuffer2 defines maximum size of a memdb in another LSM-tree
+	//WriteBuuffer2 defines maximum size of a memdb in another LSM-tree.
+	//Secondary.WriteBuffer takes precedence over it.
 	WriteBuffer2 int
 
 	// WriteL0StopTrigger defines number of 'sorted table' at level-0 that will
@@ -391,6 +610,39 @@ func (o *Options) GetAltFilters() []filter.Filter {
 	return o.AltFilters
 }
 
+func (o *Options) GetAncientCompression(kind string) Compression {
+	if o != nil {
+		if c, ok := o.AncientCompression[kind]; ok && c > DefaultCompression && c < nCompression {
+			return c
+		}
+	}
+	if c, ok := DefaultAncientCompression[kind]; ok {
+		return c
+	}
+	return NoCompression
+}
+
+func (o *Options) GetAncientDistance() int {
+	if o == nil || o.AncientDistance < 0 {
+		return 0
+	}
+	return o.AncientDistance
+}
+
+func (o *Options) GetBlobGCRatio() float64 {
+	if o == nil || o.BlobGCRatio <= 0 {
+		return DefaultBlobGCRatio
+	}
+	return o.BlobGCRatio
+}
+
+func (o *Options) GetBlobThreshold() int {
+	if o == nil || o.BlobThreshold < 0 {
+		return 0
+	}
+	return o.BlobThreshold
+}
+
 func (o *Options) GetBlockCacher() Cacher {
 	if o == nil || o.BlockCacher == nil {
 		return DefaultBlockCacher
@@ -454,10 +706,11 @@ func (o *Options) GetCompactionL0Trigger() int {
 }
 
 func (o *Options) GetCompactionL0Trigger2() int {
-	if o == nil || o.CompactionL0Trigger == 0 {
+	so := o.GetSecondary()
+	if so == nil || so.CompactionL0Trigger == 0 {
 		return DefaultCompactionL0Trigger2
 	}
-	return o.CompactionL0Trigger
+	return so.CompactionL0Trigger
 }
 
 func (o *Options) GetCompactionSourceLimit(level int) int {
@@ -524,6 +777,22 @@ func (o *Options) GetCompression() Compression {
 	return o.Compression
 }
 
+// GetCompressor returns the block compressor of the tables of the level.
+func (o *Options) GetCompressor(level int) compression.Compressor {
+	if o != nil {
+		if level >= 0 && level < len(o.CompressorPerLevel) && o.CompressorPerLevel[level] != nil {
+			return o.CompressorPerLevel[level]
+		}
+		if o.Compressor != nil {
+			return o.Compressor
+		}
+	}
+	if o.GetCompression() == NoCompression {
+		return compression.None
+	}
+	return compression.Snappy
+}
+
 func (o *Options) GetDisableBufferPool() bool {
 	if o == nil {
 		return false
@@ -577,6 +846,9 @@ func (o *Options) GetFilter() filter.Filter {
 	if o == nil {
 		return nil
 	}
+	if _, ok := o.Filter.(filter.PrefixFilter); !ok && o.Filter != nil && o.PrefixExtractor != nil {
+		return filter.NewPrefixFilter(o.Filter, o.PrefixExtractor)
+	}
 	return o.Filter
 }
 
@@ -589,6 +861,41 @@ func (o *Options) GetIteratorSamplingRate() int {
 	return o.IteratorSamplingRate
 }
 
+func (o *Options) GetKeyRouter() func(key []byte) Tree {
+	if o == nil {
+		return nil
+	}
+	return o.KeyRouter
+}
+
+func (o *Options) GetMergeOperator() MergeOperator {
+	if o == nil {
+		return nil
+	}
+	return o.MergeOperator
+}
+
+func (o *Options) GetCompactionFilter() CompactionFilter {
+	if o == nil {
+		return nil
+	}
+	return o.CompactionFilter
+}
+
+func (o *Options) GetListener() Listener {
+	if o == nil || o.Listener == nil {
+		return NopListener{}
+	}
+	return o.Listener
+}
+
+func (o *Options) GetRateLimiter() *RateLimiter {
+	if o == nil {
+		return nil
+	}
+	return o.RateLimiter
+}
+
 func (o *Options) GetNoSync() bool {
 	if o == nil {
 		return false
@@ -629,6 +936,88 @@ func (o *Options) GetReadOnly() bool {
 	return o.ReadOnly
 }
 
+// GetSecondary returns the options of the secondary tree, that is o with the
+// fields set in o.Secondary taking precedence. WriteBuffer2 is honoured as
+// the secondary WriteBuffer unless o.Secondary sets one.
+func (o *Options) GetSecondary() *Options {
+	if o == nil {
+		return nil
+	}
+	so := *o
+	so.Secondary = nil
+	if o.WriteBuffer2 > 0 {
+		so.WriteBuffer = o.WriteBuffer2
+	}
+	sec := o.Secondary
+	if sec == nil {
+		return &so
+	}
+	if sec.BlockRestartInterval > 0 {
+		so.BlockRestartInterval = sec.BlockRestartInterval
+	}
+	if sec.BlockSize > 0 {
+		so.BlockSize = sec.BlockSize
+	}
+	if sec.CompactionExpandLimitFactor > 0 {
+		so.CompactionExpandLimitFactor = sec.CompactionExpandLimitFactor
+	}
+	if sec.CompactionGPOverlapsFactor > 0 {
+		so.CompactionGPOverlapsFactor = sec.CompactionGPOverlapsFactor
+	}
+	if sec.CompactionL0Trigger > 0 {
+		so.CompactionL0Trigger = sec.CompactionL0Trigger
+	}
+	if sec.CompactionSourceLimitFactor > 0 {
+		so.CompactionSourceLimitFactor = sec.CompactionSourceLimitFactor
+	}
+	if sec.CompactionTableSize > 0 {
+		so.CompactionTableSize = sec.CompactionTableSize
+	}
+	if sec.CompactionTableSizeMultiplier > 0 {
+		so.CompactionTableSizeMultiplier = sec.CompactionTableSizeMultiplier
+	}
+	if sec.CompactionTableSizeMultiplierPerLevel != nil {
+		so.CompactionTableSizeMultiplierPerLevel = sec.CompactionTableSizeMultiplierPerLevel
+	}
+	if sec.CompactionTotalSize > 0 {
+		so.CompactionTotalSize = sec.CompactionTotalSize
+	}
+	if sec.CompactionTotalSizeMultiplier > 0 {
+		so.CompactionTotalSizeMultiplier = sec.CompactionTotalSizeMultiplier
+	}
+	if sec.CompactionTotalSizeMultiplierPerLevel != nil {
+		so.CompactionTotalSizeMultiplierPerLevel = sec.CompactionTotalSizeMultiplierPerLevel
+	}
+	if sec.Compression != DefaultCompression {
+		so.Compression = sec.Compression
+	}
+	if sec.Compressor != nil {
+		so.Compressor = sec.Compressor
+	}
+	if sec.CompressorPerLevel != nil {
+		so.CompressorPerLevel = sec.CompressorPerLevel
+	}
+	if sec.Filter != nil {
+		so.Filter = sec.Filter
+	}
+	if sec.PrefixExtractor != nil {
+		so.PrefixExtractor = sec.PrefixExtractor
+	}
+	if sec.RateLimiter != nil {
+		so.RateLimiter = sec.RateLimiter
+	}
+	if sec.WriteBuffer > 0 {
+		so.WriteBuffer = sec.WriteBuffer
+	}
+	if sec.WriteL0PauseTrigger > 0 {
+		so.WriteL0PauseTrigger = sec.WriteL0PauseTrigger
+	}
+	if sec.WriteL0SlowdownTrigger > 0 {
+		so.WriteL0SlowdownTrigger = sec.WriteL0SlowdownTrigger
+	}
+	return &so
+}
+
 func (o *Options) GetStrict(strict Strict) bool {
 	if o == nil || o.Strict == 0 {
 		return DefaultStrict&strict != 0
@@ -644,10 +1033,7 @@ func (o *Options) GetWriteBuffer() int {
 }
 
 func (o *Options) GetWriteBuffer2() int {
-	if o == nil || o.WriteBuffer2 <= 0 {
-		return DefaultWriteBuffer
-	}
-	return o.WriteBuffer2
+	return o.GetSecondary().GetWriteBuffer()
 }
 
 func (o *Options) GetWriteL0PauseTrigger() int {
@@ -658,10 +1044,11 @@ func (o *Options) GetWriteL0PauseTrigger() int {
 }
 
 func (o *Options) GetWriteL0PauseTrigger2() int {
-	if o == nil || o.WriteL0PauseTrigger == 0 {
+	so := o.GetSecondary()
+	if so == nil || so.WriteL0PauseTrigger == 0 {
 		return DefaultWriteL0PauseTrigger2
 	}
-	return o.WriteL0PauseTrigger
+	return so.WriteL0PauseTrigger
 }
 
 func (o *Options) GetWriteL0SlowdownTrigger() int {
@@ -672,10 +1059,11 @@ func (o *Options) GetWriteL0SlowdownTrigger() int {
 }
 
 func (o *Options) GetWriteL0SlowdownTrigger2() int {
-	if o == nil || o.WriteL0SlowdownTrigger == 0 {
+	so := o.GetSecondary()
+	if so == nil || so.WriteL0SlowdownTrigger == 0 {
 		return DefaultWriteL0SlowdownTrigger2
 	}
-	return o.WriteL0SlowdownTrigger
+	return so.WriteL0SlowdownTrigger
 }
 
 // ReadOptions holds the optional parameters for 'read operation'. The
@@ -23,24 +23,31 @@ func dupOptions(o *opt.Options) *opt.Options {
 }
 
 func (s *session) setOptions(o *opt.Options) {
+	// Comparer.
+	s.icmp = &iComparer{o.GetComparer()}
+	s.o = s.newCachedOptions(o)
+	s.o_s = s.newCachedOptions(o.GetSecondary())
+}
+
+func (s *session) newCachedOptions(o *opt.Options) *cachedOptions {
 	no := dupOptions(o)
 	// Alternative filters.
 	if filters := o.GetAltFilters(); len(filters) > 0 {
 		no.AltFilters = make([]filter.Filter, len(filters))
 		for i, filter := range filters {
-			no.AltFilters[i] = &iFilter{filter}
+			no.AltFilters[i] = newIFilter(filter)
 		}
 	}
 	// Comparer.
-	s.icmp = &iComparer{o.GetComparer()}
 	no.Comparer = s.icmp
 	// Filter.
 	if filter := o.GetFilter(); filter != nil {
-		no.Filter = &iFilter{filter}
+		no.Filter = newIFilter(filter)
 	}
 
-	s.o = &cachedOptions{Options: no}
-	s.o.cache()
+	co := &cachedOptions{Options: no}
+	co.cache()
+	return co
 }
 
 const optCachedLevel = 7
@@ -0,0 +1,350 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"sort"
+
+	"awesomeProject1/goleveldb/leveldb/cache"
+	"awesomeProject1/goleveldb/leveldb/iterator"
+	"awesomeProject1/goleveldb/leveldb/table"
+	"awesomeProject1/goleveldb/leveldb/util"
+)
+
+// rangeTombstone deletes the keys from start, inclusive, up to limit,
+// exclusive, written before seq. In memdbs and tables it is stored as the
+// internal key (start, seq, keyTypeDel) mapped to limit.
+type rangeTombstone struct {
+	start, limit []byte
+	seq          uint64
+}
+
+func (rt rangeTombstone) ikey() internalKey {
+	return makeInternalKey(nil, rt.start, rt.seq, keyTypeDel)
+}
+
+func (rt rangeTombstone) covers(icmp *iComparer, ukey []byte) bool {
+	return icmp.uCompare(rt.start, ukey) <= 0 && icmp.uCompare(ukey, rt.limit) < 0
+}
+
+// readRangeTombstones reads and releases iter, an iterator of stored range
+// tombstones.
+func readRangeTombstones(iter iterator.Iterator) (rts []rangeTombstone, err error) {
+	defer iter.Release()
+	for iter.Next() {
+		start, seq, _, kerr := parseInternalKey(iter.Key())
+		if kerr != nil {
+			return nil, kerr
+		}
+		rts = append(rts, rangeTombstone{
+			start: append([]byte(nil), start...),
+			limit: append([]byte(nil), iter.Value()...),
+			seq:   seq,
+		})
+	}
+	return rts, iter.Error()
+}
+
+// rangeTombstoneSeq reads and releases iter, an iterator of stored range
+// tombstones, and returns the sequence number of the newest one covering
+// ukey that is visible at seq, or zero if there is none.
+func rangeTombstoneSeq(icmp *iComparer, iter iterator.Iterator, ukey []byte, seq uint64) (uint64, error) {
+	defer iter.Release()
+	var rseq uint64
+	for iter.Next() {
+		start, tseq, _, kerr := parseInternalKey(iter.Key())
+		if kerr != nil {
+			return 0, kerr
+		}
+		if icmp.uCompare(start, ukey) > 0 {
+			break
+		}
+		if tseq <= seq && tseq > rseq && icmp.uCompare(ukey, iter.Value()) < 0 {
+			rseq = tseq
+		}
+	}
+	return rseq, iter.Error()
+}
+
+// memRangeTombstoneSeq is rangeTombstoneSeq over the range tombstones of a
+// memdb of the given tree.
+func memRangeTombstoneSeq(icmp *iComparer, m *memDB, secondary bool, ukey []byte, seq uint64) (uint64, error) {
+	if secondary {
+		return rangeTombstoneSeq(icmp, m.NewRangeTombstoneIterator_s(), ukey, seq)
+	}
+	return rangeTombstoneSeq(icmp, m.NewRangeTombstoneIterator(), ukey, seq)
+}
+
+// rangeDelFragment is a piece of the key space covered by the same set of
+// range tombstones.
+type rangeDelFragment struct {
+	start, limit []byte
+	seqs         []uint64 // decreasing
+}
+
+// rangeDelIndex answers which range tombstones cover a key. The tombstones
+// are split at every start and limit into non-overlapping fragments, so a
+// lookup is a binary search.
+type rangeDelIndex struct {
+	icmp  *iComparer
+	frags []rangeDelFragment
+}
+
+// newRangeDelIndex indexes the tombstones of rts visible at seq. It returns
+// nil if there are none.
+func newRangeDelIndex(icmp *iComparer, rts []rangeTombstone, seq uint64) *rangeDelIndex {
+	var bounds [][]byte
+	for _, rt := range rts {
+		if rt.seq <= seq && icmp.uCompare(rt.start, rt.limit) < 0 {
+			bounds = append(bounds, rt.start, rt.limit)
+		}
+	}
+	if len(bounds) == 0 {
+		return nil
+	}
+	sort.Slice(bounds, func(i, j int) bool {
+		return icmp.uCompare(bounds[i], bounds[j]) < 0
+	})
+	x := &rangeDelIndex{icmp: icmp}
+	for i := 1; i < len(bounds); i++ {
+		if icmp.uCompare(bounds[i-1], bounds[i]) < 0 {
+			x.frags = append(x.frags, rangeDelFragment{start: bounds[i-1], limit: bounds[i]})
+		}
+	}
+	for _, rt := range rts {
+		if rt.seq > seq || icmp.uCompare(rt.start, rt.limit) >= 0 {
+			continue
+		}
+		for i := x.search(rt.start); i < len(x.frags) && icmp.uCompare(x.frags[i].start, rt.limit) < 0; i++ {
+			x.frags[i].seqs = append(x.frags[i].seqs, rt.seq)
+		}
+	}
+	for _, f := range x.frags {
+		sort.Slice(f.seqs, func(i, j int) bool { return f.seqs[i] > f.seqs[j] })
+	}
+	return x
+}
+
+// search returns the index of the first fragment ending after ukey.
+func (x *rangeDelIndex) search(ukey []byte) int {
+	return sort.Search(len(x.frags), func(i int) bool {
+		return x.icmp.uCompare(x.frags[i].limit, ukey) > 0
+	})
+}
+
+// seq returns the sequence number of the newest tombstone covering ukey
+// that is visible at seq, or zero if there is none.
+func (x *rangeDelIndex) seq(ukey []byte, seq uint64) uint64 {
+	if x == nil {
+		return 0
+	}
+	i := x.search(ukey)
+	if i == len(x.frags) || x.icmp.uCompare(x.frags[i].start, ukey) > 0 {
+		return 0
+	}
+	for _, tseq := range x.frags[i].seqs {
+		if tseq <= seq {
+			return tseq
+		}
+	}
+	return 0
+}
+
+// rangeTombstonesOverlap returns true if a range tombstone of iter, which is
+// released, overlaps the keys from umin to umax, both inclusive and nil
+// meaning unbounded.
+func rangeTombstonesOverlap(icmp *iComparer, iter iterator.Iterator, umin, umax []byte) bool {
+	defer iter.Release()
+	for iter.Next() {
+		start := internalKey(iter.Key()).ukey()
+		if umax != nil && icmp.uCompare(start, umax) > 0 {
+			break
+		}
+		if umin == nil || icmp.uCompare(umin, iter.Value()) < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the range tombstones of the table. They are kept by tOps once
+// read, so a table is opened for them at most once.
+func (t *tOps) rangeTombstones(f *tFile) ([]rangeTombstone, error) {
+	return t.cachedRangeTombstones(f.fd.Num, func() (*cache.Handle, error) { return t.open(f) })
+}
+func (t *tOps) rangeTombstones_s(f *sFile) ([]rangeTombstone, error) {
+	return t.cachedRangeTombstones(f.fd.Num, func() (*cache.Handle, error) { return t.open_s(f) })
+}
+
+func (t *tOps) cachedRangeTombstones(num int64, open func() (*cache.Handle, error)) ([]rangeTombstone, error) {
+	t.rangeDelMu.Lock()
+	rts, ok := t.rangeDels[num]
+	t.rangeDelMu.Unlock()
+	if ok {
+		return rts, nil
+	}
+	ch, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Release()
+	rts, err = readRangeTombstones(ch.Value().(*table.Reader).NewRangeTombstoneIterator())
+	if err != nil {
+		return nil, err
+	}
+	t.setRangeTombstones(num, rts)
+	return rts, nil
+}
+
+func (t *tOps) setRangeTombstones(num int64, rts []rangeTombstone) {
+	t.rangeDelMu.Lock()
+	t.rangeDels[num] = rts
+	t.rangeDelMu.Unlock()
+}
+
+// Returns the sequence number of the newest range tombstone of the table
+// covering ukey that is visible at seq, or zero if there is none.
+func (t *tOps) rangeTombstoneSeq(f *tFile, ukey []byte, seq uint64) (uint64, error) {
+	rts, err := t.rangeTombstones(f)
+	return maxRangeTombstoneSeq(t.s.icmp, rts, ukey, seq), err
+}
+func (t *tOps) rangeTombstoneSeq_s(f *sFile, ukey []byte, seq uint64) (uint64, error) {
+	rts, err := t.rangeTombstones_s(f)
+	return maxRangeTombstoneSeq(t.s.icmp, rts, ukey, seq), err
+}
+
+func maxRangeTombstoneSeq(icmp *iComparer, rts []rangeTombstone, ukey []byte, seq uint64) (rseq uint64) {
+	for _, rt := range rts {
+		if rt.seq <= seq && rt.seq > rseq && rt.covers(icmp, ukey) {
+			rseq = rt.seq
+		}
+	}
+	return
+}
+
+// Returns the index of the range tombstones visible at seq in the given
+// memdbs and in the tables of v overlapping slice, an internal key range.
+func (db *DB) newRangeDelIndex(mems []*memDB, v *version, slice *util.Range, seq uint64, secondary bool) (*rangeDelIndex, error) {
+	var umin, umax []byte
+	if slice != nil {
+		if slice.Start != nil {
+			umin = internalKey(slice.Start).ukey()
+		}
+		if slice.Limit != nil {
+			umax = internalKey(slice.Limit).ukey()
+		}
+	}
+
+	var all []rangeTombstone
+	for _, m := range mems {
+		var iter iterator.Iterator
+		if secondary {
+			iter = m.NewRangeTombstoneIterator_s()
+		} else {
+			iter = m.NewRangeTombstoneIterator()
+		}
+		rts, err := readRangeTombstones(iter)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, rts...)
+	}
+	if secondary {
+		for _, tables := range v.level_s {
+			for _, t := range tables {
+				if !t.overlaps(db.s.icmp, umin, umax) {
+					continue
+				}
+				rts, err := db.s.tops.rangeTombstones_s(t)
+				if err != nil {
+					return nil, err
+				}
+				all = append(all, rts...)
+			}
+		}
+	} else {
+		for _, tables := range v.levels {
+			for _, t := range tables {
+				if !t.overlaps(db.s.icmp, umin, umax) {
+					continue
+				}
+				rts, err := db.s.tops.rangeTombstones(t)
+				if err != nil {
+					return nil, err
+				}
+				all = append(all, rts...)
+			}
+		}
+	}
+	return newRangeDelIndex(db.s.icmp, all, seq), nil
+}
+
+// Removes from the compaction the tables of sourceLevel+1 whose keys all lie
+// under a single sourceLevel range tombstone seen by every snapshot. Newer
+// entries of a key never sit below older ones, so everything in such a table
+// is deleted and it is dropped without being read.
+func (c *compaction) dropCoveredTables(minSeq uint64) (dropped tFiles, err error) {
+	var rts []rangeTombstone
+	for _, t := range c.levels[0] {
+		x, err := c.s.tops.rangeTombstones(t)
+		if err != nil {
+			return nil, err
+		}
+		rts = append(rts, x...)
+	}
+	if len(rts) == 0 {
+		return nil, nil
+	}
+	var kept tFiles
+	for _, t := range c.levels[1] {
+		if coveredByRangeTombstone(c.s.icmp, rts, t.imin.ukey(), t.imax.ukey(), minSeq) {
+			dropped = append(dropped, t)
+		} else {
+			kept = append(kept, t)
+		}
+	}
+	if len(dropped) > 0 {
+		c.levels[1] = kept
+	}
+	return dropped, nil
+}
+func (c *compaction) dropCoveredTables_s(minSeq uint64) (dropped sFiles, err error) {
+	var rts []rangeTombstone
+	for _, t := range c.level_s[0] {
+		x, err := c.s.tops.rangeTombstones_s(t)
+		if err != nil {
+			return nil, err
+		}
+		rts = append(rts, x...)
+	}
+	if len(rts) == 0 {
+		return nil, nil
+	}
+	var kept sFiles
+	for _, t := range c.level_s[1] {
+		if coveredByRangeTombstone(c.s.icmp, rts, t.imin.ukey(), t.imax.ukey(), minSeq) {
+			dropped = append(dropped, t)
+		} else {
+			kept = append(kept, t)
+		}
+	}
+	if len(dropped) > 0 {
+		c.level_s[1] = kept
+	}
+	return dropped, nil
+}
+
+// coveredByRangeTombstone returns true if a single tombstone of rts visible
+// at seq covers all the keys from umin to umax, both inclusive.
+func coveredByRangeTombstone(icmp *iComparer, rts []rangeTombstone, umin, umax []byte, seq uint64) bool {
+	for _, rt := range rts {
+		if rt.seq <= seq && rt.covers(icmp, umin) && rt.covers(icmp, umax) {
+			return true
+		}
+	}
+	return false
+}
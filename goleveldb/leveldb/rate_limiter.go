@@ -0,0 +1,153 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"awesomeProject1/goleveldb/leveldb/iterator"
+	"awesomeProject1/goleveldb/leveldb/storage"
+)
+
+const (
+	// Longest sleep of rateLimiter.wait before looking at the bucket again,
+	// so that rate changes and close apply to waiting writers.
+	rateLimiterMaxSleep = 100 * time.Millisecond
+
+	// Bytes read by a compaction between two takes from the bucket.
+	rateLimiterReadChunk = 64 << 10
+)
+
+// rateLimiter is the token bucket throttling the background I/O of a tree,
+// see opt.Options.RateLimiter. Takes may drive the bucket negative, the
+// caller then sleeps until it is refilled.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   int64 // bytes per second, zero or less doesn't limit
+	burst  int64
+	tokens float64
+	last   time.Time
+	closed bool
+
+	throttled int64 // nanoseconds slept, atomic
+}
+
+func newRateLimiter(rate, burst int64) *rateLimiter {
+	l := &rateLimiter{}
+	l.setRate(rate, burst)
+	return l
+}
+
+func (l *rateLimiter) setRate(rate, burst int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if burst <= 0 {
+		burst = rate / 10
+	}
+	l.rate, l.burst = rate, burst
+	l.tokens, l.last = float64(burst), time.Now()
+}
+
+// wait takes n bytes from the bucket, sleeping while it is in debt.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	if l.rate <= 0 || l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.refill()
+	l.tokens -= float64(n)
+	for l.tokens < 0 && l.rate > 0 && !l.closed {
+		d := time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+		if d > rateLimiterMaxSleep {
+			d = rateLimiterMaxSleep
+		}
+		l.mu.Unlock()
+		start := time.Now()
+		time.Sleep(d)
+		atomic.AddInt64(&l.throttled, int64(time.Since(start)))
+		l.mu.Lock()
+		l.refill()
+	}
+	l.mu.Unlock()
+}
+
+// Adds the tokens earned since the last refill, up to burst. Must be called
+// with mu held.
+func (l *rateLimiter) refill() {
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+	l.last = now
+}
+
+// Returns the total time spent waiting for the bucket.
+func (l *rateLimiter) throttleDuration() time.Duration {
+	return time.Duration(atomic.LoadInt64(&l.throttled))
+}
+
+// close stops throttling, letting the waiting writers go.
+func (l *rateLimiter) close() {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+}
+
+// limitedWriter is a storage.Writer taking the bytes it writes from a
+// rateLimiter.
+type limitedWriter struct {
+	storage.Writer
+	l *rateLimiter
+}
+
+func (w limitedWriter) Write(p []byte) (int, error) {
+	w.l.wait(len(p))
+	return w.Writer.Write(p)
+}
+
+// limitedIterator is an iterator taking the bytes of the entries it reads
+// from a rateLimiter, by chunks.
+type limitedIterator struct {
+	iterator.Iterator
+	l       *rateLimiter
+	pending int
+}
+
+func (i *limitedIterator) Next() bool {
+	if !i.Iterator.Next() {
+		return false
+	}
+	i.pending += len(i.Key()) + len(i.Value())
+	if i.pending >= rateLimiterReadChunk {
+		i.l.wait(i.pending)
+		i.pending = 0
+	}
+	return true
+}
+
+// SetCompactionRate changes the rate of opt.Options.RateLimiter to
+// bytesPerSec for the given tree, zero or less stops throttling it. The
+// burst of the options of the tree is kept if set.
+func (db *DB) SetCompactionRate(tree Tree, bytesPerSec int64) error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+	o, l := db.s.o, db.s.tops.limiter
+	if tree == SecondaryTree {
+		o, l = db.s.o_s, db.s.tops.limiter_s
+	}
+	var burst int64
+	if rl := o.GetRateLimiter(); rl != nil {
+		burst = rl.Burst
+	}
+	l.setRate(bytesPerSec, burst)
+	return nil
+}
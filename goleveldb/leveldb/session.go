@@ -45,6 +45,7 @@ type session struct {
 	stor     *iStorage
 	storLock storage.Locker
 	o        *cachedOptions
+	o_s      *cachedOptions // secondary tree, see opt.Options.Secondary
 	icmp     *iComparer
 	tops     *tOps // 管理缓存！
 	//tops2    *tOps // 同样管理缓存？
@@ -246,6 +247,7 @@ func (s *session) commit(r *sessionRecord, trivial bool) (err error) {
 	// finally, apply new version if no error rise
 	if err == nil {
 		s.setVersion(r, nv)
+		s.tops.commitBlobs(r)
 	}
 
 	return
@@ -34,8 +34,8 @@ func (s *session) flushMemdb_s(rec *sessionRecord, mdb *memdb.DBs, maxLevel int)
 	// Create sorted table.
 	iter := mdb.NewIterator_s(nil)
 	defer iter.Release()
-	t, n, err := s.tops.createFrom_s(iter) //这里t是一个sfile
-	if err != nil {
+	t, n, err := s.tops.createFrom_s(iter, mdb.NewRangeTombstoneIterator_s()) //这里t是一个sfile
+	if err != nil || t == nil {
 		return 0, err
 	}
 	//Pick level other than zero can cause compaction issue with large
@@ -60,8 +60,8 @@ func (s *session) flushMemdb(rec *sessionRecord, mdb *memdb.DB, maxLevel int) (i
 	// Create sorted table.
 	iter := mdb.NewIterator(nil) //immutable的迭代器
 	defer iter.Release()
-	t, n, err := s.tops.createFrom(iter) //n为 number of entries added so far.
-	if err != nil {
+	t, n, err := s.tops.createFrom(iter, mdb.NewRangeTombstoneIterator()) //n为 number of entries added so far.
+	if err != nil || t == nil {
 		return 0, err
 	}
 	// Pick level other than zero can cause compaction issue with large
@@ -217,7 +217,7 @@ func (s *session) getCompactionRange_s(sourceLevel int, umin, umax []byte, noLim
 	//and we must not pick one file and drop another older file if the
 	//two files overlap.
 	if !noLimit && sourceLevel > 0 {
-		limit := int64(v.s.o.GetCompactionSourceLimit(sourceLevel))
+		limit := int64(v.s.o_s.GetCompactionSourceLimit(sourceLevel))
 		total := int64(0)
 		for i, t := range t0 {
 			total += t.size
@@ -258,7 +258,7 @@ func newCompaction_s(s *session, v *version, sourceLevel int, t0 sFiles, typ int
 		typ:           typ,                //知道了触发的类型
 		sourceLevel:   sourceLevel,        //此为参与合并的是哪一层
 		level_s:       [2]sFiles{t0, nil}, //得到了参与compaction的第一层数据
-		maxGPOverlaps: int64(s.o.GetCompactionGPOverlaps(sourceLevel)),
+		maxGPOverlaps: int64(s.o_s.GetCompactionGPOverlaps(sourceLevel)),
 		tPtrs:         make([]int, len(v.level_s)), //一块空间
 	}
 	c.expand_s()
@@ -365,7 +365,7 @@ func (c *compaction) expand() {
 	c.imin, c.imax = imin, imax
 }
 func (c *compaction) expand_s() {
-	limit := int64(c.s.o.GetCompactionExpandLimit(c.sourceLevel)) //参与compaction的大小限制？
+	limit := int64(c.s.o_s.GetCompactionExpandLimit(c.sourceLevel)) //参与compaction的大小限制？
 	vt0 := c.v.level_s[c.sourceLevel]
 	vt1 := sFiles{}                                           //暂且为空
 	if level := c.sourceLevel + 1; level < len(c.v.level_s) { //下一层
@@ -458,6 +458,25 @@ func (c *compaction) baseLevelForKey_s(ukey []byte) bool {
 	}
 	return true
 }
+
+// baseLevelForRange is baseLevelForKey for the keys from umin to umax, both
+// inclusive.
+func (c *compaction) baseLevelForRange(umin, umax []byte) bool {
+	for level := c.sourceLevel + 2; level < len(c.v.levels); level++ {
+		if c.v.levels[level].overlaps(c.s.icmp, umin, umax, false) {
+			return false
+		}
+	}
+	return true
+}
+func (c *compaction) baseLevelForRange_s(umin, umax []byte) bool {
+	for level := c.sourceLevel + 2; level < len(c.v.level_s); level++ {
+		if c.v.level_s[level].overlaps(c.s.icmp, umin, umax, false) {
+			return false
+		}
+	}
+	return true
+}
 func (c *compaction) shouldStopBefore(ikey internalKey) bool {
 	for ; c.gpi < len(c.gp); c.gpi++ {
 		gp := c.gp[c.gpi]
@@ -533,7 +552,7 @@ func (c *compaction) newIterator() iterator.Iterator {
 		}
 	}
 
-	return iterator.NewMergedIterator(its, c.s.icmp, strict)
+	return &limitedIterator{Iterator: iterator.NewMergedIterator(its, c.s.icmp, strict), l: c.s.tops.limiter}
 }
 func (c *compaction) newIterator_s() iterator.Iterator {
 	// Creates iterator slice.
@@ -570,5 +589,5 @@ func (c *compaction) newIterator_s() iterator.Iterator {
 		}
 	}
 
-	return iterator.NewMergedIterator(its, c.s.icmp, strict)
+	return &limitedIterator{Iterator: iterator.NewMergedIterator(its, c.s.icmp, strict), l: c.s.tops.limiter_s}
 }
@@ -33,6 +33,7 @@ const (
 	recAddTable    = 7
 	recDelTables   = 10
 	recAddTables   = 11
+	recTableSeq    = 13
 	// 8 was used for large value refs
 	recPrevJournalNum = 9
 )
@@ -48,6 +49,7 @@ type atRecord struct {
 	size  int64
 	imin  internalKey
 	imax  internalKey
+	seq   uint64 // Global sequence number, see tFile
 }
 
 type dtRecord struct {
@@ -120,18 +122,38 @@ func (p *sessionRecord) resetCompPtrs_s() {
 }
 func (p *sessionRecord) addTable(level int, num, size int64, imin, imax internalKey) {
 	p.hasRec |= 1 << recAddTable
-	p.addedTables = append(p.addedTables, atRecord{level, num, size, imin, imax})
+	p.addedTables = append(p.addedTables, atRecord{level, num, size, imin, imax, 0})
 }
 func (p *sessionRecord) addTable_s(level int, num, size int64, imin, imax internalKey) {
 	p.hasRec |= 1 << recAddTables
-	p.addedTabless = append(p.addedTabless, atRecord{level, num, size, imin, imax})
+	p.addedTabless = append(p.addedTabless, atRecord{level, num, size, imin, imax, 0})
 }
 
 func (p *sessionRecord) addTableFile(level int, t *tFile) { //用于tablecmpaction
 	p.addTable(level, t.fd.Num, t.size, t.imin, t.imax)
+	if t.seq != 0 {
+		p.setTableSeq(t.fd.Num, t.seq)
+	}
 }
 func (p *sessionRecord) addTableFile_s(level int, t *sFile) {
 	p.addTable_s(level, t.fd.Num, t.size, t.imin, t.imax)
+	if t.seq != 0 {
+		p.setTableSeq(t.fd.Num, t.seq)
+	}
+}
+
+// setTableSeq sets the global sequence number of the added table num, and
+// reports whether there is such a table.
+func (p *sessionRecord) setTableSeq(num int64, seq uint64) bool {
+	for _, ts := range [][]atRecord{p.addedTables, p.addedTabless} {
+		for i := len(ts) - 1; i >= 0; i-- {
+			if ts[i].num == num {
+				ts[i].seq = seq
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func (p *sessionRecord) resetAddedTables() { //置空，用于recoverJ、recover()
@@ -239,6 +261,15 @@ func (p *sessionRecord) encode(w io.Writer) error {
 		p.putBytes(w, r.imin)
 		p.putBytes(w, r.imax)
 	}
+	for _, ts := range [][]atRecord{p.addedTables, p.addedTabless} {
+		for _, r := range ts {
+			if r.seq != 0 {
+				p.putUvarint(w, recTableSeq)
+				p.putVarint(w, r.num)
+				p.putUvarint(w, r.seq)
+			}
+		}
+	}
 	return p.err
 }
 
@@ -372,6 +403,12 @@ func (p *sessionRecord) decode(r io.Reader) error {
 			if p.err == nil {
 				p.addTable_s(level, num, size, imin, imax)
 			}
+		case recTableSeq:
+			num := p.readVarint("table-seq.num", br)
+			seq := p.readUvarint("table-seq.seq", br)
+			if p.err == nil && !p.setTableSeq(num, seq) {
+				p.err = errors.NewErrCorrupted(storage.FileDesc{}, &ErrManifestCorrupted{"table-seq", "table not added"})
+			}
 		case recDelTable:
 			level := p.readLevel("del-table.level", br)
 			num := p.readVarint("del-table.num", br)
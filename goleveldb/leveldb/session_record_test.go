@@ -49,6 +49,7 @@ func TestSessionRecord_EncodeDecode(t *testing.T) {
 		v.addTable(3, big+300+i, big+400+i,
 			makeInternalKey(nil, []byte("foo"), uint64(big+500+1), keyTypeVal),
 			makeInternalKey(nil, []byte("zoo"), uint64(big+600+1), keyTypeDel))
+		v.setTableSeq(big+300+i, uint64(big+800+i))
 		v.delTable(4, big+700+i)
 		v.addCompPtr(int(i), makeInternalKey(nil, []byte("x"), uint64(big+900+1), keyTypeVal))
 	}
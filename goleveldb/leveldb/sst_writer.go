@@ -0,0 +1,131 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"os"
+
+	"awesomeProject1/goleveldb/leveldb/comparer"
+	"awesomeProject1/goleveldb/leveldb/errors"
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/table"
+)
+
+// ErrSSTKeyOrder is returned by SSTWriter.Put when a key isn't greater than
+// the previous one.
+var ErrSSTKeyOrder = errors.New("leveldb: keys not in increasing order")
+
+var errSSTWriterDone = errors.New("leveldb: SSTWriter finished or aborted")
+
+// SSTWriter builds a table file outside of any DB, to be added to one with
+// IngestExternalFiles. It takes user keys in increasing order and writes
+// them as internal keys, so the file has the layout of the tables of a DB,
+// along with a properties block describing its content.
+//
+// An SSTWriter is not safe for concurrent use, distinct SSTWriters may be
+// used from distinct goroutines.
+type SSTWriter struct {
+	path  string
+	f     *os.File
+	tw    *table.Writer
+	ucmp  comparer.Comparer
+	props table.Properties
+	ikey  internalKey
+	err   error
+}
+
+// NewSSTWriter creates the table file at path, truncating it if it exists.
+// The comparer, filter, compression and block options of o are used the
+// same way a DB uses them; o must have the comparer of the DBs the file is
+// ingested into. The options may be nil.
+func NewSSTWriter(path string, o *opt.Options) (*SSTWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	ucmp := o.GetComparer()
+	to := dupOptions(o)
+	to.Comparer = &iComparer{ucmp}
+	if filter := o.GetFilter(); filter != nil {
+		to.Filter = newIFilter(filter)
+	}
+	w := &SSTWriter{
+		path: path,
+		f:    f,
+		tw:   table.NewWriter(f, to),
+		ucmp: ucmp,
+	}
+	w.props.InternalKeys = true
+	w.props.Comparer = ucmp.Name()
+	return w, nil
+}
+
+// Put appends the key/value pair to the table. The key must be greater than
+// the previous one.
+//
+// It is safe to modify the contents of the arguments after Put returns.
+func (w *SSTWriter) Put(key, value []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.props.NumEntries > 0 && w.ucmp.Compare(w.props.LargestKey, key) >= 0 {
+		return ErrSSTKeyOrder
+	}
+	w.ikey = makeInternalKey(w.ikey, key, 0, keyTypeVal)
+	if w.err = w.tw.Append(w.ikey, value); w.err != nil {
+		return w.err
+	}
+	if w.props.NumEntries == 0 {
+		w.props.SmallestKey = append([]byte{}, key...)
+	}
+	w.props.LargestKey = append(w.props.LargestKey[:0], key...)
+	w.props.NumEntries++
+	w.props.DataSize += uint64(len(key) + len(value))
+	return nil
+}
+
+// Len returns the number of entries put so far.
+func (w *SSTWriter) Len() int {
+	return int(w.props.NumEntries)
+}
+
+// Finish writes the remaining blocks and the properties, syncs and closes the
+// file, and returns the properties. The SSTWriter can't be used after.
+func (w *SSTWriter) Finish() (*table.Properties, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	w.err = errSSTWriterDone
+	if w.props.LargestKey == nil {
+		w.props.LargestKey = []byte{}
+	}
+	w.tw.SetProperties(&w.props)
+	err := w.tw.Close()
+	if err == nil {
+		err = w.f.Sync()
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	w.f = nil
+	if err != nil {
+		os.Remove(w.path)
+		return nil, err
+	}
+	return &w.props, nil
+}
+
+// Abort closes and removes the file. It does nothing after Finish.
+func (w *SSTWriter) Abort() {
+	if w.f == nil {
+		return
+	}
+	w.err = errSSTWriterDone
+	w.f.Close()
+	os.Remove(w.path)
+	w.f = nil
+}
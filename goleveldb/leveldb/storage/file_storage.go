@@ -527,6 +527,27 @@ func (fs *fileStorage) Create_s(fd FileDesc) (Writer, error) {
 	return &fileWrap{File: of, fs: fs, fd: fd}, nil
 }
 
+func (fs *fileStorage) Append(fd FileDesc) (Appender, error) {
+	if !FileDescOk(fd) {
+		return nil, ErrInvalidFile
+	}
+	if fs.readOnly {
+		return nil, errReadOnly
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.open < 0 {
+		return nil, ErrClosed
+	}
+	of, err := os.OpenFile(filepath.Join(fs.path, fsGenName(fd)), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fs.open++
+	return &fileWrap{File: of, fs: fs, fd: fd}, nil
+}
+
 func (fs *fileStorage) Remove(fd FileDesc) error {
 	if !FileDescOk(fd) {
 		return ErrInvalidFile
@@ -573,6 +594,75 @@ func (fs *fileStorage) Rename(oldfd, newfd FileDesc) error {
 	return rename(filepath.Join(fs.path, fsGenName(oldfd)), filepath.Join(fs.path, fsGenName(newfd)))
 }
 
+func (fs *fileStorage) Link(fd FileDesc, dir string) error {
+	if !FileDescOk(fd) {
+		return ErrInvalidFile
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.open < 0 {
+		return ErrClosed
+	}
+	dst := filepath.Join(dir, fsGenName(fd))
+	err := os.Link(filepath.Join(fs.path, fsGenName(fd)), dst)
+	if err != nil && fsHasOldName(fd) && os.IsNotExist(err) {
+		err = os.Link(filepath.Join(fs.path, fsGenOldName(fd)), dst)
+	}
+	return err
+}
+
+func (fs *fileStorage) Import(path string, fd FileDesc) error {
+	if !FileDescOk(fd) {
+		return ErrInvalidFile
+	}
+	if fs.readOnly {
+		return errReadOnly
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.open < 0 {
+		return ErrClosed
+	}
+	return os.Link(path, filepath.Join(fs.path, fsGenName(fd)))
+}
+
+func (fs *fileStorage) Copy(fd FileDesc, dir string) error {
+	if !FileDescOk(fd) {
+		return ErrInvalidFile
+	}
+
+	// The lock is only held to open the file, copying may take a while.
+	fs.mu.Lock()
+	if fs.open < 0 {
+		fs.mu.Unlock()
+		return ErrClosed
+	}
+	src, err := os.Open(filepath.Join(fs.path, fsGenName(fd)))
+	if err != nil && fsHasOldName(fd) && os.IsNotExist(err) {
+		src, err = os.Open(filepath.Join(fs.path, fsGenOldName(fd)))
+	}
+	fs.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(filepath.Join(dir, fsGenName(fd)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
 func (fs *fileStorage) Close() error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
@@ -614,6 +704,14 @@ func (fw *fileWrap) Sync() error {
 	return nil
 }
 
+func (fw *fileWrap) Size() (int64, error) {
+	fi, err := fw.File.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
 func (fw *fileWrap) Close() error {
 	fw.fs.mu.Lock()
 	defer fw.fs.mu.Unlock()
@@ -641,6 +739,12 @@ func fsGenName(fd FileDesc) string {
 		return fmt.Sprintf("%06d.ldb", fd.Num)
 	case TypeTemp:
 		return fmt.Sprintf("%06d.tmp", fd.Num)
+	case TypeAncient:
+		return fmt.Sprintf("%06d.adat", fd.Num)
+	case TypeAncientIndex:
+		return fmt.Sprintf("%06d.aidx", fd.Num)
+	case TypeBlob:
+		return fmt.Sprintf("%06d.blob", fd.Num)
 	default:
 		panic("invalid file type")
 	}
@@ -671,6 +775,12 @@ func fsParseName(name string) (fd FileDesc, ok bool) {
 			fd.Type = TypeTable
 		case "tmp":
 			fd.Type = TypeTemp
+		case "adat":
+			fd.Type = TypeAncient
+		case "aidx":
+			fd.Type = TypeAncientIndex
+		case "blob":
+			fd.Type = TypeBlob
 		default:
 			return
 		}
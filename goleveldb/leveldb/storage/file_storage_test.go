@@ -29,6 +29,9 @@ var cases = []struct {
 	{nil, "MANIFEST-000007", TypeManifest, 7},
 	{nil, "9223372036854775807.log", TypeJournal, 9223372036854775807},
 	{nil, "000100.tmp", TypeTemp, 100},
+	{nil, "000002.adat", TypeAncient, 2},
+	{nil, "000002.aidx", TypeAncientIndex, 2},
+	{nil, "000003.blob", TypeBlob, 3},
 }
 
 var invalidCases = []string{
@@ -398,3 +401,133 @@ func TestFileStorage_ReadOnlyLocking(t *testing.T) {
 	p3.Close()
 	p4.Close()
 }
+
+func TestFileStorage_Append(t *testing.T) {
+	temp := tempDir(t)
+	defer os.RemoveAll(temp)
+
+	fs, err := OpenFile(temp, false)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer fs.Close()
+
+	fd := FileDesc{Type: TypeAncient, Num: 1}
+	a, err := fs.Append(fd)
+	if err != nil {
+		t.Fatal("Append: got error: ", err)
+	}
+	fmt.Fprintf(a, "abcdef")
+	if err := a.Truncate(4); err != nil {
+		t.Fatal("Truncate: got error: ", err)
+	}
+	fmt.Fprintf(a, "xy")
+	a.Close()
+
+	a, err = fs.Append(fd)
+	if err != nil {
+		t.Fatal("Append: got error: ", err)
+	}
+	defer a.Close()
+	fmt.Fprintf(a, "z")
+	if size, _ := a.Size(); size != 7 {
+		t.Fatalf("Size: want 7, got %d", size)
+	}
+	buf := make([]byte, 7)
+	if _, err := a.ReadAt(buf, 0); err != nil {
+		t.Fatal("ReadAt: got error: ", err)
+	}
+	if got := string(buf); got != "abcdxyz" {
+		t.Fatalf("ReadAt: want abcdxyz, got %s", got)
+	}
+}
+
+func TestFileStorage_Export(t *testing.T) {
+	temp := tempDir(t)
+	defer os.RemoveAll(temp)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	fs, err := OpenFile(temp, false)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer fs.Close()
+
+	table, journal := FileDesc{Type: TypeTable, Num: 1}, FileDesc{Type: TypeJournal, Num: 2}
+	for _, fd := range []FileDesc{table, journal} {
+		w, err := fs.Create(fd)
+		if err != nil {
+			t.Fatal("Create: got error: ", err)
+		}
+		fmt.Fprintf(w, "%s", fd)
+		w.Close()
+	}
+	ex := fs.(Exporter)
+	if err := ex.Link(table, dir); err != nil {
+		t.Fatal("Link: got error: ", err)
+	}
+	if err := ex.Copy(journal, dir); err != nil {
+		t.Fatal("Copy: got error: ", err)
+	}
+	if err := ex.Copy(journal, dir); !os.IsExist(err) {
+		t.Fatalf("Copy: want exist error, got %v", err)
+	}
+
+	// The copy doesn't see later writes, the link does.
+	for _, fd := range []FileDesc{table, journal} {
+		a, err := fs.Append(fd)
+		if err != nil {
+			t.Fatal("Append: got error: ", err)
+		}
+		fmt.Fprintf(a, "+")
+		a.Close()
+	}
+	for fd, want := range map[FileDesc]string{table: table.String() + "+", journal: journal.String()} {
+		b, err := os.ReadFile(filepath.Join(dir, fsGenName(fd)))
+		if err != nil {
+			t.Fatal("ReadFile: got error: ", err)
+		}
+		if string(b) != want {
+			t.Fatalf("%s: want %q, got %q", fd, want, b)
+		}
+	}
+}
+
+func TestFileStorage_Import(t *testing.T) {
+	temp := tempDir(t)
+	defer os.RemoveAll(temp)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	fs, err := OpenFile(temp, false)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer fs.Close()
+
+	path := filepath.Join(dir, "external.ldb")
+	if err := os.WriteFile(path, []byte("external"), 0644); err != nil {
+		t.Fatal("WriteFile: got error: ", err)
+	}
+	table := FileDesc{Type: TypeTable, Num: 1}
+	im := fs.(Importer)
+	if err := im.Import(path, table); err != nil {
+		t.Fatal("Import: got error: ", err)
+	}
+	if err := im.Import(path, table); !os.IsExist(err) {
+		t.Fatalf("Import: want exist error, got %v", err)
+	}
+
+	// The source may go away, the storage keeps the file.
+	os.Remove(path)
+	r, err := fs.Open(table)
+	if err != nil {
+		t.Fatal("Open: got error: ", err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil || string(b) != "external" {
+		t.Fatalf("ReadAll: want %q, got %q (%v)", "external", b, err)
+	}
+}
@@ -12,7 +12,7 @@ import (
 	"sync"
 )
 
-const typeShift = 5
+const typeShift = 8
 
 // Verify at compile-time that typeShift is large enough to cover all FileType
 // values by confirming that 0 == 0.
@@ -154,6 +154,27 @@ func (ms *memStorage) Create(fd FileDesc) (Writer, error) {
 	return &memWriter{memFile: m, ms: ms}, nil
 }
 
+func (ms *memStorage) Append(fd FileDesc) (Appender, error) {
+	if !FileDescOk(fd) {
+		return nil, ErrInvalidFile
+	}
+
+	x := packFile(fd)
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	m, exist := ms.files[x]
+	if exist {
+		if m.open {
+			return nil, errFileOpen
+		}
+	} else {
+		m = &memFile{}
+		ms.files[x] = m
+	}
+	m.open = true
+	return &memAppender{memWriter{memFile: m, ms: ms}}, nil
+}
+
 func (ms *memStorage) Remove(fd FileDesc) error {
 	if !FileDescOk(fd) {
 		return ErrInvalidFile
@@ -236,6 +257,41 @@ func (mw *memWriter) Close() error {
 	return nil
 }
 
+// memAppender locks the storage around every access, as the file may be
+// read while being appended to.
+type memAppender struct {
+	memWriter
+}
+
+func (ma *memAppender) Write(p []byte) (int, error) {
+	ma.ms.mu.Lock()
+	defer ma.ms.mu.Unlock()
+	return ma.memFile.Write(p)
+}
+
+func (ma *memAppender) ReadAt(p []byte, off int64) (int, error) {
+	ma.ms.mu.Lock()
+	defer ma.ms.mu.Unlock()
+	return bytes.NewReader(ma.memFile.Bytes()).ReadAt(p, off)
+}
+
+func (ma *memAppender) Size() (int64, error) {
+	ma.ms.mu.Lock()
+	defer ma.ms.mu.Unlock()
+	return int64(ma.memFile.Len()), nil
+}
+
+func (ma *memAppender) Truncate(size int64) error {
+	ma.ms.mu.Lock()
+	defer ma.ms.mu.Unlock()
+	if size > int64(ma.memFile.Len()) {
+		ma.memFile.Write(make([]byte, size-int64(ma.memFile.Len())))
+	} else {
+		ma.memFile.Truncate(int(size))
+	}
+	return nil
+}
+
 func packFile(fd FileDesc) uint64 {
 	return uint64(fd.Num)<<typeShift | uint64(fd.Type)
 }
@@ -115,3 +115,36 @@ func TestMemStorageRename(t *testing.T) {
 		}
 	}
 }
+
+func TestMemStorageAppend(t *testing.T) {
+	fd := FileDesc{Type: TypeAncient, Num: 1}
+
+	m := NewMemStorage()
+	a, err := m.Append(fd)
+	if err != nil {
+		t.Fatalf("Storage.Append: %v", err)
+	}
+	fmt.Fprintf(a, "abcdef")
+	if err := a.Truncate(4); err != nil {
+		t.Fatalf("Appender.Truncate: %v", err)
+	}
+	fmt.Fprintf(a, "xy")
+	a.Close()
+
+	a, err = m.Append(fd)
+	if err != nil {
+		t.Fatalf("Storage.Append: %v", err)
+	}
+	defer a.Close()
+	fmt.Fprintf(a, "z")
+	if size, _ := a.Size(); size != 7 {
+		t.Fatalf("Appender.Size: want 7, got %d", size)
+	}
+	buf := make([]byte, 7)
+	if _, err := a.ReadAt(buf, 0); err != nil {
+		t.Fatalf("Appender.ReadAt: %v", err)
+	}
+	if got := string(buf); got != "abcdxyz" {
+		t.Fatalf("Appender.ReadAt: want abcdxyz, got %s", got)
+	}
+}
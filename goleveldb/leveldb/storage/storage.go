@@ -23,8 +23,11 @@ const (
 	TypeJournals
 	TypeTable
 	TypeTemp
+	TypeAncient
+	TypeAncientIndex
+	TypeBlob
 
-	TypeAll = TypeManifest | TypeJournal | TypeJournals | TypeTable | TypeTemp
+	TypeAll = TypeManifest | TypeJournal | TypeJournals | TypeTable | TypeTemp | TypeAncient | TypeAncientIndex | TypeBlob
 )
 
 func (t FileType) String() string {
@@ -39,6 +42,12 @@ func (t FileType) String() string {
 		return "table"
 	case TypeTemp:
 		return "temp"
+	case TypeAncient:
+		return "ancient"
+	case TypeAncientIndex:
+		return "ancient-index"
+	case TypeBlob:
+		return "blob"
 	}
 	return fmt.Sprintf("<unknown:%d>", t)
 }
@@ -94,6 +103,20 @@ type Writer interface {
 	Syncer
 }
 
+// Appender is the interface of a file opened for both reading and
+// appending, see Storage.Append.
+type Appender interface {
+	io.ReaderAt
+	Writer
+
+	// Size returns the current size of the file.
+	Size() (int64, error)
+
+	// Truncate changes the size of the file. Subsequent writes go to the
+	// new end of the file.
+	Truncate(size int64) error
+}
+
 // Locker is the interface that wraps Unlock method.
 type Locker interface {
 	Unlock()
@@ -117,6 +140,12 @@ func (fd FileDesc) String() string {
 		return fmt.Sprintf("%06d.ldb", fd.Num)
 	case TypeTemp:
 		return fmt.Sprintf("%06d.tmp", fd.Num)
+	case TypeAncient:
+		return fmt.Sprintf("%06d.adat", fd.Num)
+	case TypeAncientIndex:
+		return fmt.Sprintf("%06d.aidx", fd.Num)
+	case TypeBlob:
+		return fmt.Sprintf("%06d.blob", fd.Num)
 	default:
 		return fmt.Sprintf("%#x-%d", fd.Type, fd.Num)
 	}
@@ -135,6 +164,9 @@ func FileDescOk(fd FileDesc) bool {
 	case TypeJournals:
 	case TypeTable:
 	case TypeTemp:
+	case TypeAncient:
+	case TypeAncientIndex:
+	case TypeBlob:
 	default:
 		return false
 	}
@@ -179,6 +211,11 @@ type Storage interface {
 	Create(fd FileDesc) (Writer, error)
 	Create_s(fd FileDesc) (Writer, error)
 
+	// Append opens file with the given 'file descriptor' for reading and
+	// appending, the file is created if not exist.
+	// Returns ErrClosed if the underlying storage is closed.
+	Append(fd FileDesc) (Appender, error)
+
 	// Remove removes file with the given 'file descriptor'.
 	// Returns ErrClosed if the underlying storage is closed.
 	Remove(fd FileDesc) error
@@ -192,3 +229,28 @@ type Storage interface {
 	// called after the storage has been closed.
 	Close() error
 }
+
+// Exporter is implemented by the storages able to export their files to a
+// directory of the file system, see leveldb.DB.Checkpoint.
+type Exporter interface {
+	// Link hard links the file with the given 'file descriptor' into dir,
+	// under the name it has in the storage. Dir must be on the same file
+	// system as the storage.
+	// Returns ErrClosed if the underlying storage is closed.
+	Link(fd FileDesc, dir string) error
+
+	// Copy copies the file with the given 'file descriptor' into dir, under
+	// the name it has in the storage, and syncs the copy.
+	// Returns ErrClosed if the underlying storage is closed.
+	Copy(fd FileDesc, dir string) error
+}
+
+// Importer is implemented by the storages able to adopt files of the file
+// system, see leveldb.DB.IngestExternalFiles.
+type Importer interface {
+	// Import hard links the file at path into the storage under the given
+	// 'file descriptor'. Path must be on the same file system as the
+	// storage, the file must not be modified afterwards.
+	// Returns ErrClosed if the underlying storage is closed.
+	Import(path string, fd FileDesc) error
+}
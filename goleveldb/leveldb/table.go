@@ -8,8 +8,10 @@ package leveldb
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"sort"
+	"sync"
 	"sync/atomic"
 
 	"awesomeProject1/goleveldb/leveldb/cache"
@@ -26,12 +28,20 @@ type tFile struct {
 	seekLeft   int32
 	size       int64       //sst大小
 	imin, imax internalKey //最小key和最大key
+
+	// Global sequence number of a table adopted by IngestExternalFiles, its
+	// entries are stored with sequence number zero and read with seq.
+	seq uint64
 }
 type sFile struct {
 	fd         storage.FileDesc // FileDesc is a 'file descriptor'.
 	seekLeft   int32
 	size       int64       //sst大小
 	imin, imax internalKey //最小key和最大key
+
+	// Global sequence number of a table adopted by IngestExternalFiles, its
+	// entries are stored with sequence number zero and read with seq.
+	seq uint64
 }
 
 func (t *sFile) after(icmp *iComparer, ukey []byte) bool {
@@ -112,10 +122,14 @@ func newTableFile_s(fd storage.FileDesc, size int64, imin, imax internalKey) *sF
 }
 
 func tableFileFromRecord(r atRecord) *tFile {
-	return newTableFile(storage.FileDesc{Type: storage.TypeTable, Num: r.num}, r.size, r.imin, r.imax)
+	f := newTableFile(storage.FileDesc{Type: storage.TypeTable, Num: r.num}, r.size, r.imin, r.imax)
+	f.seq = r.seq
+	return f
 }
 func tableFileFromRecord_s(r atRecord) *sFile {
-	return newTableFile_s(storage.FileDesc{Type: storage.TypeTable, Num: r.num}, r.size, r.imin, r.imax)
+	f := newTableFile_s(storage.FileDesc{Type: storage.TypeTable, Num: r.num}, r.size, r.imin, r.imax)
+	f.seq = r.seq
+	return f
 }
 
 // tFiles hold multiple tFile.
@@ -640,40 +654,63 @@ type tOps struct {
 	cache        *cache.Cache
 	bcache       *cache.Cache
 	bpool        *util.BufferPool
+
+	// Range tombstones by table number, see rangeTombstones.
+	rangeDelMu sync.Mutex
+	rangeDels  map[int64][]rangeTombstone
+
+	// Blob files of the tables not committed yet, by table number, see
+	// createBlob.
+	blobMu      sync.Mutex
+	blobPending map[int64]int64
+
+	// Buckets throttling the table writes and compaction reads of each
+	// tree, see opt.Options.RateLimiter.
+	limiter, limiter_s *rateLimiter
 }
 
-// Creates an empty table and returns table writer.
+// Creates an empty table of the given level and returns table writer.
 // 莫非这里是新建一个real & empty 的sstable并返回twriter
-func (t *tOps) create() (*tWriter, error) {
+func (t *tOps) create(level int) (*tWriter, error) {
 	fd := storage.FileDesc{Type: storage.TypeTable, Num: t.s.allocFileNum()} //得到文件类型和文件名
 	fw, err := t.s.stor.Create(fd)                                           //storage.writer
 	if err != nil {
 		return nil, err
 	}
+	fw = limitedWriter{fw, t.limiter}
+	tw := table.NewWriter(fw, t.s.o.Options) //*table.writer
+	tw.SetCompressor(t.s.o.GetCompressor(level))
 	return &tWriter{
-		t:  t,                                  //tOps
-		fd: fd,                                 //文件描述符
-		w:  fw,                                 //storage.writer
-		tw: table.NewWriter(fw, t.s.o.Options), //*table.writer
+		t:  t,  //tOps
+		fd: fd, //文件描述符
+		w:  fw, //storage.writer
+		tw: tw,
 	}, nil
 }
-func (t *tOps) create_s() (*tWriter, error) {
+func (t *tOps) create_s(level int) (*tWriter, error) {
 	fd := storage.FileDesc{Type: storage.TypeTable, Num: t.s.allocFileNum()} //得到文件类型和文件名
 	fw, err := t.s.stor.Create_s(fd)                                         //storage.writer
 	if err != nil {
 		return nil, err
 	}
+	fw = limitedWriter{fw, t.limiter_s}
+	tw := table.NewWriter(fw, t.s.o_s.Options) //*table.writer
+	tw.SetTree(opt.SecondaryTree)              // 恢复时据此放回level_s
+	tw.SetCompressor(t.s.o_s.GetCompressor(level))
 	return &tWriter{
-		t:  t,                                  //tOps
-		fd: fd,                                 //文件描述符
-		w:  fw,                                 //storage.writer
-		tw: table.NewWriter(fw, t.s.o.Options), //*table.writer
+		t:             t,  //tOps
+		fd:            fd, //文件描述符
+		w:             fw, //storage.writer
+		tw:            tw,
+		blobThreshold: t.s.o.GetBlobThreshold(),
 	}, nil
 }
 
 // Builds table from src iterator.createfrom函数的主要功能是创建新的文件，将frozenmemdb中的数据取出，然后刷新到磁盘。
-func (t *tOps) createFrom(src iterator.Iterator) (f *tFile, n int, err error) {
-	w, err := t.create() //w is type of *tWriter,封装了table writer
+// The range tombstones of rangeDels, if not nil, are added to the table. No
+// table is created if there is nothing to write.
+func (t *tOps) createFrom(src, rangeDels iterator.Iterator) (f *tFile, n int, err error) {
+	w, err := t.create(0) //w is type of *tWriter,封装了table writer
 	if err != nil {
 		return
 	}
@@ -694,13 +731,23 @@ func (t *tOps) createFrom(src iterator.Iterator) (f *tFile, n int, err error) {
 	if err != nil {
 		return
 	}
+	if rangeDels != nil {
+		if err = w.appendRangeTombstones(rangeDels); err != nil {
+			return
+		}
+	}
+	if w.empty() {
+		// Only empty range tombstones.
+		w.drop()
+		return
+	}
 
 	n = w.tw.EntriesLen() //// EntriesLen returns number of entries added so far.
 	f, err = w.finish()   //// Finalizes the table and returns table file.
 	return
 }
-func (t *tOps) createFrom_s(src iterator.Iterator) (f *sFile, n int, err error) {
-	w, err := t.create_s() //w is type of *tWriter,封装了table writer
+func (t *tOps) createFrom_s(src, rangeDels iterator.Iterator) (f *sFile, n int, err error) {
+	w, err := t.create_s(0) //w is type of *tWriter,封装了table writer
 	if err != nil {
 		return
 	}
@@ -720,6 +767,16 @@ func (t *tOps) createFrom_s(src iterator.Iterator) (f *sFile, n int, err error)
 	if err != nil {
 		return
 	}
+	if rangeDels != nil {
+		if err = w.appendRangeTombstones(rangeDels); err != nil {
+			return
+		}
+	}
+	if w.empty() {
+		// Only empty range tombstones.
+		w.drop()
+		return
+	}
 
 	n = w.tw.EntriesLen() //// EntriesLen returns number of entries added so far.
 	f, err = w.finish_s() //// Finalizes the table and returns table file.
@@ -773,7 +830,7 @@ func (t *tOps) open_s(f *sFile) (ch *cache.Handle, err error) {
 		}
 
 		var tr *table.Reader
-		tr, err = table.NewReader(r, f.size, f.fd, bcache, t.bpool, t.s.o.Options)
+		tr, err = table.NewReader(r, f.size, f.fd, bcache, t.bpool, t.s.o_s.Options)
 		if err != nil {
 			r.Close()
 			return 0, nil
@@ -795,6 +852,9 @@ func (t *tOps) find(f *tFile, key []byte, ro *opt.ReadOptions) (rkey, rvalue []b
 		return nil, nil, err
 	}
 	defer ch.Release()
+	if f.seq != 0 {
+		return t.findSeq(ch.Value().(*table.Reader), f.seq, key, false, ro)
+	}
 	return ch.Value().(*table.Reader).Find(key, true, ro)
 }
 func (t *tOps) find_s(f *sFile, key []byte, ro *opt.ReadOptions) (rkey, rvalue []byte, err error) {
@@ -803,6 +863,9 @@ func (t *tOps) find_s(f *sFile, key []byte, ro *opt.ReadOptions) (rkey, rvalue [
 		return nil, nil, err
 	}
 	defer ch.Release()
+	if f.seq != 0 {
+		return t.findSeq(ch.Value().(*table.Reader), f.seq, key, false, ro)
+	}
 	return ch.Value().(*table.Reader).Find(key, true, ro)
 }
 
@@ -813,6 +876,10 @@ func (t *tOps) findKey(f *tFile, key []byte, ro *opt.ReadOptions) (rkey []byte,
 		return nil, err
 	}
 	defer ch.Release()
+	if f.seq != 0 {
+		rkey, _, err = t.findSeq(ch.Value().(*table.Reader), f.seq, key, true, ro)
+		return
+	}
 	return ch.Value().(*table.Reader).FindKey(key, true, ro)
 }
 func (t *tOps) findKey_s(f *sFile, key []byte, ro *opt.ReadOptions) (rkey []byte, err error) {
@@ -821,6 +888,10 @@ func (t *tOps) findKey_s(f *sFile, key []byte, ro *opt.ReadOptions) (rkey []byte
 		return nil, err
 	}
 	defer ch.Release()
+	if f.seq != 0 {
+		rkey, _, err = t.findSeq(ch.Value().(*table.Reader), f.seq, key, true, ro)
+		return
+	}
 	return ch.Value().(*table.Reader).FindKey(key, true, ro)
 }
 
@@ -848,7 +919,15 @@ func (t *tOps) newIterator(f *tFile, slice *util.Range, ro *opt.ReadOptions) ite
 	if err != nil {
 		return iterator.NewEmptyIterator(err)
 	}
-	iter := ch.Value().(*table.Reader).NewIterator(slice, ro)
+	r := ch.Value().(*table.Reader)
+	if p := slicePrefix(t.s.icmp, t.s.o, slice); p != nil && !r.MayContainPrefix(slice, p) {
+		ch.Release()
+		return iterator.NewEmptyIterator(nil)
+	}
+	iter := r.NewIterator(slice, ro)
+	if f.seq != 0 {
+		iter = &seqIter{Iterator: iter, icmp: t.s.icmp, seq: f.seq}
+	}
 	iter.SetReleaser(ch)
 	return iter
 }
@@ -857,19 +936,59 @@ func (t *tOps) newIterator_s(f *sFile, slice *util.Range, ro *opt.ReadOptions) i
 	if err != nil {
 		return iterator.NewEmptyIterator(err)
 	}
-	iter := ch.Value().(*table.Reader).NewIterator(slice, ro)
+	r := ch.Value().(*table.Reader)
+	if p := slicePrefix(t.s.icmp, t.s.o_s, slice); p != nil && !r.MayContainPrefix(slice, p) {
+		ch.Release()
+		return iterator.NewEmptyIterator(nil)
+	}
+	iter := r.NewIterator(slice, ro)
+	if f.seq != 0 {
+		iter = &seqIter{Iterator: iter, icmp: t.s.icmp, seq: f.seq}
+	}
 	iter.SetReleaser(ch)
 	return iter
 }
 
+// Finds, as find or findKey if noValue, the entry of a table whose entries
+// are read with the global sequence number seq.
+func (t *tOps) findSeq(r *table.Reader, seq uint64, key []byte, noValue bool, ro *opt.ReadOptions) (rkey, rvalue []byte, err error) {
+	find := func(key []byte, filtered bool) ([]byte, []byte, error) {
+		if noValue {
+			rkey, err := r.FindKey(key, filtered, ro)
+			return rkey, nil, err
+		}
+		return r.Find(key, filtered, ro)
+	}
+	ukey, kseq, _, err := parseInternalKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rkey, rvalue, err = find(key, true); err != nil {
+		return nil, nil, err
+	}
+	if kseq < seq && sameUkey(t.s.icmp, rkey, ukey) {
+		// The entry of ukey is newer than key, find the one past it.
+		if rkey, rvalue, err = find(makeInternalKey(nil, ukey, 0, keyTypeDel), false); err != nil {
+			return nil, nil, err
+		}
+	}
+	setKeySeq(rkey, seq)
+	return rkey, rvalue, nil
+}
+
 // Removes table from persistent storage. It waits until
 // no one use the the table.
 func (t *tOps) remove(fd storage.FileDesc) {
+	t.dropBlob(fd.Num)
+	t.rangeDelMu.Lock()
+	delete(t.rangeDels, fd.Num)
+	t.rangeDelMu.Unlock()
 	t.cache.Delete(0, uint64(fd.Num), func() {
 		if err := t.s.stor.Remove(fd); err != nil {
 			t.s.logf("table@remove removing @%d %q", fd.Num, err)
 		} else {
 			t.s.logf("table@remove removed @%d", fd.Num)
+			t.s.o.GetListener().OnTableDeleted(opt.TableInfo{FileNum: fd.Num})
 		}
 		if t.evictRemoved && t.bcache != nil {
 			t.bcache.EvictNS(uint64(fd.Num))
@@ -921,6 +1040,10 @@ func newTableOps(s *session) *tOps {
 		cache:        cache.NewCache(cacher),
 		bcache:       bcache,
 		bpool:        bpool,
+		rangeDels:    make(map[int64][]rangeTombstone),
+		blobPending:  make(map[int64]int64),
+		limiter:      newRateLimiter(s.o.GetRateLimiter().GetBytesPerSec(), s.o.GetRateLimiter().GetBurst()),
+		limiter_s:    newRateLimiter(s.o_s.GetRateLimiter().GetBytesPerSec(), s.o_s.GetRateLimiter().GetBurst()),
 	}
 }
 func (s *session) SetC() {
@@ -938,11 +1061,36 @@ type tWriter struct {
 	tw *table.Writer    //内嵌的table writer
 
 	first, last []byte //sst中的最小和最大key
+
+	rangeDels []rangeTombstone
+
+	// Values longer than blobThreshold go to the blob file of the table,
+	// created on first use. Zero keeps them in the table.
+	blobThreshold int
+	blob          *blobWriter
+	bkey, bval    []byte
 }
 
 // Append key/value pair to the table.内存或者sst文件的迭代器
 // 赋值最小key和最大key，然后调用Append
 func (w *tWriter) append(key, value []byte) error {
+	if w.blobThreshold > 0 && len(value) > w.blobThreshold {
+		if ukey, seq, kt, kerr := parseInternalKey(key); kerr == nil && kt == keyTypeVal {
+			if w.blob == nil {
+				var err error
+				if w.blob, err = w.t.createBlob(w.fd.Num); err != nil {
+					return err
+				}
+			}
+			p, err := w.blob.append(ukey, value)
+			if err != nil {
+				return err
+			}
+			w.bkey = makeInternalKey(w.bkey[:0], ukey, seq, keyTypeBlob)
+			w.bval = p.encode(w.bval[:0])
+			key, value = w.bkey, w.bval
+		}
+	}
 	if w.first == nil {
 		w.first = append([]byte{}, key...)
 	}
@@ -953,6 +1101,42 @@ func (w *tWriter) append(key, value []byte) error {
 	//Append函数是关键
 }
 
+// Append the range tombstones of iter, which is released, to the table.
+func (w *tWriter) appendRangeTombstones(iter iterator.Iterator) error {
+	rts, err := readRangeTombstones(iter)
+	if err != nil {
+		return err
+	}
+	for _, rt := range rts {
+		if err := w.appendRangeTombstone(rt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Append a range tombstone to the table, after the key/value pairs. The table key range is widened to
+// cover it, up to its limit with the largest sequence number, so that the
+// tombstone reaches every lookup of the keys it deletes.
+func (w *tWriter) appendRangeTombstone(rt rangeTombstone) error {
+	icmp := w.t.s.icmp
+	if icmp.uCompare(rt.start, rt.limit) >= 0 {
+		return nil
+	}
+	ikey := rt.ikey()
+	if err := w.tw.AppendRangeTombstone(ikey, rt.limit); err != nil {
+		return err
+	}
+	if w.first == nil || icmp.Compare(ikey, w.first) < 0 {
+		w.first = ikey
+	}
+	if imax := makeInternalKey(nil, rt.limit, keyMaxSeq, keyTypeSeek); w.last == nil || icmp.Compare(imax, w.last) > 0 {
+		w.last = imax
+	}
+	w.rangeDels = append(w.rangeDels, rt)
+	return nil
+}
+
 // Returns true if the table is empty.
 func (w *tWriter) empty() bool {
 	return w.first == nil
@@ -964,6 +1148,23 @@ func (w *tWriter) close() {
 		w.w.Close()
 		w.w = nil
 	}
+	if w.blob != nil {
+		w.blob.close()
+	}
+}
+
+// Syncs and closes the blob file, if any, ahead of the table pointing to it.
+func (w *tWriter) finishBlob() error {
+	if w.blob == nil {
+		return nil
+	}
+	if !w.t.noSync {
+		if err := w.blob.w.Sync(); err != nil {
+			return err
+		}
+	}
+	w.blob.close()
+	return nil
 }
 
 // Finalizes the table and returns table file.
@@ -981,10 +1182,15 @@ func (w *tWriter) finish() (f *tFile, err error) {
 	}
 	//返回table的basic information
 	f = newTableFile(w.fd, int64(w.tw.BytesLen()), internalKey(w.first), internalKey(w.last))
+	w.t.setRangeTombstones(w.fd.Num, w.rangeDels)
+	w.t.s.o.GetListener().OnTableCreated(opt.TableInfo{Tree: opt.PrimaryTree, FileNum: w.fd.Num, Size: f.size})
 	return
 }
 func (w *tWriter) finish_s() (f *sFile, err error) {
 	defer w.close()
+	if err = w.finishBlob(); err != nil {
+		return
+	}
 	err = w.tw.Close()
 	if err != nil {
 		return
@@ -997,6 +1203,8 @@ func (w *tWriter) finish_s() (f *sFile, err error) {
 	}
 	//返回table的basic information
 	f = newTableFile_s(w.fd, int64(w.tw.BytesLen()), internalKey(w.first), internalKey(w.last))
+	w.t.setRangeTombstones(w.fd.Num, w.rangeDels)
+	w.t.s.o.GetListener().OnTableCreated(opt.TableInfo{Tree: opt.SecondaryTree, FileNum: w.fd.Num, Size: f.size})
 	return
 }
 
@@ -1004,8 +1212,59 @@ func (w *tWriter) finish_s() (f *sFile, err error) {
 func (w *tWriter) drop() {
 	w.close()
 	w.t.s.stor.Remove(w.fd)
+	w.t.dropBlob(w.fd.Num)
 	w.t.s.reuseFileNum(w.fd.Num)
 	w.tw = nil
+	w.blob = nil
 	w.first = nil
 	w.last = nil
+	w.rangeDels = nil
+}
+
+// seqIter reads the entries of a table, stored with sequence number zero,
+// with the global sequence number seq.
+type seqIter struct {
+	iterator.Iterator
+	icmp *iComparer
+	seq  uint64
+	key  []byte
+}
+
+func (i *seqIter) Seek(key []byte) bool {
+	if !i.Iterator.Seek(key) {
+		return false
+	}
+	// The stored entry of the user key of key is its first entry, yet is
+	// past key if seq is newer.
+	if ukey, kseq, _, err := parseInternalKey(key); err == nil && kseq < i.seq && sameUkey(i.icmp, i.Iterator.Key(), ukey) {
+		return i.Iterator.Next()
+	}
+	return true
+}
+
+func (i *seqIter) Key() []byte {
+	key := i.Iterator.Key()
+	if key == nil {
+		return nil
+	}
+	i.key = append(i.key[:0], key...)
+	setKeySeq(i.key, i.seq)
+	return i.key
+}
+
+// sameUkey reports whether the stored internal key ikey of a table read
+// with a global sequence number has user key ukey.
+func sameUkey(icmp *iComparer, ikey, ukey []byte) bool {
+	fukey, _, _, err := parseInternalKey(ikey)
+	return err == nil && icmp.uCompare(fukey, ukey) == 0
+}
+
+// setKeySeq replaces in place the sequence number of the internal key ikey,
+// if valid.
+func setKeySeq(ikey []byte, seq uint64) {
+	if len(ikey) < 8 {
+		return
+	}
+	num := binary.LittleEndian.Uint64(ikey[len(ikey)-8:])
+	binary.LittleEndian.PutUint64(ikey[len(ikey)-8:], seq<<8|num&0xff)
 }
@@ -18,6 +18,7 @@ import (
 
 	"awesomeProject1/goleveldb/leveldb/cache"
 	"awesomeProject1/goleveldb/leveldb/comparer"
+	"awesomeProject1/goleveldb/leveldb/compression"
 	"awesomeProject1/goleveldb/leveldb/errors"
 	"awesomeProject1/goleveldb/leveldb/filter"
 	"awesomeProject1/goleveldb/leveldb/iterator"
@@ -476,6 +477,21 @@ func (b *filterBlock) contains(filter filter.Filter, offset uint64, key []byte)
 	return true
 }
 
+func (b *filterBlock) containsPrefix(filter filter.PrefixFilter, offset uint64, prefix []byte) bool {
+	i := int(offset >> b.baseLg)
+	if i < b.filtersNum {
+		o := b.data[b.oOffset+i*4:]
+		n := int(binary.LittleEndian.Uint32(o))
+		m := int(binary.LittleEndian.Uint32(o[4:]))
+		if n < m && m <= b.oOffset {
+			return filter.ContainsPrefix(b.data[n:m], prefix)
+		} else if n == m {
+			return false
+		}
+	}
+	return true
+}
+
 func (b *filterBlock) Release() {
 	b.bpool.Put(b.data)
 	b.bpool = nil
@@ -520,6 +536,7 @@ type Reader struct {
 	cmp            comparer.Comparer //比较
 	filter         filter.Filter     //过滤器
 	verifyChecksum bool              //crc？
+	tree           opt.Tree
 
 	dataEnd                   int64
 	metaBH, indexBH, filterBH blockHandle
@@ -529,6 +546,11 @@ type Reader struct {
 
 	indexBlock  *block       //指向索引块的数据
 	filterBlock *filterBlock //指向filter块的数据
+
+	rangeDelBH    blockHandle
+	rangeDelBlock *block
+
+	propsBH blockHandle
 }
 
 func (r *Reader) blockKind(bh blockHandle) string {
@@ -541,6 +563,14 @@ func (r *Reader) blockKind(bh blockHandle) string {
 		if r.filterBH.length > 0 {
 			return "filter-block"
 		}
+	case r.rangeDelBH.offset:
+		if r.rangeDelBH.length > 0 {
+			return "rangedel-block"
+		}
+	case r.propsBH.offset:
+		if r.propsBH.length > 0 {
+			return "properties-block"
+		}
 	}
 	return "data-block"
 }
@@ -597,8 +627,17 @@ func (r *Reader) readRawBlock(bh blockHandle, verifyChecksum bool) ([]byte, erro
 		}
 		data = decData
 	default:
+		c := compression.Get(data[bh.length])
+		if c == nil {
+			r.bpool.Put(data)
+			return nil, r.newErrCorruptedBH(bh, fmt.Sprintf("unknown compression type %#x", data[bh.length]))
+		}
+		decData, err := c.Decompress(data[:bh.length])
 		r.bpool.Put(data)
-		return nil, r.newErrCorruptedBH(bh, fmt.Sprintf("unknown compression type %#x", data[bh.length]))
+		if err != nil {
+			return nil, r.newErrCorruptedBH(bh, err.Error())
+		}
+		data = decData
 	}
 	return data, nil
 }
@@ -940,6 +979,55 @@ func (r *Reader) FindKey(key []byte, filtered bool, ro *opt.ReadOptions) (rkey [
 	return
 }
 
+// MayContainPrefix returns false if the filter of the table rules out every
+// key with the given prefix within the given key range. Only the filters of
+// the data blocks overlapping the range are checked. It returns true if the
+// table has no prefix filter, see filter.NewPrefixFilter, or its index or
+// filter block can't be read.
+//
+// A nil Range.Start is treated as a key before all keys in the table.
+// And a nil Range.Limit is treated as a key after all keys in the table.
+func (r *Reader) MayContainPrefix(slice *util.Range, prefix []byte) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	pf, ok := r.filter.(filter.PrefixFilter)
+	if r.err != nil || !ok {
+		return true
+	}
+	indexBlock, rel, err := r.getIndexBlock(true)
+	if err != nil {
+		return true
+	}
+	defer rel.Release()
+	filterBlock, frel, err := r.getFilterBlock(true)
+	if err != nil {
+		return true
+	}
+	defer frel.Release()
+
+	index := r.newBlockIter(indexBlock, nil, nil, true)
+	defer index.Release()
+	var valid bool
+	if slice != nil && slice.Start != nil {
+		valid = index.Seek(slice.Start)
+	} else {
+		valid = index.First()
+	}
+	for ; valid; valid = index.Next() {
+		dataBH, n := decodeBlockHandle(index.Value())
+		if n == 0 || filterBlock.containsPrefix(pf, dataBH.offset, prefix) {
+			return true
+		}
+		// The block holds keys up to the index key, the next ones are past
+		// the limit.
+		if slice != nil && slice.Limit != nil && r.cmp.Compare(index.Key(), slice.Limit) >= 0 {
+			break
+		}
+	}
+	return index.Error() != nil
+}
+
 // Get gets the value for the given key. It returns errors.ErrNotFound
 // if the table does not contain the key.
 //
@@ -999,6 +1087,84 @@ func (r *Reader) OffsetOf(key []byte) (offset int64, err error) {
 	return
 }
 
+// Tree returns the tree of the DB the table belongs to, as recorded by
+// Writer.SetTree. Tables without the record belong to the primary tree.
+func (r *Reader) Tree() opt.Tree {
+	return r.tree
+}
+
+// NewRangeTombstoneIterator returns an iterator of the range tombstones of
+// the table, as added by Writer.AppendRangeTombstone. The keys are the
+// tombstone start keys and the values their limits, in increasing key
+// order.
+//
+// The iterator must be released after use, by calling Release method.
+func (r *Reader) NewRangeTombstoneIterator() iterator.Iterator {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.err != nil {
+		return iterator.NewEmptyIterator(r.err)
+	}
+	if r.rangeDelBlock == nil {
+		return iterator.NewEmptyIterator(nil)
+	}
+	return r.newBlockIter(r.rangeDelBlock, nil, nil, true)
+}
+
+// Properties returns the properties of the table, or nil if it has none.
+func (r *Reader) Properties() (*Properties, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.propsBH.length == 0 {
+		return nil, nil
+	}
+	b, err := r.readBlock(r.propsBH, true)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Release()
+
+	p := &Properties{}
+	iter := r.newBlockIter(b, nil, nil, true)
+	defer iter.Release()
+	for iter.Next() {
+		value := iter.Value()
+		u := func() uint64 {
+			x, n := binary.Uvarint(value)
+			if n <= 0 {
+				err = r.newErrCorruptedBH(r.propsBH, "bad property "+string(iter.Key()))
+			}
+			return x
+		}
+		switch string(iter.Key()) {
+		case propComparer:
+			p.Comparer = string(value)
+		case propDataSize:
+			p.DataSize = u()
+		case propInternalKeys:
+			p.InternalKeys = u() != 0
+		case propLargestKey:
+			p.LargestKey = append([]byte{}, value...)
+		case propNumEntries:
+			p.NumEntries = u()
+		case propSmallestKey:
+			p.SmallestKey = append([]byte{}, value...)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
 // Release implements util.Releaser.
 // It also close the file if it is an io.Closer.
 func (r *Reader) Release() {
@@ -1016,6 +1182,10 @@ func (r *Reader) Release() {
 		r.filterBlock.Release()
 		r.filterBlock = nil
 	}
+	if r.rangeDelBlock != nil {
+		r.rangeDelBlock.Release()
+		r.rangeDelBlock = nil
+	}
 	r.reader = nil
 	r.cache = nil
 	r.bpool = nil
@@ -1088,7 +1258,28 @@ func NewReader(f io.ReaderAt, size int64, fd storage.FileDesc, cache *cache.Name
 	metaIter := r.newBlockIter(metaBlock, nil, nil, true)
 	for metaIter.Next() {
 		key := string(metaIter.Key())
-		if !strings.HasPrefix(key, "filter.") {
+		if key == treeMetaKey {
+			if v := metaIter.Value(); len(v) == 1 {
+				r.tree = opt.Tree(v[0])
+			}
+			continue
+		}
+		if key == rangeDelMetaKey {
+			if bh, n := decodeBlockHandle(metaIter.Value()); n > 0 {
+				r.rangeDelBH = bh
+			}
+			continue
+		}
+		if key == propertiesMetaKey {
+			if bh, n := decodeBlockHandle(metaIter.Value()); n > 0 {
+				r.propsBH = bh
+				if int64(bh.offset) < r.dataEnd {
+					r.dataEnd = int64(bh.offset)
+				}
+			}
+			continue
+		}
+		if r.filter != nil || !strings.HasPrefix(key, "filter.") {
 			continue
 		}
 		fn := key[7:]
@@ -1110,12 +1301,26 @@ func NewReader(f io.ReaderAt, size int64, fd storage.FileDesc, cache *cache.Name
 			r.filterBH = filterBH
 			// Update data end.
 			r.dataEnd = int64(filterBH.offset)
-			break
 		}
 	}
 	metaIter.Release()
 	metaBlock.Release()
 
+	// The range tombstones are consulted on every lookup, keep them loaded.
+	if r.rangeDelBH.length > 0 {
+		if int64(r.rangeDelBH.offset) < r.dataEnd {
+			r.dataEnd = int64(r.rangeDelBH.offset)
+		}
+		r.rangeDelBlock, err = r.readBlock(r.rangeDelBH, true)
+		if err != nil {
+			if errors.IsCorrupted(err) {
+				r.err = err
+				return r, nil
+			}
+			return nil, err
+		}
+	}
+
 	// Cache index and filter block locally, since we don't have global cache.
 	if cache == nil {
 		r.indexBlock, err = r.readBlock(r.indexBH, true)
@@ -17,19 +17,23 @@ Table:
 Table is consist of one or more data blocks, an optional filter block
 a metaindex block, an index block and a table footer. Metaindex block
 is a special block used to keep parameters of the table, such as filter
-block name and its block handle. Index block is a special block used to
+block name and its block handle, or the tree of a secondary tree table. Index block is a special block used to
 keep record of data blocks offset and length, index block use one as
 restart interval. The key used by index block are the last key of preceding
 block, shorter separator of adjacent blocks or shorter successor of the
 last key of the last block. Filter block is an optional block contains
-sequence of filter data generated by a filter generator.
+sequence of filter data generated by a filter generator. Range tombstone
+block is an optional block, with the same layout as a data block, that maps
+the start internal key of each range tombstone to its exclusive limit.
+Properties block is an optional block, with the same layout as a data block,
+that maps the property names to their values, see Properties.
 
 Table data structure:
-                                                         + optional
-                                                        /
-    +--------------+--------------+--------------+------+-------+-----------------+-------------+--------+
-    | data block 1 |      ...     | data block n | filter block | metaindex block | index block | footer |
-    +--------------+--------------+--------------+--------------+-----------------+-------------+--------+
+                                                         + optional     + optional              + optional
+                                                        /              /                       /
+    +--------------+--------------+--------------+------+-------+------+---------------+-------+----------+-----------------+-------------+--------+
+    | data block 1 |      ...     | data block n | filter block | range tombstone block | properties block | metaindex block | index block | footer |
+    +--------------+--------------+--------------+--------------+-----------------------+------------------+-----------------+-------------+--------+
 
     Each block followed by a 5-bytes trailer contains compression type and checksum.
 
@@ -147,6 +151,25 @@ const (
 
 	magic = "\x57\xfb\x80\x8b\x24\x75\x47\xdb" //magic number?
 
+	// treeMetaKey is the metaindex key of the tree the table belongs to, its
+	// value is the opt.Tree byte. It is only written for the secondary tree.
+	treeMetaKey = "leveldb.tree"
+
+	// rangeDelMetaKey is the metaindex key of the range tombstone block
+	// handle.
+	rangeDelMetaKey = "leveldb.rangedel"
+
+	// propertiesMetaKey is the metaindex key of the properties block handle.
+	propertiesMetaKey = "leveldb.properties"
+
+	// Names of the properties, in the order of the properties block.
+	propComparer     = "leveldb.comparer"
+	propDataSize     = "leveldb.data.size"
+	propInternalKeys = "leveldb.internal.keys"
+	propLargestKey   = "leveldb.largest.key"
+	propNumEntries   = "leveldb.num.entries"
+	propSmallestKey  = "leveldb.smallest.key"
+
 	// The block type gives the per-block compression format.
 	// These constants are part of the file format and should not be changed.
 	blockTypeNoCompression     = 0
@@ -157,6 +180,22 @@ const (
 	filterBase   = 1 << filterBaseLg
 )
 
+// Properties describes the content of a table. They are only written for
+// tables given them with Writer.SetProperties.
+type Properties struct {
+	NumEntries  uint64 // Number of key/value entries
+	DataSize    uint64 // Sum of the key and value lengths
+	SmallestKey []byte
+	LargestKey  []byte
+
+	// Comparer is the name of the comparer of the keys, if known.
+	Comparer string
+
+	// InternalKeys is set if the keys of the table are internal keys of a
+	// DB, SmallestKey and LargestKey being user keys.
+	InternalKeys bool
+}
+
 type blockHandle struct {
 	offset, length uint64 //偏移量和长度
 }
@@ -8,10 +8,15 @@ package table
 
 import (
 	"bytes"
+	"compress/flate"
+	"fmt"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"awesomeProject1/goleveldb/leveldb/compression"
+	"awesomeProject1/goleveldb/leveldb/errors"
+	"awesomeProject1/goleveldb/leveldb/filter"
 	"awesomeProject1/goleveldb/leveldb/iterator"
 	"awesomeProject1/goleveldb/leveldb/opt"
 	"awesomeProject1/goleveldb/leveldb/storage"
@@ -23,6 +28,21 @@ type tableWrapper struct {
 	*Reader
 }
 
+// unregisteredCompressor stores the blocks as is under a block type no
+// compressor is registered for.
+type unregisteredCompressor struct{}
+
+func (unregisteredCompressor) Type() byte   { return 0xff }
+func (unregisteredCompressor) Name() string { return "unregistered" }
+
+func (unregisteredCompressor) Compress(dst, src []byte) ([]byte, error) {
+	return append(dst[:0], src...), nil
+}
+
+func (unregisteredCompressor) Decompress(src []byte) ([]byte, error) {
+	return src, nil
+}
+
 func (t tableWrapper) TestFind(key []byte) (rkey, rvalue []byte, err error) {
 	return t.Reader.Find(key, false, nil)
 }
@@ -81,6 +101,126 @@ var _ = testutil.Defer(func() {
 			})
 		})
 
+		Describe("tree test", func() {
+			Build := func(tree opt.Tree, o *opt.Options) *Reader {
+				buf := &bytes.Buffer{}
+				tw := NewWriter(buf, o)
+				tw.SetTree(tree)
+				tw.Append([]byte("k01"), []byte("hello"))
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+
+				tr, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), storage.FileDesc{}, nil, nil, o)
+				Expect(err).ShouldNot(HaveOccurred())
+				return tr
+			}
+
+			It("Should default to the primary tree", func() {
+				Expect(Build(opt.PrimaryTree, nil).Tree()).Should(Equal(opt.PrimaryTree))
+			})
+
+			It("Should record the secondary tree", func() {
+				tr := Build(opt.SecondaryTree, nil)
+				Expect(tr.Tree()).Should(Equal(opt.SecondaryTree))
+				value, err := tr.Get([]byte("k01"), nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(value).Should(Equal([]byte("hello")))
+			})
+
+			It("Should keep the filter of a secondary tree table", func() {
+				o := &opt.Options{Filter: filter.NewBloomFilter(10)}
+				tr := Build(opt.SecondaryTree, o)
+				Expect(tr.Tree()).Should(Equal(opt.SecondaryTree))
+				Expect(tr.filter).ShouldNot(BeNil())
+			})
+		})
+
+		Describe("properties test", func() {
+			Build := func(props *Properties, o *opt.Options) *Reader {
+				buf := &bytes.Buffer{}
+				tw := NewWriter(buf, o)
+				tw.Append([]byte("k01"), []byte("hello"))
+				tw.Append([]byte("k02"), []byte("world"))
+				if props != nil {
+					tw.SetProperties(props)
+				}
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+
+				tr, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), storage.FileDesc{}, nil, nil, o)
+				Expect(err).ShouldNot(HaveOccurred())
+				return tr
+			}
+
+			It("Should have no properties by default", func() {
+				props, err := Build(nil, nil).Properties()
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(props).Should(BeNil())
+			})
+
+			It("Should read back the properties", func() {
+				want := &Properties{
+					NumEntries:   2,
+					DataSize:     16,
+					SmallestKey:  []byte("k01"),
+					LargestKey:   []byte("k02"),
+					Comparer:     "leveldb.BytewiseComparator",
+					InternalKeys: true,
+				}
+				tr := Build(want, &opt.Options{Filter: filter.NewBloomFilter(10)})
+				props, err := tr.Properties()
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(props).Should(Equal(want))
+				value, err := tr.Get([]byte("k02"), nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(value).Should(Equal([]byte("world")))
+			})
+		})
+
+		Describe("compressor test", func() {
+			It("Should read tables mixing block compressors", func() {
+				o := &opt.Options{
+					BlockSize:  64,
+					Compressor: compression.NewFlate(flate.BestSpeed),
+				}
+				buf := &bytes.Buffer{}
+				tw := NewWriter(buf, o)
+				value := bytes.Repeat([]byte("v"), 100)
+				for i := 0; i < 100; i++ {
+					switch i {
+					case 40:
+						tw.SetCompressor(compression.Snappy)
+					case 70:
+						tw.SetCompressor(nil)
+					}
+					Expect(tw.Append([]byte(fmt.Sprintf("k%03d", i)), value)).ShouldNot(HaveOccurred())
+				}
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+
+				tr, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), storage.FileDesc{}, nil, nil, o)
+				Expect(err).ShouldNot(HaveOccurred())
+				iter := tr.NewIterator(nil, nil)
+				defer iter.Release()
+				n := 0
+				for ; iter.Next(); n++ {
+					Expect(iter.Key()).Should(Equal([]byte(fmt.Sprintf("k%03d", n))))
+					Expect(iter.Value()).Should(Equal(value))
+				}
+				Expect(iter.Error()).ShouldNot(HaveOccurred())
+				Expect(n).Should(Equal(100))
+			})
+
+			It("Should fail on unregistered block types", func() {
+				buf := &bytes.Buffer{}
+				tw := NewWriter(buf, &opt.Options{Compressor: unregisteredCompressor{}})
+				tw.Append([]byte("k01"), []byte("hello"))
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+
+				tr, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), storage.FileDesc{}, nil, nil, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				_, err = tr.Get([]byte("k01"), nil)
+				Expect(errors.IsCorrupted(err)).Should(BeTrue())
+			})
+		})
+
 		Describe("read test", func() {
 			Build := func(kv testutil.KeyValue) testutil.DB {
 				o := &opt.Options{
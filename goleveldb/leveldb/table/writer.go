@@ -11,10 +11,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
-
-	"github.com/golang/snappy"
+	"sort"
 
 	"awesomeProject1/goleveldb/leveldb/comparer"
+	"awesomeProject1/goleveldb/leveldb/compression"
 	"awesomeProject1/goleveldb/leveldb/filter"
 	"awesomeProject1/goleveldb/leveldb/opt"
 	"awesomeProject1/goleveldb/leveldb/util"
@@ -135,19 +135,26 @@ func (w *filterWriter) generate() {
 	}
 }
 
+type rangeTombstone struct {
+	key, limit []byte
+}
+
 // Writer is a table writer.
 type Writer struct { //这貌似就是一个table的结构
 	writer io.Writer
 	err    error
 	// Options
-	cmp         comparer.Comparer
-	filter      filter.Filter
-	compression opt.Compression
-	blockSize   int
+	cmp        comparer.Comparer
+	filter     filter.Filter
+	compressor compression.Compressor
+	blockSize  int
+	tree       opt.Tree
 
 	dataBlock   blockWriter
 	indexBlock  blockWriter
 	filterBlock filterWriter
+	rangeDels   []rangeTombstone
+	props       *Properties
 	pendingBH   blockHandle
 	offset      uint64
 	nEntries    int
@@ -159,18 +166,17 @@ type Writer struct { //这貌似就是一个table的结构
 	compressionScratch []byte
 }
 
-func (w *Writer) writeBlock(buf *util.Buffer, compression opt.Compression) (bh blockHandle, err error) {
+func (w *Writer) writeBlock(buf *util.Buffer, c compression.Compressor) (bh blockHandle, err error) {
 	// Compress the buffer if necessary.
 	var b []byte
-	if compression == opt.SnappyCompression {
-		// Allocate scratch enough for compression and block trailer.
-		if n := snappy.MaxEncodedLen(buf.Len()) + blockTrailerLen; len(w.compressionScratch) < n {
-			w.compressionScratch = make([]byte, n)
+	if c != nil && c.Type() != compression.NoneType {
+		b, err = c.Compress(w.compressionScratch, buf.Bytes())
+		if err != nil {
+			return
 		}
-		compressed := snappy.Encode(w.compressionScratch, buf.Bytes())
-		n := len(compressed)
-		b = compressed[:n+blockTrailerLen]
-		b[n] = blockTypeSnappyCompression
+		// Append the block trailer, the checksum is filled below.
+		b = append(b, c.Type(), 0, 0, 0, 0)
+		w.compressionScratch = b
 	} else {
 		tmp := buf.Alloc(blockTrailerLen)
 		tmp[0] = blockTypeNoCompression
@@ -197,9 +203,12 @@ func (w *Writer) flushPendingBH(key []byte) {
 		return
 	}
 	var separator []byte
-	if len(key) == 0 {
+	switch {
+	case len(w.dataBlock.prevKey) == 0:
+		// Empty data block of a table holding only range tombstones.
+	case len(key) == 0:
 		separator = w.cmp.Successor(w.comparerScratch[:0], w.dataBlock.prevKey)
-	} else {
+	default:
 		separator = w.cmp.Separator(w.comparerScratch[:0], w.dataBlock.prevKey, key)
 	}
 	if separator == nil {
@@ -218,7 +227,7 @@ func (w *Writer) flushPendingBH(key []byte) {
 
 func (w *Writer) finishBlock() error {
 	w.dataBlock.finish()
-	bh, err := w.writeBlock(&w.dataBlock.buf, w.compression)
+	bh, err := w.writeBlock(&w.dataBlock.buf, w.compressor)
 	if err != nil {
 		return err
 	}
@@ -260,6 +269,29 @@ func (w *Writer) Append(key, value []byte) error {
 	return nil
 }
 
+// AppendRangeTombstone adds a range tombstone to the table, covering the
+// keys from key, inclusive, up to limit, exclusive. Unlike Append the
+// tombstones may be added in any order, they are sorted by key when the
+// table is closed.
+//
+// It is safe to modify the contents of the arguments after
+// AppendRangeTombstone returns.
+func (w *Writer) AppendRangeTombstone(key, limit []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.rangeDels = append(w.rangeDels, rangeTombstone{
+		key:   append([]byte(nil), key...),
+		limit: append([]byte(nil), limit...),
+	})
+	return nil
+}
+
+// RangeTombstonesLen returns number of range tombstones added so far.
+func (w *Writer) RangeTombstonesLen() int {
+	return len(w.rangeDels)
+}
+
 // BlocksLen returns number of blocks written so far.
 func (w *Writer) BlocksLen() int {
 	n := w.indexBlock.nEntries
@@ -280,6 +312,50 @@ func (w *Writer) BytesLen() int {
 	return int(w.offset)
 }
 
+// SetTree sets the tree of the DB the table belongs to. Tables of the
+// secondary tree record it in the metaindex block, so that a DB recovery
+// can put them back into that tree. It must be called before Close.
+func (w *Writer) SetTree(tree opt.Tree) {
+	w.tree = tree
+}
+
+// SetProperties sets the properties written to the table. It must be called
+// before Close.
+func (w *Writer) SetProperties(p *Properties) {
+	w.props = p
+}
+
+// SetCompressor sets the compressor of the blocks written after, the filter
+// block excepted. A nil compressor stores the blocks as is.
+func (w *Writer) SetCompressor(c compression.Compressor) {
+	w.compressor = c
+}
+
+func (w *Writer) writeProperties() (bh blockHandle, err error) {
+	// Like block handles, the values are encoded in the first 20-bytes of
+	// the scratch, which the block writer doesn't use.
+	u := func(x uint64) []byte {
+		n := binary.PutUvarint(w.scratch[:20], x)
+		return w.scratch[:n]
+	}
+	var internalKeys uint64
+	if w.props.InternalKeys {
+		internalKeys = 1
+	}
+	if w.props.Comparer != "" {
+		w.dataBlock.append([]byte(propComparer), []byte(w.props.Comparer))
+	}
+	w.dataBlock.append([]byte(propDataSize), u(w.props.DataSize))
+	w.dataBlock.append([]byte(propInternalKeys), u(internalKeys))
+	w.dataBlock.append([]byte(propLargestKey), w.props.LargestKey)
+	w.dataBlock.append([]byte(propNumEntries), u(w.props.NumEntries))
+	w.dataBlock.append([]byte(propSmallestKey), w.props.SmallestKey)
+	w.dataBlock.finish()
+	bh, err = w.writeBlock(&w.dataBlock.buf, w.compressor)
+	w.dataBlock.reset()
+	return
+}
+
 // Close will finalize the table. Calling Append is not possible
 // after Close, but calling BlocksLen, EntriesLen and BytesLen
 // is still possible.
@@ -302,7 +378,33 @@ func (w *Writer) Close() error {
 	var filterBH blockHandle
 	w.filterBlock.finish()
 	if buf := &w.filterBlock.buf; buf.Len() > 0 {
-		filterBH, w.err = w.writeBlock(buf, opt.NoCompression)
+		filterBH, w.err = w.writeBlock(buf, nil)
+		if w.err != nil {
+			return w.err
+		}
+	}
+
+	// Write the range tombstone block.
+	var rangeDelBH blockHandle
+	if len(w.rangeDels) > 0 {
+		sort.Slice(w.rangeDels, func(i, j int) bool {
+			return w.cmp.Compare(w.rangeDels[i].key, w.rangeDels[j].key) < 0
+		})
+		for _, rd := range w.rangeDels {
+			w.dataBlock.append(rd.key, rd.limit)
+		}
+		w.dataBlock.finish()
+		rangeDelBH, w.err = w.writeBlock(&w.dataBlock.buf, w.compressor)
+		if w.err != nil {
+			return w.err
+		}
+		w.dataBlock.reset()
+	}
+
+	// Write the properties block.
+	var propsBH blockHandle
+	if w.props != nil {
+		propsBH, w.err = w.writeProperties()
 		if w.err != nil {
 			return w.err
 		}
@@ -314,8 +416,19 @@ func (w *Writer) Close() error {
 		n := encodeBlockHandle(w.scratch[:20], filterBH)
 		w.dataBlock.append(key, w.scratch[:n])
 	}
+	if propsBH.length > 0 {
+		n := encodeBlockHandle(w.scratch[:20], propsBH)
+		w.dataBlock.append([]byte(propertiesMetaKey), w.scratch[:n])
+	}
+	if rangeDelBH.length > 0 {
+		n := encodeBlockHandle(w.scratch[:20], rangeDelBH)
+		w.dataBlock.append([]byte(rangeDelMetaKey), w.scratch[:n])
+	}
+	if w.tree != opt.PrimaryTree {
+		w.dataBlock.append([]byte(treeMetaKey), []byte{byte(w.tree)})
+	}
 	w.dataBlock.finish()
-	metaindexBH, err := w.writeBlock(&w.dataBlock.buf, w.compression)
+	metaindexBH, err := w.writeBlock(&w.dataBlock.buf, w.compressor)
 	if err != nil {
 		w.err = err
 		return w.err
@@ -323,7 +436,7 @@ func (w *Writer) Close() error {
 
 	// Write the index block.
 	w.indexBlock.finish()
-	indexBH, err := w.writeBlock(&w.indexBlock.buf, w.compression)
+	indexBH, err := w.writeBlock(&w.indexBlock.buf, w.compressor)
 	if err != nil {
 		w.err = err
 		return w.err
@@ -355,7 +468,7 @@ func NewWriter(f io.Writer, o *opt.Options) *Writer {
 		writer:          f,
 		cmp:             o.GetComparer(),
 		filter:          o.GetFilter(),
-		compression:     o.GetCompression(),
+		compressor:      o.GetCompressor(0),
 		blockSize:       o.GetBlockSize(),
 		comparerScratch: make([]byte, 0),
 	}
@@ -61,6 +61,9 @@ const (
 	typeJournals
 	typeTable
 	typeTemp
+	typeAncient
+	typeAncientIndex
+	typeBlob
 
 	typeCount
 )
@@ -101,6 +104,12 @@ func flattenType(m StorageMode, t storage.FileType) int {
 		return x + typeTable
 	case storage.TypeTemp:
 		return x + typeTemp
+	case storage.TypeAncient:
+		return x + typeAncient
+	case storage.TypeAncientIndex:
+		return x + typeAncientIndex
+	case storage.TypeBlob:
+		return x + typeBlob
 	default:
 		panic("invalid file type")
 	}
@@ -121,6 +130,12 @@ func listFlattenType(m StorageMode, t storage.FileType) []int {
 			ret = append(ret, x+typeTable)
 		case t&storage.TypeTemp != 0:
 			ret = append(ret, x+typeTemp)
+		case t&storage.TypeAncient != 0:
+			ret = append(ret, x+typeAncient)
+		case t&storage.TypeAncientIndex != 0:
+			ret = append(ret, x+typeAncientIndex)
+		case t&storage.TypeBlob != 0:
+			ret = append(ret, x+typeBlob)
 		}
 	}
 	switch {
@@ -248,6 +263,32 @@ func (w *writer) Close() (err error) {
 	return w.s.fileClose(w.fd, w.Writer)
 }
 
+type appender struct {
+	writer
+	a storage.Appender
+}
+
+func (a *appender) ReadAt(p []byte, off int64) (n int, err error) {
+	err = a.s.emulateError(ModeRead, a.fd.Type)
+	if err == nil {
+		a.s.stall(ModeRead, a.fd.Type)
+		n, err = a.a.ReadAt(p, off)
+	}
+	a.s.count(ModeRead, a.fd.Type, n)
+	if err != nil && err != io.EOF {
+		a.s.logI("readAt error, fd=%s offset=%d n=%d err=%v", a.fd, off, n, err)
+	}
+	return
+}
+
+func (a *appender) Size() (int64, error) {
+	return a.a.Size()
+}
+
+func (a *appender) Truncate(size int64) error {
+	return a.a.Truncate(size)
+}
+
 type Storage struct { //Storage的实现类
 	storage.Storage
 	path    string
@@ -455,6 +496,28 @@ func (s *Storage) Create(fd storage.FileDesc) (w storage.Writer, err error) {
 	return
 }
 
+func (s *Storage) Append(fd storage.FileDesc) (a storage.Appender, err error) {
+	err = s.emulateError(ModeCreate, fd.Type)
+	if err == nil {
+		s.stall(ModeCreate, fd.Type)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.assertOpen(fd)
+		s.countNB(ModeCreate, fd.Type, 0)
+		a, err = s.Storage.Append(fd)
+	}
+	if err != nil {
+		s.logI("file append failed, fd=%s err=%v", fd, err)
+	} else {
+		s.logI("file opened for append, fd=%s", fd)
+		s.opens[packFile(fd)] = true
+		a = &appender{writer{s, fd, a}, a}
+	}
+	return
+}
+
 func (s *Storage) Remove(fd storage.FileDesc) (err error) {
 	err = s.emulateError(ModeRemove, fd.Type)
 	if err == nil {
@@ -516,6 +579,45 @@ func (s *Storage) ForceRename(oldfd, newfd storage.FileDesc) (err error) {
 	return
 }
 
+func (s *Storage) Link(fd storage.FileDesc, dir string) (err error) {
+	ex, ok := s.Storage.(storage.Exporter)
+	if !ok {
+		return fmt.Errorf("storage %T can't export files", s.Storage)
+	}
+	if err = ex.Link(fd, dir); err != nil {
+		s.logI("file link failed, fd=%s dir=%s err=%v", fd, dir, err)
+	} else {
+		s.logI("file linked, fd=%s dir=%s", fd, dir)
+	}
+	return
+}
+
+func (s *Storage) Copy(fd storage.FileDesc, dir string) (err error) {
+	ex, ok := s.Storage.(storage.Exporter)
+	if !ok {
+		return fmt.Errorf("storage %T can't export files", s.Storage)
+	}
+	if err = ex.Copy(fd, dir); err != nil {
+		s.logI("file copy failed, fd=%s dir=%s err=%v", fd, dir, err)
+	} else {
+		s.logI("file copied, fd=%s dir=%s", fd, dir)
+	}
+	return
+}
+
+func (s *Storage) Import(path string, fd storage.FileDesc) (err error) {
+	im, ok := s.Storage.(storage.Importer)
+	if !ok {
+		return fmt.Errorf("storage %T can't import files", s.Storage)
+	}
+	if err = im.Import(path, fd); err != nil {
+		s.logI("file import failed, path=%s fd=%s err=%v", path, fd, err)
+	} else {
+		s.logI("file imported, path=%s fd=%s", path, fd)
+	}
+	return
+}
+
 func (s *Storage) openFiles() string {
 	out := "Open files:"
 	for x, writer := range s.opens {
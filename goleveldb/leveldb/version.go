@@ -206,7 +206,12 @@ func (v *version) walkOverlapping_s(aux sFiles, ikey internalKey, f func(level i
 //2.读取文件，找到ikey和ivalue
 //3.如果当前在L0找到，根据f seq取最新的数据
 
-func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue bool) (value []byte, tcomp bool, err error) {
+// get looks up ikey in the tables. rseq is the sequence number of the newest
+// range tombstone covering the key in the memdbs, entries older than it or
+// than a covering tombstone of the tables are deleted. Since tables of a
+// deeper level hold older entries, the walk stops at the level below the
+// newest covering tombstone.
+func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue bool, rseq uint64) (value []byte, tcomp bool, err error) {
 	if v.closing {
 		return nil, false, ErrClosed
 	}
@@ -223,12 +228,20 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 		zseq   uint64
 		zkt    keyType //插入还是删除？
 		zval   []byte
+
+		// Level of the newest covering range tombstone.
+		rlevel = -1
 	)
+	seq, _ := ikey.parseNum()
 
 	err = ErrNotFound
 	// Since entries never hop across level, finding key/value
 	// in smaller level make later levels irrelevant. walkoverlapping 是用来定位ikey位于哪个文件中的
 	v.walkOverlapping(aux, ikey, func(level int, t *tFile) bool { //把func作为参数
+		if rseq > 0 && level > rlevel {
+			// Deleted by a range tombstone of a newer level.
+			return false
+		}
 		if sampleSeeks && level >= 0 && !tseek { //s为true，level为0
 			if tset == nil {
 				tset = &tSet{level, t} //0，tfile
@@ -237,6 +250,13 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 			}
 		}
 
+		if tseq, terr := v.s.tops.rangeTombstoneSeq(t, ukey, seq); terr != nil {
+			err = terr
+			return false
+		} else if tseq > rseq {
+			rseq, rlevel = tseq, level
+		}
+
 		var (
 			fikey, fval []byte //找到的kv键值对?
 			ferr        error
@@ -266,11 +286,14 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 						zval = fval
 					}
 				} else {
-					switch fkt {
-					case keyTypeVal:
+					switch {
+					case fseq < rseq:
+					case fkt == keyTypeVal:
 						value = fval
 						err = nil
-					case keyTypeDel:
+					case fkt == keyTypeDel:
+					case fkt == keyTypeMerge:
+						err = errMergeOperand
 					default:
 						panic("leveldb: invalid internalKey type")
 					}
@@ -285,11 +308,14 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 		return true
 	}, func(level int) bool {
 		if zfound {
-			switch zkt {
-			case keyTypeVal:
+			switch {
+			case zseq < rseq:
+			case zkt == keyTypeVal:
 				value = zval
 				err = nil
-			case keyTypeDel:
+			case zkt == keyTypeDel:
+			case zkt == keyTypeMerge:
+				err = errMergeOperand
 			default:
 				panic("leveldb: invalid internalKey type")
 			}
@@ -306,10 +332,20 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 	return
 }
 
-func (v *version) get_s(aux sFiles, ikey internalKey, ro *opt.ReadOptions, noValue bool) (value []byte, tcomp bool, err error) {
+func (v *version) get_s(aux sFiles, ikey internalKey, ro *opt.ReadOptions, noValue bool, rseq uint64) (value []byte, tcomp bool, err error) {
+	value, blob, tcomp, err := v.getRaw_s(aux, ikey, ro, noValue, rseq)
+	if err == nil && blob && !noValue {
+		value, err = v.s.tops.readBlob(value)
+	}
+	return
+}
+
+// getRaw_s is get_s, leaving the values moved to blob files as the pointers
+// of their entries, blob is true then.
+func (v *version) getRaw_s(aux sFiles, ikey internalKey, ro *opt.ReadOptions, noValue bool, rseq uint64) (value []byte, blob, tcomp bool, err error) {
 	//aux nil
 	if v.closing {
-		return nil, false, ErrClosed
+		return nil, false, false, ErrClosed
 	}
 	//根据internalKey获得userKey
 	ukey := ikey.ukey()
@@ -323,12 +359,19 @@ func (v *version) get_s(aux sFiles, ikey internalKey, ro *opt.ReadOptions, noVal
 		zseq   uint64
 		zkt    keyType
 		zval   []byte
+
+		rlevel = -1
 	)
+	seq, _ := ikey.parseNum()
 
 	err = ErrNotFound
 	// Since entries never hop across level, finding key/value
 	// in smaller level make later levels irrelevant.意思是从上往下找
 	v.walkOverlapping_s(aux, ikey, func(level int, t *sFile) bool {
+		if rseq > 0 && level > rlevel {
+			// Deleted by a range tombstone of a newer level.
+			return false
+		}
 		if sampleSeeks && level >= 0 && !tseek {
 			if tset == nil {
 				tset = &tSet_s{level, t}
@@ -337,6 +380,13 @@ func (v *version) get_s(aux sFiles, ikey internalKey, ro *opt.ReadOptions, noVal
 			}
 		}
 
+		if tseq, terr := v.s.tops.rangeTombstoneSeq_s(t, ukey, seq); terr != nil {
+			err = terr
+			return false
+		} else if tseq > rseq {
+			rseq, rlevel = tseq, level
+		}
+
 		var (
 			fikey, fval []byte
 			ferr        error
@@ -366,11 +416,13 @@ func (v *version) get_s(aux sFiles, ikey internalKey, ro *opt.ReadOptions, noVal
 						zval = fval
 					}
 				} else {
-					switch fkt {
-					case keyTypeVal:
+					switch {
+					case fseq < rseq:
+					case fkt == keyTypeVal, fkt == keyTypeBlob:
 						value = fval
+						blob = fkt == keyTypeBlob
 						err = nil
-					case keyTypeDel:
+					case fkt == keyTypeDel:
 					default:
 						panic("leveldb: invalid internalKey type")
 					}
@@ -385,11 +437,13 @@ func (v *version) get_s(aux sFiles, ikey internalKey, ro *opt.ReadOptions, noVal
 		return true
 	}, func(level int) bool {
 		if zfound {
-			switch zkt {
-			case keyTypeVal:
+			switch {
+			case zseq < rseq:
+			case zkt == keyTypeVal, zkt == keyTypeBlob:
 				value = zval
+				blob = zkt == keyTypeBlob
 				err = nil
-			case keyTypeDel:
+			case zkt == keyTypeDel:
 			default:
 				panic("leveldb: invalid internalKey type")
 			}
@@ -588,7 +642,7 @@ func (v *version) pickMemdbLevel_s(umin, umax []byte, maxLevel int) (level int)
 				}
 				if gpLevel := level + 2; gpLevel < len(v.level_s) {
 					overlaps = v.level_s[gpLevel].getOverlaps(overlaps, v.s.icmp, umin, umax, false)
-					if overlaps.size() > int64(v.s.o.GetCompactionGPOverlaps(level)) {
+					if overlaps.size() > int64(v.s.o_s.GetCompactionGPOverlaps(level)) {
 						break
 					}
 				}
@@ -597,6 +651,32 @@ func (v *version) pickMemdbLevel_s(umin, umax []byte, maxLevel int) (level int)
 	}
 	return
 }
+
+// pickIngestLevel returns the deepest level a table of entries newer than
+// the whole tree may be added to: none of the keys from umin to umax may be
+// at that level or above. It is maxLevel if no level holds them.
+func (v *version) pickIngestLevel(umin, umax []byte, maxLevel int) (level int) {
+	if len(v.levels) > 0 && v.levels[0].overlaps(v.s.icmp, umin, umax, true) {
+		return 0
+	}
+	for ; level < maxLevel; level++ {
+		if pLevel := level + 1; pLevel < len(v.levels) && v.levels[pLevel].overlaps(v.s.icmp, umin, umax, false) {
+			break
+		}
+	}
+	return
+}
+func (v *version) pickIngestLevel_s(umin, umax []byte, maxLevel int) (level int) {
+	if len(v.level_s) > 0 && v.level_s[0].overlaps(v.s.icmp, umin, umax, true) {
+		return 0
+	}
+	for ; level < maxLevel; level++ {
+		if pLevel := level + 1; pLevel < len(v.level_s) && v.level_s[pLevel].overlaps(v.s.icmp, umin, umax, false) {
+			break
+		}
+	}
+	return
+}
 func (v *version) computeCompaction() {
 	// Precomputed best level for next compaction
 	bestLevel := int(-1)
@@ -668,9 +748,9 @@ func (v *version) computeCompaction_s() {
 			// file size is small (perhaps because of a small write-buffer
 			// setting, or very high compression ratios, or lots of
 			// overwrites/deletions).
-			score = float64(len(tables)) / float64(v.s.o.GetCompactionL0Trigger2()) // 文件个数/4
+			score = float64(len(tables)) / float64(v.s.o_s.GetCompactionL0Trigger()) // 文件个数/4
 		} else {
-			score = float64(size) / float64(v.s.o.GetCompactionTotalSize(level)) //文件的总大小/预设的每个level的文件大小总量
+			score = float64(size) / float64(v.s.o_s.GetCompactionTotalSize(level)) //文件的总大小/预设的每个level的文件大小总量
 		}
 
 		if score > bestScore {
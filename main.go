@@ -93,7 +93,16 @@ func headerKey(hash common.Hash, number uint64) []byte {
 }
 
 func GetHeaderRLP(db *myethdb.LDBDatabase, hash common.Hash, number uint64) rlp.RawValue {
-	data, _ := db.Get(headerKey(hash, number))
+	// First try to look up the data in ancient database. Extra hash
+	// comparison is necessary since ancient database only maintains
+	// the canonical data.
+	var data []byte
+	if h, _ := db.Ancient("hashes", number); common.BytesToHash(h) == hash {
+		data, _ = db.Ancient("headers", number)
+	}
+	if len(data) == 0 {
+		data, _ = db.Get(headerKey(hash, number))
+	}
 	return data
 }
 func GetHeader(db *myethdb.LDBDatabase, hash common.Hash, number uint64) *types.Header {
@@ -142,6 +151,15 @@ func headerHashKey(number uint64) []byte {
 	return append(append(headerPrefix, encodeBlockNumber(number)...), headerHashSuffix...)
 }
 
+// ReadCanonicalHash retrieves the hash assigned to a canonical block number.
+func ReadCanonicalHash(db *myethdb.LDBDatabase, number uint64) common.Hash {
+	data, _ := db.Ancient("hashes", number)
+	if len(data) == 0 {
+		data, _ = db.Get(headerHashKey(number))
+	}
+	return common.BytesToHash(data)
+}
+
 func blockBodyKey(hash common.Hash, number uint64) []byte {
 	return append(append(blockBodyPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
 }
@@ -152,7 +170,12 @@ func ReadBodyRLP(db *myethdb.LDBDatabase, hash common.Hash, number uint64) rlp.R
 	// comparison is necessary since ancient database only maintains
 	// the canonical data.
 	var data []byte
-	data, _ = db.Get(blockBodyKey(hash, number))
+	if h, _ := db.Ancient("hashes", number); common.BytesToHash(h) == hash {
+		data, _ = db.Ancient("bodies", number)
+	}
+	if len(data) == 0 {
+		data, _ = db.Get(blockBodyKey(hash, number))
+	}
 	return data
 }
 
@@ -180,13 +203,12 @@ func GetTransaction(db *myethdb.LDBDatabase, hash common.Hash) (*types.Transacti
 	//fmt.Println("blocknumber:",blockNumber)
 
 	t3 := time.Now()
-	headerhash := headerHashKey(blockNumber) // prefix + num + suffix --> hash
-	// 取区块hash
-	blkhash3, _ := db.Get(headerhash)
+	// 取区块hash, prefix + num + suffix --> hash
+	blkhash3 := ReadCanonicalHash(db, blockNumber)
 	t4 := time.Now()
 	tt2 += t4.Sub(t3).Seconds()
 
-	body := ReadBody(db, common.BytesToHash(blkhash3), blockNumber) // b + num + hash --> body
+	body := ReadBody(db, blkhash3, blockNumber) // b + num + hash --> body
 
 	t5 := time.Now()
 	tt3 += t5.Sub(t4).Seconds()
@@ -199,7 +221,7 @@ func GetTransaction(db *myethdb.LDBDatabase, hash common.Hash) (*types.Transacti
 	for txIndex, tx := range body.Transactions {
 		if tx.Hash() == hash {
 			fmt.Printf("success get tx\n")
-			return tx, common.BytesToHash(blkhash3), blockNumber, uint64(txIndex)
+			return tx, blkhash3, blockNumber, uint64(txIndex)
 		}
 	}
 	//log.Error("Transaction not found", "number", blockNumber, "hash", blkhash3, "txhash", hash)